@@ -0,0 +1,38 @@
+// restore writes the items of a tinyamodb db live at a point in time into a new db.
+// the db is read without writing to it, so it may be served by another process.
+//
+//	restore -src /tmp/tinyamodb -dst /tmp/restored -time 2024-01-02T15:04:05Z
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/yyyoichi/tinyamodb/tinyamodb"
+)
+
+func main() {
+	src := flag.String("src", "", "directory of the db to restore")
+	dst := flag.String("dst", "", "new directory of the restored db")
+	at := flag.String("time", "", "point in time to restore in RFC 3339. empty is now")
+	flag.Parse()
+	if *src == "" || *dst == "" {
+		fmt.Fprintln(os.Stderr, "usage: restore -src <dir> -dst <dir> [-time <RFC 3339>]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	t := time.Now()
+	if *at != "" {
+		var err error
+		if t, err = time.Parse(time.RFC3339Nano, *at); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+	if err := tinyamodb.RestoreToPointInTime(*src, *dst, t); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
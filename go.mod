@@ -4,13 +4,13 @@ go 1.22.2
 
 require (
 	github.com/aws/aws-sdk-go-v2/service/dynamodb v1.32.3
+	github.com/aws/smithy-go v1.20.2
 	github.com/stretchr/testify v1.9.0
 )
 
 require gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 
 require (
-	github.com/aws/smithy-go v1.20.2 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -0,0 +1,220 @@
+package tinyamodb
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/yyyoichi/tinyamodb/tinyamodb/expression"
+)
+
+const (
+	maxBatchGetItems   = 100
+	maxBatchWriteItems = 25
+)
+
+var (
+	ErrBatchSize           = errors.New("too many or no items requested in a batch")
+	ErrDuplicateKeys       = errors.New("provided list of item keys contains duplicates")
+	ErrInvalidWriteRequest = errors.New("write request must have either PutRequest or DeleteRequest")
+)
+
+// BatchGetItem reads the items of the keys.
+// the keys are grouped by partition and the partitions are read concurrently.
+// the keys not read before ctx is done are returned as UnprocessedKeys.
+func (db *Db) BatchGetItem(ctx context.Context, input *BatchGetItemInput) (*BatchGetItemOutput, error) {
+	var n int
+	for _, ka := range input.RequestItems {
+		n += len(ka.Keys)
+	}
+	if n == 0 || n > maxBatchGetItems {
+		return nil, ErrBatchSize
+	}
+
+	type request struct {
+		table string
+		key   map[string]types.AttributeValue
+		item  *tinyamodbItem
+	}
+	groups := make(map[*partition][]request)
+	projs := make(map[string]*expression.Projection, len(input.RequestItems))
+	expiries := make(map[string]expiry, len(input.RequestItems))
+	for name, ka := range input.RequestItems {
+		t, err := db.table(name)
+		if err != nil {
+			return nil, err
+		}
+		defer t.release()
+		proj, attributes, err := parseProjection(t, stringValue(ka.ProjectionExpression), ka.ExpressionAttributeNames, nil)
+		if err != nil {
+			return nil, err
+		}
+		projs[name] = proj
+		expiries[name] = t.expiry(attributes)
+		seen := make(map[string]struct{}, len(ka.Keys))
+		for _, key := range ka.Keys {
+			item, err := NewTinyamoDbItem(key, t.c)
+			if err != nil {
+				return nil, err
+			}
+			if _, found := seen[item.strSha256Key]; found {
+				return nil, ErrDuplicateKeys
+			}
+			seen[item.strSha256Key] = struct{}{}
+			p := t.determinePartition(item.sha256PartitionKey)
+			groups[p] = append(groups[p], request{table: name, key: key, item: newReadItem(item, attributes)})
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		output = &BatchGetItemOutput{Responses: make(map[string][]map[string]types.AttributeValue, len(input.RequestItems))}
+		errs   []error
+	)
+	for name := range input.RequestItems {
+		output.Responses[name] = []map[string]types.AttributeValue{}
+	}
+	for p, requests := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			items := make([]*tinyamodbItem, len(requests))
+			for i, r := range requests {
+				items[i] = r.item
+			}
+			n, err := p.ReadBatch(ctx, items)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			for i, item := range items[:n] {
+				name := requests[i].table
+				if item.Item == nil || expiries[name].expired(item.Item) {
+					continue
+				}
+				if proj := projs[name]; proj != nil {
+					output.Responses[name] = append(output.Responses[name], proj.Apply(item.Item))
+				} else {
+					output.Responses[name] = append(output.Responses[name], item.Item)
+				}
+			}
+			for _, r := range requests[n:] {
+				if output.UnprocessedKeys == nil {
+					output.UnprocessedKeys = make(map[string]types.KeysAndAttributes)
+				}
+				ka, found := output.UnprocessedKeys[r.table]
+				if !found {
+					ka = input.RequestItems[r.table]
+					ka.Keys = nil
+				}
+				ka.Keys = append(ka.Keys, r.key)
+				output.UnprocessedKeys[r.table] = ka
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return output, nil
+}
+
+// BatchWriteItem puts and deletes the items.
+// the requests are grouped by partition and each partition writes its requests in one locked pass.
+// the requests not written before ctx is done are returned as UnprocessedItems.
+// if a partition fails, the error is returned with the output having the requests not written.
+func (db *Db) BatchWriteItem(ctx context.Context, input *BatchWriteItemInput) (*BatchWriteItemOutput, error) {
+	var n int
+	for _, requests := range input.RequestItems {
+		n += len(requests)
+	}
+	if n == 0 || n > maxBatchWriteItems {
+		return nil, ErrBatchSize
+	}
+
+	type request struct {
+		table  string
+		origin types.WriteRequest
+		writeRequest
+	}
+	groups := make(map[*partition][]request)
+	for name, requests := range input.RequestItems {
+		t, err := db.table(name)
+		if err != nil {
+			return nil, err
+		}
+		defer t.release()
+		seen := make(map[string]struct{}, len(requests))
+		for _, r := range requests {
+			var item *tinyamodbItem
+			switch {
+			case r.PutRequest != nil && r.DeleteRequest == nil:
+				item, err = NewTinyamoDbItem(r.PutRequest.Item, t.c)
+			case r.PutRequest == nil && r.DeleteRequest != nil:
+				item, err = NewTinyamoDbItem(r.DeleteRequest.Key, t.c)
+			default:
+				return nil, ErrInvalidWriteRequest
+			}
+			if err != nil {
+				return nil, err
+			}
+			if _, found := seen[item.strSha256Key]; found {
+				return nil, ErrDuplicateKeys
+			}
+			seen[item.strSha256Key] = struct{}{}
+			p := t.determinePartition(item.sha256PartitionKey)
+			groups[p] = append(groups[p], request{
+				table:        name,
+				origin:       r,
+				writeRequest: writeRequest{item: item, delete: r.DeleteRequest != nil},
+			})
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		output = &BatchWriteItemOutput{}
+		errs   []error
+	)
+	for p, requests := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			writes := make([]writeRequest, len(requests))
+			for i, r := range requests {
+				writes[i] = r.writeRequest
+			}
+			n, err := p.WriteBatch(ctx, writes)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+			}
+			for _, r := range requests[n:] {
+				if output.UnprocessedItems == nil {
+					output.UnprocessedItems = make(map[string][]types.WriteRequest)
+				}
+				output.UnprocessedItems[r.table] = append(output.UnprocessedItems[r.table], r.origin)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		// the output has the requests not written, including the failed ones.
+		return output, errors.Join(errs...)
+	}
+	return output, nil
+}
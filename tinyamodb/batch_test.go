@@ -0,0 +1,242 @@
+package tinyamodb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+// deadlineContext is done after Err is called n times.
+type deadlineContext struct {
+	context.Context
+	n atomic.Int32
+}
+
+func (ctx *deadlineContext) Err() error {
+	if ctx.n.Add(-1) < 0 {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+func TestBatchGetItem(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-batch-get")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.Name = "users"
+	c.Table.PartitionKey = "pk"
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+
+	key := func(i int) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%d", i)}}
+	}
+	var want []map[string]types.AttributeValue
+	for i := range 80 {
+		item := key(i)
+		item["n"] = &types.AttributeValueMemberN{Value: fmt.Sprint(i)}
+		item["a"] = &types.AttributeValueMemberS{Value: "a"}
+		_, err := db.PutItem(context.Background(), &PutItemInput{Item: item})
+		require.NoError(t, err)
+		want = append(want, item)
+	}
+
+	// 20 keys are not found
+	var keys []map[string]types.AttributeValue
+	for i := range 100 {
+		keys = append(keys, key(i))
+	}
+	batchGet := func(ctx context.Context, keys []map[string]types.AttributeValue) (*BatchGetItemOutput, error) {
+		return db.BatchGetItem(ctx, &BatchGetItemInput{RequestItems: map[string]types.KeysAndAttributes{
+			"users": {Keys: keys},
+		}})
+	}
+	output, err := batchGet(context.Background(), keys)
+	require.NoError(t, err)
+	require.ElementsMatch(t, want, output.Responses["users"])
+	require.Empty(t, output.UnprocessedKeys)
+
+	proj := "n"
+	output, err = db.BatchGetItem(context.Background(), &BatchGetItemInput{RequestItems: map[string]types.KeysAndAttributes{
+		"users": {Keys: keys[:2], ProjectionExpression: &proj},
+	}})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []map[string]types.AttributeValue{
+		{"n": &types.AttributeValueMemberN{Value: "0"}},
+		{"n": &types.AttributeValueMemberN{Value: "1"}},
+	}, output.Responses["users"])
+
+	// multiple tables
+	_, err = db.CreateTable(context.Background(), &CreateTableInput{
+		TableName:            "groups",
+		KeySchema:            []types.KeySchemaElement{{AttributeName: &c.Table.PartitionKey, KeyType: types.KeyTypeHash}},
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: &c.Table.PartitionKey, AttributeType: types.ScalarAttributeTypeS}},
+	})
+	require.NoError(t, err)
+	_, err = db.PutItem(context.Background(), &PutItemInput{TableName: "groups", Item: key(0)})
+	require.NoError(t, err)
+	output, err = db.BatchGetItem(context.Background(), &BatchGetItemInput{RequestItems: map[string]types.KeysAndAttributes{
+		"users":  {Keys: keys[:2], ProjectionExpression: &proj},
+		"groups": {Keys: keys[:2]},
+	}})
+	require.NoError(t, err)
+	require.Len(t, output.Responses["users"], 2)
+	require.Equal(t, []map[string]types.AttributeValue{key(0)}, output.Responses["groups"])
+
+	// the deadline hits after 30 keys are read
+	ctx := &deadlineContext{Context: context.Background()}
+	ctx.n.Store(1 + 30)
+	output, err = batchGet(ctx, keys[:80])
+	require.NoError(t, err)
+	require.NotEmpty(t, output.UnprocessedKeys["users"].Keys)
+	require.Len(t, output.Responses["users"], 80-len(output.UnprocessedKeys["users"].Keys))
+	output2, err := db.BatchGetItem(context.Background(), &BatchGetItemInput{RequestItems: output.UnprocessedKeys})
+	require.NoError(t, err)
+	require.Empty(t, output2.UnprocessedKeys)
+	require.ElementsMatch(t, want, append(output.Responses["users"], output2.Responses["users"]...))
+
+	// invalid requests
+	_, err = batchGet(context.Background(), append(keys, key(100)))
+	require.ErrorIs(t, err, ErrBatchSize)
+	_, err = db.BatchGetItem(context.Background(), &BatchGetItemInput{})
+	require.ErrorIs(t, err, ErrBatchSize)
+	_, err = batchGet(context.Background(), append(keys[:2:2], key(0)))
+	require.ErrorIs(t, err, ErrDuplicateKeys)
+	_, err = db.BatchGetItem(context.Background(), &BatchGetItemInput{RequestItems: map[string]types.KeysAndAttributes{
+		"unknown": {Keys: keys[:1]},
+	}})
+	require.ErrorIs(t, err, ErrTableNotFound)
+}
+
+func TestBatchWriteItem(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-batch-write")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.Name = "orders"
+	c.Table.PartitionKey = "pk"
+	c.Table.SortKey = "sk"
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+
+	key := func(pk, sk int) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%d", pk)},
+			"sk": &types.AttributeValueMemberS{Value: fmt.Sprintf("%d", sk)},
+		}
+	}
+	put := func(pk, sk int, v string) types.WriteRequest {
+		item := key(pk, sk)
+		item["v"] = &types.AttributeValueMemberS{Value: v}
+		return types.WriteRequest{PutRequest: &types.PutRequest{Item: item}}
+	}
+	del := func(pk, sk int) types.WriteRequest {
+		return types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: key(pk, sk)}}
+	}
+	scan := func() []map[string]types.AttributeValue {
+		output, err := db.Scan(context.Background(), &ScanInput{TableName: "orders"})
+		require.NoError(t, err)
+		return output.Items
+	}
+	batchWrite := func(ctx context.Context, requests []types.WriteRequest) (*BatchWriteItemOutput, error) {
+		return db.BatchWriteItem(ctx, &BatchWriteItemInput{RequestItems: map[string][]types.WriteRequest{
+			"orders": requests,
+		}})
+	}
+
+	var requests []types.WriteRequest
+	for i := range 25 {
+		requests = append(requests, put(i%5, i/5, "1"))
+	}
+	output, err := batchWrite(context.Background(), requests)
+	require.NoError(t, err)
+	require.Empty(t, output.UnprocessedItems)
+	require.Len(t, scan(), 25)
+
+	// mixed requests
+	output, err = batchWrite(context.Background(), []types.WriteRequest{
+		put(0, 0, "2"), del(0, 1), del(1, 0), put(9, 0, "2"), del(9, 9),
+	})
+	require.NoError(t, err)
+	require.Empty(t, output.UnprocessedItems)
+	require.Len(t, scan(), 24)
+	got, err := db.GetItem(context.Background(), &GetItemInput{TableName: "orders", Key: key(0, 0)})
+	require.NoError(t, err)
+	require.Equal(t, put(0, 0, "2").PutRequest.Item, got.Item)
+	got, err = db.GetItem(context.Background(), &GetItemInput{TableName: "orders", Key: key(0, 1)})
+	require.NoError(t, err)
+	require.Nil(t, got.Item)
+
+	// the deadline hits after 10 requests are written
+	requests = requests[:0]
+	for i := range 25 {
+		requests = append(requests, put(i, 100, "3"))
+	}
+	ctx := &deadlineContext{Context: context.Background()}
+	ctx.n.Store(1 + 10)
+	output, err = batchWrite(ctx, requests)
+	require.NoError(t, err)
+	require.Len(t, output.UnprocessedItems["orders"], 15)
+	require.Len(t, scan(), 24+10)
+	output, err = db.BatchWriteItem(context.Background(), &BatchWriteItemInput{RequestItems: output.UnprocessedItems})
+	require.NoError(t, err)
+	require.Empty(t, output.UnprocessedItems)
+	require.Len(t, scan(), 24+25)
+
+	// invalid requests
+	_, err = batchWrite(context.Background(), append(requests, put(0, 0, "4")))
+	require.ErrorIs(t, err, ErrBatchSize)
+	_, err = batchWrite(context.Background(), []types.WriteRequest{put(0, 0, "4"), del(0, 0)})
+	require.ErrorIs(t, err, ErrDuplicateKeys)
+	_, err = batchWrite(context.Background(), []types.WriteRequest{{}})
+	require.ErrorIs(t, err, ErrInvalidWriteRequest)
+	_, err = batchWrite(context.Background(), []types.WriteRequest{
+		{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: "USER#0"}}}},
+	})
+	require.ErrorIs(t, err, ErrNotFoundSortKey)
+
+	// a partition fails after a request is written
+	s := func(v string) *string { return &v }
+	_, err = db.CreateTable(context.Background(), &CreateTableInput{
+		TableName: "indexed",
+		KeySchema: []types.KeySchemaElement{{AttributeName: s("pk"), KeyType: types.KeyTypeHash}},
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: s("pk"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: s("status"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName:  s("status"),
+			KeySchema:  []types.KeySchemaElement{{AttributeName: s("status"), KeyType: types.KeyTypeHash}},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		}},
+		PartitionNum: 1,
+	})
+	require.NoError(t, err)
+	indexed := func(pk string, status types.AttributeValue) types.WriteRequest {
+		return types.WriteRequest{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{
+			"pk":     &types.AttributeValueMemberS{Value: pk},
+			"status": status,
+		}}}
+	}
+	// the status of N cannot be indexed
+	failed := indexed("B", &types.AttributeValueMemberN{Value: "1"})
+	output, err = db.BatchWriteItem(context.Background(), &BatchWriteItemInput{RequestItems: map[string][]types.WriteRequest{
+		"indexed": {indexed("A", &types.AttributeValueMemberS{Value: "open"}), failed},
+	}})
+	require.ErrorIs(t, err, ErrInvalidPartitionKeyType)
+	require.Equal(t, map[string][]types.WriteRequest{"indexed": {failed}}, output.UnprocessedItems)
+}
@@ -1,14 +1,47 @@
 package tinyamodb
 
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
 type Config struct {
+	// Partition is the partition num of the tables created. the default is 10.
 	Partition struct {
 		Num uint8
 	}
+	// Segment is the segment limits of the tables created. the table of Config.Table is checked against them,
+	// and the other tables keep the limits they are created with.
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 	}
+	// Table is the schema of the table created by New. it is optional
+	// when the tables are created by CreateTable.
 	Table struct {
+		// Name is the name of the table. empty is "default".
+		// the items of the table are addressed by an empty TableName.
+		Name         string
 		PartitionKey string
+		// PartitionKeyType is S, N or B. empty is S.
+		PartitionKeyType types.ScalarAttributeType
+		// SortKey is optional. When set, items are addressed by
+		// the pair of PartitionKey and SortKey.
+		SortKey string
+		// SortKeyType is S, N or B. empty is S.
+		SortKeyType types.ScalarAttributeType
+	}
+	Stream struct {
+		// Retention is how long the records of the streams are retained. the default is 24 hours.
+		Retention time.Duration
+		// MaxSegmentBytes is the size at which the log files of the streams are rotated. the default is 1 MiB.
+		// the retention removes the records by the files.
+		MaxSegmentBytes uint64
+	}
+	TimeToLive struct {
+		// SweepInterval is the interval of the sweeps deleting the expired items.
+		// the default is 1 minute, and negative disables the sweeps.
+		SweepInterval time.Duration
 	}
 }
@@ -2,21 +2,51 @@ package tinyamodb
 
 import (
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/yyyoichi/tinyamodb/tinyamodb/expression"
+)
+
+var (
+	ErrInvalidKeyCondition = errors.New("invalid key condition")
+	ErrInvalidStartKey     = errors.New("invalid exclusive start key")
+	ErrInvalidSegment      = errors.New("invalid segment")
+	ErrUpdateKeyAttribute  = errors.New("cannot update key attributes")
+	ErrInvalidReturnValues = errors.New("invalid return values")
+	ErrFilterKeyAttribute  = errors.New("filter expression can only contain non-primary key attributes")
+	ErrInvalidKeyType      = errors.New("key type must be S, N or B")
 )
 
 type Db struct {
-	// partition id start with 1
-	partitions map[int]*partition
-	c          Config
+	dir string
+	c   Config
+
+	mu     sync.RWMutex
+	tables map[string]*table
+	txns   *transactions
+
+	// done is closed to stop the sweeper of the expired items.
+	done    chan struct{}
+	sweeper sync.WaitGroup
 }
 
+// New opens the tables in the children dirs of dir.
+// the table of c.Table is created if c.Table.PartitionKey is set and the table does not exist.
+// it returns ErrConfigMismatch if c conflicts with the metadata of the tables. the fields of c not set
+// are filled from the metadata.
+// the items of the table are addressed by an empty TableName.
 func New(dir string, c Config) (*Db, error) {
+	if err := validateKeyTypes(c); err != nil {
+		return nil, err
+	}
 	if _, err := os.Stat(dir); err != nil {
 		if err = os.Mkdir(dir, 0755); err != nil {
 			return nil, err
@@ -24,116 +54,773 @@ func New(dir string, c Config) (*Db, error) {
 	}
 
 	db := &Db{
-		partitions: make(map[int]*partition),
-		c:          c,
+		dir:    dir,
+		c:      c,
+		tables: make(map[string]*table),
 	}
-
-	// read from children dir.
-	// cannot change partition num after init the database.
 	children, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
+	var legacy []string
+	resume := false
 	for _, child := range children {
+		name := child.Name()
 		if !child.IsDir() {
 			continue
 		}
-		name := child.Name()
-		id, _ := strconv.Atoi(name)
-		if id == 0 {
+		if !isTableDir(filepath.Join(dir, name)) {
+			if id, _ := strconv.Atoi(name); id > 0 {
+				// the partitions were in dir before the tables.
+				legacy = append(legacy, name)
+			}
+			// the migration may be interrupted before the metadata is written.
+			resume = resume || name == db.defaultTableName()
 			continue
 		}
-		db.partitions[id], err = newPartition(dir, id, c)
+		schema := c.Table.PartitionKey != "" && name == db.defaultTableName()
+		db.tables[name], err = openTable(dir, name, c, schema)
 		if err != nil {
 			return nil, err
 		}
 	}
-
-	if l := len(db.partitions); l == 0 {
-		// create
-		if c.Partition.Num == 0 {
-			c.Partition.Num = 10
+	if len(legacy) > 0 || resume {
+		migrated, err := db.migrate(legacy)
+		if err != nil {
+			return nil, err
 		}
-		for i := 1; i <= int(c.Partition.Num); i++ {
-			db.partitions[i], err = newPartition(dir, i, c)
-			if err != nil {
+		if migrated {
+			name := db.defaultTableName()
+			if db.tables[name], err = openTable(dir, name, c, true); err != nil {
 				return nil, err
 			}
 		}
-	} else {
-		// serial
-		for i := 1; i <= l; i++ {
-			_, found := db.partitions[i]
-			if !found {
-				return nil, fmt.Errorf("unexpected error: partition '%d' is not found", i)
+	}
+	if c.Table.PartitionKey != "" {
+		name := db.defaultTableName()
+		if _, found := db.tables[name]; !found {
+			db.tables[name], err = createTable(dir, name, c, nil, nil, "")
+			if err != nil {
+				return nil, err
 			}
 		}
 	}
 
+	db.txns, err = newTransactions(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.recoverTransactions(); err != nil {
+		return nil, err
+	}
+
+	db.done = make(chan struct{})
+	if interval := c.TimeToLive.SweepInterval; interval >= 0 {
+		if interval == 0 {
+			interval = defaultSweepInterval
+		}
+		db.sweeper.Add(1)
+		go db.sweepExpired(interval)
+	}
 	return db, nil
 }
 
+// migrate moves the partitions written in dir before the tables, whose names are legacy, into the table of Config.Table,
+// and reports whether the table is migrated. the partitions are moved before the metadata is written,
+// so an interrupted migration is resumed on the next open.
+func (db *Db) migrate(legacy []string) (bool, error) {
+	name := db.defaultTableName()
+	if db.c.Table.PartitionKey == "" {
+		if len(legacy) == 0 {
+			return false, nil
+		}
+		return false, fmt.Errorf("%w: partitions in '%s' have no table metadata. open with Config.Table to migrate them into table '%s'", ErrTableFormat, db.dir, name)
+	}
+	tdir := filepath.Join(db.dir, name)
+	if err := os.MkdirAll(tdir, 0755); err != nil {
+		return false, err
+	}
+	for _, id := range legacy {
+		if err := os.Rename(filepath.Join(db.dir, id), filepath.Join(tdir, id)); err != nil {
+			return false, err
+		}
+	}
+	// the partition num is the num of the partitions written, as it was before the tables.
+	children, err := os.ReadDir(tdir)
+	if err != nil {
+		return false, err
+	}
+	var n int
+	for _, child := range children {
+		if id, _ := strconv.Atoi(child.Name()); id > 0 && child.IsDir() {
+			n++
+		}
+	}
+	if n == 0 {
+		// not a migration
+		return false, nil
+	}
+	for i := 1; i <= n; i++ {
+		if _, err := os.Stat(filepath.Join(tdir, strconv.Itoa(i))); err != nil {
+			return false, fmt.Errorf("unexpected error: partition '%d' is not found", i)
+		}
+	}
+	c := db.c
+	c.Partition.Num = uint8(n)
+	return true, writeTableMetadata(tdir, newTableMetadata(c, nil, nil, ""))
+}
+
+func (db *Db) defaultTableName() string {
+	if db.c.Table.Name != "" {
+		return db.c.Table.Name
+	}
+	return defaultTableName
+}
+
+// table returns the table of the name in use. empty name is the table of Config.Table.
+// the caller must release the table after the use.
+func (db *Db) table(name string) (*table, error) {
+	if name == "" {
+		name = db.defaultTableName()
+	}
+	db.mu.RLock()
+	t, found := db.tables[name]
+	db.mu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("%w: '%s'", ErrTableNotFound, name)
+	}
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// recoverTransactions completes the writes of the transactions not committed before a crash.
+// the writes done before the crash are not written again, and the writes which cannot be written are skipped.
+func (db *Db) recoverTransactions() error {
+	writes, err := db.txns.Recover()
+	if err != nil {
+		return err
+	}
+	var requests []transactRequest
+	for _, w := range writes {
+		t, found := db.tables[w.table]
+		if !found {
+			// the table is deleted.
+			continue
+		}
+		item, err := NewTinyamoDbItem(w.item, t.c)
+		if err == nil && !w.delete {
+			err = t.determinePartition(item.sha256PartitionKey).check(item)
+		}
+		if err != nil {
+			// never written. it is skipped not to fail every open.
+			continue
+		}
+		item.UnixNano = w.unixNano
+		p := t.determinePartition(item.sha256PartitionKey)
+		p.mu.Lock()
+		err = p.recover(writeRequest{item: item, delete: w.delete})
+		p.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		requests = append(requests, transactRequest{t: t, writeRequest: writeRequest{item: item}})
+	}
+	// the writes are on the disk before the log is truncated.
+	// nothing else uses the db while it is opened.
+	if err := syncTransaction(requests); err != nil {
+		return err
+	}
+	return db.txns.Reset()
+}
+
 func (db *Db) Close() error {
-	for _, p := range db.partitions {
-		if err := p.Close(); err != nil {
+	select {
+	case <-db.done:
+	default:
+		close(db.done)
+	}
+	db.sweeper.Wait()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, t := range db.tables {
+		if err := t.Close(); err != nil {
 			return err
 		}
 	}
-	return nil
+	return db.txns.Close()
 }
 
 func (db *Db) GetItem(ctx context.Context, input *GetItemInput) (*GetItemOutput, error) {
-	item, err := NewTinyamoDbItem(input.Key, db.c)
+	t, err := db.table(input.TableName)
 	if err != nil {
 		return nil, err
 	}
-	p := db.determinePartition(item.sha256Key)
+	defer t.release()
+	item, err := NewTinyamoDbItem(input.Key, t.c)
+	if err != nil {
+		return nil, err
+	}
+	proj, attributes, err := parseProjection(t, input.ProjectionExpression, input.ExpressionAttributeNames, nil)
+	if err != nil {
+		return nil, err
+	}
+	e := t.expiry(attributes)
+	p := t.determinePartition(item.sha256PartitionKey)
 
-	output := &tinyamodbItem{
-		sha256Key:    item.sha256Key,
-		strSha256Key: item.strSha256Key,
-		UnixNano:     0,
-		Item:         nil,
+	output := newReadItem(item, attributes)
+	if input.AsOf.IsZero() {
+		err = p.Read(output)
+	} else {
+		// the items expired at the time are hidden
+		e.now = input.AsOf.Unix()
+		err = p.ReadAsOf(output, input.AsOf.UnixNano())
 	}
-	err = p.Read(output)
 	if err != nil && !errors.Is(err, io.EOF) {
 		if errors.Is(err, io.EOF) {
 			return &GetItemOutput{Item: nil}, nil
 		}
 		return nil, err
 	}
+	if e.expired(output.Item) {
+		return &GetItemOutput{Item: nil}, nil
+	}
+	if proj != nil && output.Item != nil {
+		output.Item = proj.Apply(output.Item)
+	}
 	return &GetItemOutput{Item: output.Item}, nil
 }
 
+// newReadItem returns an empty item having the key of the item to read into.
+// attributes are the top level attributes to decode. nil decodes all.
+func newReadItem(key *tinyamodbItem, attributes map[string]struct{}) *tinyamodbItem {
+	return &tinyamodbItem{
+		sha256Key:             key.sha256Key,
+		strSha256Key:          key.strSha256Key,
+		sha256PartitionKey:    key.sha256PartitionKey,
+		strSha256PartitionKey: key.strSha256PartitionKey,
+		sortKey:               key.sortKey,
+		decodeAttributes:      attributes,
+	}
+}
+
 func (db *Db) PutItem(ctx context.Context, input *PutItemInput) (*PutItemOutput, error) {
-	item, err := NewTinyamoDbItem(input.Item, db.c)
+	t, err := db.table(input.TableName)
 	if err != nil {
 		return nil, err
 	}
-	p := db.determinePartition(item.sha256Key)
-	_, err = p.Put(item)
+	defer t.release()
+	item, err := NewTinyamoDbItem(input.Item, t.c)
 	if err != nil {
 		return nil, err
 	}
-	return &PutItemOutput{}, nil
+	if err := validateReturnValues(input.ReturnValues, false); err != nil {
+		return nil, err
+	}
+	cond, err := parseCondition(input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	p := t.determinePartition(item.sha256PartitionKey)
+	var old Item
+	if cond == nil {
+		old, err = p.Put(item)
+	} else {
+		old, err = p.Update(item, func(old Item) (Item, error) {
+			if err := checkCondition(cond, old, input.ReturnValuesOnConditionCheckFailure); err != nil {
+				return nil, err
+			}
+			return item, nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	output := &PutItemOutput{}
+	if input.ReturnValues == types.ReturnValueAllOld && old != nil {
+		output.Attributes = old.(*tinyamodbItem).Item
+	}
+	return output, nil
+}
+
+// UpdateItem edits the attributes of the item by the update expression, or creates the item if absent.
+// the item is read and written under the lock of the partition.
+func (db *Db) UpdateItem(ctx context.Context, input *UpdateItemInput) (*UpdateItemOutput, error) {
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	defer t.release()
+	key, err := NewTinyamoDbItem(input.Key, t.c)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateReturnValues(input.ReturnValues, true); err != nil {
+		return nil, err
+	}
+	cond, err := parseCondition(input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	var update *expression.Update
+	if input.UpdateExpression != "" {
+		update, err = expression.ParseUpdate(
+			input.UpdateExpression,
+			input.ExpressionAttributeNames,
+			input.ExpressionAttributeValues,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p := t.determinePartition(key.sha256PartitionKey)
+	var item *tinyamodbItem
+	old, err := p.Update(key, func(old Item) (Item, error) {
+		if err := checkCondition(cond, old, input.ReturnValuesOnConditionCheckFailure); err != nil {
+			return nil, err
+		}
+		item, err = t.applyUpdate(key, update, old)
+		return item, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	output := &UpdateItemOutput{}
+	switch input.ReturnValues {
+	case types.ReturnValueAllOld:
+		if old != nil {
+			output.Attributes = old.(*tinyamodbItem).Item
+		}
+	case types.ReturnValueUpdatedOld:
+		if old != nil && update != nil {
+			output.Attributes = update.Updated(old.(*tinyamodbItem).Item)
+		}
+	case types.ReturnValueAllNew:
+		output.Attributes = item.Item
+	case types.ReturnValueUpdatedNew:
+		if update != nil {
+			output.Attributes = update.Updated(item.Item)
+		}
+	}
+	return output, nil
+}
+
+// applyUpdate returns the item updated by the update expression.
+// the item is created from the key when old is nil. nil update returns the item as it is.
+func (t *table) applyUpdate(key *tinyamodbItem, update *expression.Update, old Item) (*tinyamodbItem, error) {
+	current := keyAttributes(key.Item, t.c)
+	if old != nil {
+		current = old.(*tinyamodbItem).Item
+	}
+	updated := current
+	if update != nil {
+		v, err := update.Apply(current)
+		if err != nil {
+			return nil, err
+		}
+		updated = v
+	}
+	item, err := NewTinyamoDbItem(updated, t.c)
+	if err != nil || item.strSha256Key != key.strSha256Key {
+		return nil, ErrUpdateKeyAttribute
+	}
+	return item, nil
 }
 
 func (db *Db) DeleteItem(ctx context.Context, input *DeleteItemInput) (*DeleteItemOutput, error) {
-	item, err := NewTinyamoDbItem(input.Key, db.c)
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	defer t.release()
+	item, err := NewTinyamoDbItem(input.Key, t.c)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateReturnValues(input.ReturnValues, false); err != nil {
+		return nil, err
+	}
+	cond, err := parseCondition(input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
 	if err != nil {
 		return nil, err
 	}
-	p := db.determinePartition(item.sha256Key)
-	_, err = p.Delete(item)
+	p := t.determinePartition(item.sha256PartitionKey)
+	var old Item
+	if cond == nil {
+		old, err = p.Delete(item)
+	} else {
+		old, err = p.Update(item, func(old Item) (Item, error) {
+			if err := checkCondition(cond, old, input.ReturnValuesOnConditionCheckFailure); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		})
+	}
 	if err != nil && !errors.Is(err, io.EOF) {
 		return nil, err
 	}
-	return &DeleteItemOutput{}, nil
+	output := &DeleteItemOutput{}
+	if input.ReturnValues == types.ReturnValueAllOld && old != nil {
+		output.Attributes = old.(*tinyamodbItem).Item
+	}
+	return output, nil
+}
+
+// validateReturnValues validates ReturnValues. UPDATED_OLD, ALL_NEW and UPDATED_NEW are only for UpdateItem.
+func validateReturnValues(rv types.ReturnValue, update bool) error {
+	switch rv {
+	case "", types.ReturnValueNone, types.ReturnValueAllOld:
+		return nil
+	case types.ReturnValueUpdatedOld, types.ReturnValueAllNew, types.ReturnValueUpdatedNew:
+		if update {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: '%s'", ErrInvalidReturnValues, rv)
+}
+
+// parseProjection parses the projection expression. it also returns the top level attributes to decode
+// which includes the key attributes of ks for LastEvaluatedKey and the attributes of the filter.
+// it returns nil if the expression is empty.
+func parseProjection(ks keySpace, expr string, names map[string]string, filter *expression.Condition) (*expression.Projection, map[string]struct{}, error) {
+	if expr == "" {
+		return nil, nil, nil
+	}
+	proj, err := expression.ParseProjection(expr, names)
+	if err != nil {
+		return nil, nil, err
+	}
+	attributes := make(map[string]struct{})
+	for name := range ks.keyAttributes(nil) {
+		attributes[name] = struct{}{}
+	}
+	for _, name := range proj.Attributes() {
+		attributes[name] = struct{}{}
+	}
+	if filter != nil {
+		for _, name := range filter.Attributes() {
+			attributes[name] = struct{}{}
+		}
+	}
+	return proj, attributes, nil
 }
 
-func (db *Db) determinePartition(sha256key []byte) *partition {
-	v := binary.BigEndian.Uint32(sha256key[:4])
-	id := int(v) % len(db.partitions)
-	// partition id start with 1
-	return db.partitions[id+1]
+// parseFilter parses the filter expression. it returns nil if the expression is empty.
+func parseFilter(expr string, names map[string]string, values map[string]types.AttributeValue) (*expression.Condition, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return expression.ParseFilter(expr, names, values)
+}
+
+// evaluateFilter reports whether the item passes the filter. nil filter passes all items.
+func evaluateFilter(filter *expression.Condition, item map[string]types.AttributeValue) (bool, error) {
+	if filter == nil {
+		return true, nil
+	}
+	return filter.Evaluate(item)
+}
+
+// parseCondition parses the condition expression. it returns nil if the expression is empty.
+func parseCondition(expr string, names map[string]string, values map[string]types.AttributeValue) (*expression.Condition, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return expression.ParseCondition(expr, names, values)
+}
+
+// checkCondition evaluates the condition with the current item.
+// it returns *types.ConditionalCheckFailedException if the item does not satisfy the condition.
+func checkCondition(cond *expression.Condition, old Item, rv types.ReturnValuesOnConditionCheckFailure) error {
+	if cond == nil {
+		return nil
+	}
+	var current map[string]types.AttributeValue
+	if old != nil {
+		current = old.(*tinyamodbItem).Item
+	}
+	ok, err := cond.Evaluate(current)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		msg := "The conditional request failed"
+		e := &types.ConditionalCheckFailedException{Message: &msg}
+		if rv == types.ReturnValuesOnConditionCheckFailureAllOld {
+			e.Item = current
+		}
+		return e
+	}
+	return nil
+}
+
+// Query reads the items sharing a partition key in sort key order.
+// it reads the secondary index of IndexName if set.
+func (db *Db) Query(ctx context.Context, input *QueryInput) (*QueryOutput, error) {
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	defer t.release()
+	var ks keySpace = t
+	if input.IndexName != "" {
+		if ks, err = t.index(input.IndexName); err != nil {
+			return nil, err
+		}
+	}
+	c := ks.config()
+	conds, err := expression.ParseKeyCondition(
+		input.KeyConditionExpression,
+		input.ExpressionAttributeNames,
+		input.ExpressionAttributeValues,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var pkCond, skCond *expression.KeyCondition
+	for i, cond := range conds {
+		switch {
+		case cond.Name == c.Table.PartitionKey:
+			pkCond = &conds[i]
+		case cond.Name == c.Table.SortKey && c.Table.SortKey != "":
+			skCond = &conds[i]
+		default:
+			return nil, fmt.Errorf("%w: '%s' is not a key attribute", ErrInvalidKeyCondition, cond.Name)
+		}
+	}
+	if pkCond == nil || pkCond.Operator != expression.KeyEQ {
+		return nil, fmt.Errorf("%w: partition key must be specified with '='", ErrInvalidKeyCondition)
+	}
+	if skCond != nil {
+		for _, v := range skCond.Values {
+			if _, ok := keyBytes(v, c.Table.SortKeyType); !ok {
+				return nil, ErrInvalidSortKeyType
+			}
+		}
+	}
+	pkey, strPKey, err := hashPartitionKey(pkCond.Values[0], c)
+	if err != nil {
+		return nil, err
+	}
+
+	var exclusiveStart *keyEntry
+	if input.ExclusiveStartKey != nil {
+		item, err := ks.newItem(input.ExclusiveStartKey)
+		if err != nil {
+			return nil, err
+		}
+		if item == nil || item.strSha256PartitionKey != strPKey {
+			return nil, ErrInvalidStartKey
+		}
+		e := newKeyEntry(item)
+		exclusiveStart = &e
+	}
+	forward := input.ScanIndexForward == nil || *input.ScanIndexForward
+	filter, err := parseFilter(input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		for _, name := range filter.Attributes() {
+			if name == c.Table.PartitionKey || name == c.Table.SortKey {
+				return nil, fmt.Errorf("%w: '%s'", ErrFilterKeyAttribute, name)
+			}
+		}
+	}
+	proj, attributes, err := parseProjection(ks, input.ProjectionExpression, input.ExpressionAttributeNames, filter)
+	if err != nil {
+		return nil, err
+	}
+	e := t.expiry(attributes)
+
+	// the attributes not projected into a local index are read from the table.
+	var fetch func(*tinyamodbItem) error
+	expired := func(item *tinyamodbItem) (bool, error) {
+		return e.expired(item.Item), nil
+	}
+	x, _ := ks.(*secondaryIndex)
+	if x != nil {
+		expired = x.expired(t, e)
+		var names []string
+		if proj != nil {
+			names = append(names, proj.Attributes()...)
+		}
+		if filter != nil {
+			names = append(names, filter.Attributes()...)
+		}
+		fetch = x.fetch(t, names, attributes)
+	}
+
+	p := ks.determinePartition(pkey)
+	items, limited, err := p.Query(strPKey, skCond, forward, exclusiveStart, newPage(input.Limit), attributes, fetch)
+	if err != nil {
+		return nil, err
+	}
+	output := &QueryOutput{
+		Items: make([]map[string]types.AttributeValue, 0, len(items)),
+	}
+	for _, item := range items {
+		if ok, err := expired(item); err != nil {
+			return nil, err
+		} else if ok {
+			continue
+		}
+		if ok, err := evaluateFilter(filter, item.Item); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		switch {
+		case proj != nil:
+			output.Items = append(output.Items, proj.Apply(item.Item))
+		case fetch != nil:
+			// the projected attributes of the item read for the filter.
+			output.Items = append(output.Items, x.project(item.Item))
+		default:
+			output.Items = append(output.Items, item.Item)
+		}
+	}
+	output.Count = int32(len(output.Items))
+	output.ScannedCount = int32(len(items))
+	if limited {
+		output.LastEvaluatedKey = ks.keyAttributes(items[len(items)-1].Item)
+	}
+	return output, nil
+}
+
+// Scan reads the live items of all partitions,
+// or of the segment when TotalSegments is set.
+func (db *Db) Scan(ctx context.Context, input *ScanInput) (*ScanOutput, error) {
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	defer t.release()
+	ranges, err := t.scanRanges(input.Segment, input.TotalSegments)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := parseFilter(input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	proj, attributes, err := parseProjection(t, input.ProjectionExpression, input.ExpressionAttributeNames, filter)
+	if err != nil {
+		return nil, err
+	}
+	e := t.expiry(attributes)
+	var exclusiveStart *keyEntry
+	if input.ExclusiveStartKey != nil {
+		item, err := NewTinyamoDbItem(input.ExclusiveStartKey, t.c)
+		if err != nil {
+			return nil, err
+		}
+		e := newKeyEntry(item)
+		exclusiveStart = &e
+		id := t.partitionId(item.sha256PartitionKey)
+		i := slices.IndexFunc(ranges, func(r scanRange) bool {
+			return r.id == id && r.contains(e.partitionKey)
+		})
+		if i < 0 {
+			return nil, ErrInvalidStartKey
+		}
+		ranges = ranges[i:]
+	}
+
+	output := &ScanOutput{
+		Items: []map[string]types.AttributeValue{},
+	}
+	pg := newPage(input.Limit)
+	var last *tinyamodbItem
+	for _, r := range ranges {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if pg.Full() {
+			// items may be left in the next partitions.
+			output.LastEvaluatedKey = keyAttributes(last.Item, t.c)
+			break
+		}
+		items, limited, err := t.partitions[r.id].Scan(r.lower, r.upper, exclusiveStart, pg, attributes)
+		if err != nil {
+			return nil, err
+		}
+		exclusiveStart = nil
+		output.ScannedCount += int32(len(items))
+		for _, item := range items {
+			last = item
+			if e.expired(item.Item) {
+				continue
+			}
+			if ok, err := evaluateFilter(filter, item.Item); err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+			if proj != nil {
+				output.Items = append(output.Items, proj.Apply(item.Item))
+			} else {
+				output.Items = append(output.Items, item.Item)
+			}
+		}
+		if limited {
+			output.LastEvaluatedKey = keyAttributes(last.Item, t.c)
+			break
+		}
+	}
+	output.Count = int32(len(output.Items))
+	return output, nil
+}
+
+// scanRange is the part of a partition read by a scan segment.
+type scanRange struct {
+	id int
+	// range of the partition key hash [lower, upper). empty is unbounded.
+	lower, upper string
+}
+
+func (r scanRange) contains(partitionKey string) bool {
+	return (r.lower == "" || partitionKey >= r.lower) && (r.upper == "" || partitionKey < r.upper)
+}
+
+// scanRanges returns the ranges read by the segment.
+// the partitions are shared out among the segments, and when there are more segments than partitions,
+// a partition is split into the ranges of the partition key hash.
+func (t *table) scanRanges(segment, totalSegments int32) ([]scanRange, error) {
+	if totalSegments == 0 {
+		if segment != 0 {
+			return nil, ErrInvalidSegment
+		}
+		totalSegments = 1
+	}
+	if totalSegments < 0 || segment < 0 || segment >= totalSegments {
+		return nil, ErrInvalidSegment
+	}
+
+	n, s, total := len(t.partitions), int(segment), int(totalSegments)
+	var ranges []scanRange
+	if total <= n {
+		for idx := s; idx < n; idx += total {
+			// partition id start with 1
+			ranges = append(ranges, scanRange{id: idx + 1})
+		}
+		return ranges, nil
+	}
+
+	idx := s % n
+	// the partition is read by the segments s%n == idx.
+	splits := uint64((total - idx + n - 1) / n)
+	j := uint64(s / n)
+	r := scanRange{id: idx + 1}
+	if j > 0 {
+		r.lower = fmt.Sprintf("%08x", (j<<32)/splits)
+	}
+	if j < splits-1 {
+		r.upper = fmt.Sprintf("%08x", ((j+1)<<32)/splits)
+	}
+	return append(ranges, r), nil
 }
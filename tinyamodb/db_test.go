@@ -2,11 +2,16 @@ package tinyamodb
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/yyyoichi/tinyamodb/tinyamodb/expression"
 )
 
 func TestTinyamoDb(t *testing.T) {
@@ -39,6 +44,78 @@ func TestTinyamoDb(t *testing.T) {
 	testDeleteItem(t, db)
 }
 
+func TestTinyamoDbSortKey(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-sortkey")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Partition.Num = 4
+	c.Table.PartitionKey = "pk"
+	c.Table.SortKey = "sk"
+	db, err := New(dir, c)
+	require.NoError(t, err)
+
+	item := func(pk, sk, v string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"pk":    &types.AttributeValueMemberS{Value: pk},
+			"sk":    &types.AttributeValueMemberS{Value: sk},
+			"value": &types.AttributeValueMemberS{Value: v},
+		}
+	}
+	items := []map[string]types.AttributeValue{
+		item("USER#1", "PROFILE", "taro"),
+		item("USER#1", "ORDER#2", "book"),
+		item("USER#1", "ORDER#1", "pen"),
+		item("USER#2", "PROFILE", "hanako"),
+	}
+	for _, v := range items {
+		_, err := db.PutItem(context.Background(), &PutItemInput{Item: v})
+		require.NoError(t, err)
+	}
+	for _, v := range items {
+		output, err := db.GetItem(context.Background(), &GetItemInput{Key: v})
+		require.NoError(t, err)
+		require.Equal(t, v, output.Item)
+	}
+
+	// sort key is required
+	_, err = db.GetItem(context.Background(), &GetItemInput{Key: map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: "USER#1"},
+	}})
+	require.ErrorIs(t, err, ErrNotFoundSortKey)
+
+	// stored in sort key order within a partition
+	testSortKeyOrder := func() {
+		pkey, _ := NewTinyamoDbItem(items[0], c)
+		tbl, err := db.table("")
+		require.NoError(t, err)
+		p := tbl.determinePartition(pkey.sha256PartitionKey)
+		var got []string
+		for _, e := range p.keys.Range(pkey.StrSHA256PartitionKey()) {
+			got = append(got, e.sortKey.(*types.AttributeValueMemberS).Value)
+		}
+		require.Equal(t, []string{"ORDER#1", "ORDER#2", "PROFILE"}, got)
+	}
+	testSortKeyOrder()
+
+	// reopen
+	require.NoError(t, db.Close())
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	testSortKeyOrder()
+
+	_, err = db.DeleteItem(context.Background(), &DeleteItemInput{Key: items[1]})
+	require.NoError(t, err)
+	output, err := db.GetItem(context.Background(), &GetItemInput{Key: items[1]})
+	require.NoError(t, err)
+	require.Nil(t, output.Item)
+	output, err = db.GetItem(context.Background(), &GetItemInput{Key: items[2]})
+	require.NoError(t, err)
+	require.Equal(t, items[2], output.Item)
+	require.NoError(t, db.Close())
+}
+
 func testPutItem(t *testing.T, db *Db) {
 	t.Helper()
 	for _, v := range getAttributeValues() {
@@ -118,3 +195,888 @@ var getAttributeValues = func() []map[string]types.AttributeValue {
 		},
 	}
 }
+
+func TestQuery(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-query")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Partition.Num = 4
+	c.Table.PartitionKey = "pk"
+	c.Table.SortKey = "sk"
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, pk := range []string{"USER#1", "USER#2"} {
+		for _, sk := range []string{"ORDER#3", "ORDER#1", "PROFILE", "ORDER#2"} {
+			_, err := db.PutItem(context.Background(), &PutItemInput{Item: map[string]types.AttributeValue{
+				"pk": &types.AttributeValueMemberS{Value: pk},
+				"sk": &types.AttributeValueMemberS{Value: sk},
+			}})
+			require.NoError(t, err)
+		}
+	}
+
+	sortKeys := func(output *QueryOutput) []string {
+		var sks []string
+		for _, item := range output.Items {
+			require.Equal(t, "USER#1", item["pk"].(*types.AttributeValueMemberS).Value)
+			sks = append(sks, item["sk"].(*types.AttributeValueMemberS).Value)
+		}
+		return sks
+	}
+	s := func(v string) types.AttributeValue {
+		return &types.AttributeValueMemberS{Value: v}
+	}
+	backward := false
+
+	test := map[string]struct {
+		expr    string
+		values  map[string]types.AttributeValue
+		forward *bool
+		want    []string
+	}{
+		"partition key": {
+			expr:   "pk = :pk",
+			values: map[string]types.AttributeValue{":pk": s("USER#1")},
+			want:   []string{"ORDER#1", "ORDER#2", "ORDER#3", "PROFILE"},
+		},
+		"backward": {
+			expr:    "pk = :pk",
+			values:  map[string]types.AttributeValue{":pk": s("USER#1")},
+			forward: &backward,
+			want:    []string{"PROFILE", "ORDER#3", "ORDER#2", "ORDER#1"},
+		},
+		"=": {
+			expr:   "pk = :pk AND sk = :sk",
+			values: map[string]types.AttributeValue{":pk": s("USER#1"), ":sk": s("ORDER#2")},
+			want:   []string{"ORDER#2"},
+		},
+		"<": {
+			expr:   "pk = :pk AND sk < :sk",
+			values: map[string]types.AttributeValue{":pk": s("USER#1"), ":sk": s("ORDER#2")},
+			want:   []string{"ORDER#1"},
+		},
+		"<=": {
+			expr:   "pk = :pk AND sk <= :sk",
+			values: map[string]types.AttributeValue{":pk": s("USER#1"), ":sk": s("ORDER#2")},
+			want:   []string{"ORDER#1", "ORDER#2"},
+		},
+		">": {
+			expr:   "pk = :pk AND sk > :sk",
+			values: map[string]types.AttributeValue{":pk": s("USER#1"), ":sk": s("ORDER#2")},
+			want:   []string{"ORDER#3", "PROFILE"},
+		},
+		">=": {
+			expr:   "pk = :pk AND :sk <= sk",
+			values: map[string]types.AttributeValue{":pk": s("USER#1"), ":sk": s("ORDER#2")},
+			want:   []string{"ORDER#2", "ORDER#3", "PROFILE"},
+		},
+		"between": {
+			expr:   "pk = :pk AND sk BETWEEN :from AND :to",
+			values: map[string]types.AttributeValue{":pk": s("USER#1"), ":from": s("ORDER#2"), ":to": s("ORDER#9")},
+			want:   []string{"ORDER#2", "ORDER#3"},
+		},
+		"begins_with": {
+			expr:   "begins_with(sk, :prefix) AND pk = :pk",
+			values: map[string]types.AttributeValue{":pk": s("USER#1"), ":prefix": s("ORDER#")},
+			want:   []string{"ORDER#1", "ORDER#2", "ORDER#3"},
+		},
+		"not found": {
+			expr:   "pk = :pk AND begins_with(sk, :prefix)",
+			values: map[string]types.AttributeValue{":pk": s("USER#1"), ":prefix": s("CART#")},
+			want:   nil,
+		},
+	}
+	for name, tt := range test {
+		t.Run(name, func(t *testing.T) {
+			output, err := db.Query(context.Background(), &QueryInput{
+				KeyConditionExpression:    tt.expr,
+				ExpressionAttributeValues: tt.values,
+				ScanIndexForward:          tt.forward,
+			})
+			require.NoError(t, err)
+			require.Equal(t, tt.want, sortKeys(output))
+			require.Equal(t, int32(len(tt.want)), output.Count)
+			require.Nil(t, output.LastEvaluatedKey)
+		})
+	}
+
+	t.Run("paging", func(t *testing.T) {
+		input := &QueryInput{
+			KeyConditionExpression:    "#pk = :pk",
+			ExpressionAttributeNames:  map[string]string{"#pk": "pk"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":pk": s("USER#1")},
+			Limit:                     3,
+		}
+		output, err := db.Query(context.Background(), input)
+		require.NoError(t, err)
+		require.Equal(t, []string{"ORDER#1", "ORDER#2", "ORDER#3"}, sortKeys(output))
+		require.Equal(t, map[string]types.AttributeValue{"pk": s("USER#1"), "sk": s("ORDER#3")}, output.LastEvaluatedKey)
+
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+		output, err = db.Query(context.Background(), input)
+		require.NoError(t, err)
+		require.Equal(t, []string{"PROFILE"}, sortKeys(output))
+		require.Nil(t, output.LastEvaluatedKey)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, expr := range []string{
+			"sk = :pk",
+			"pk < :pk",
+			"pk = :pk AND value = :pk",
+			"pk = :pk AND",
+		} {
+			_, err := db.Query(context.Background(), &QueryInput{
+				KeyConditionExpression:    expr,
+				ExpressionAttributeValues: map[string]types.AttributeValue{":pk": s("USER#1")},
+			})
+			require.Error(t, err, expr)
+		}
+	})
+}
+
+func TestScan(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-scan")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Partition.Num = 4
+	c.Segment.MaxStoreBytes = 1024 * 1024 * 4
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.PartitionKey = "pk"
+	c.Table.SortKey = "sk"
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+
+	key := func(pk, sk int) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%d", pk)},
+			"sk": &types.AttributeValueMemberS{Value: fmt.Sprintf("ORDER#%d", sk)},
+		}
+	}
+	want := make(map[string]bool)
+	for pk := range 10 {
+		for sk := range 5 {
+			item := key(pk, sk)
+			item["value"] = &types.AttributeValueMemberN{Value: "1"}
+			_, err := db.PutItem(context.Background(), &PutItemInput{Item: item})
+			require.NoError(t, err)
+			want[fmt.Sprint(pk, sk)] = true
+		}
+	}
+	// overwrite and delete
+	for pk := range 10 {
+		item := key(pk, 0)
+		item["value"] = &types.AttributeValueMemberN{Value: "2"}
+		_, err := db.PutItem(context.Background(), &PutItemInput{Item: item})
+		require.NoError(t, err)
+		_, err = db.DeleteItem(context.Background(), &DeleteItemInput{Key: key(pk, 4)})
+		require.NoError(t, err)
+		delete(want, fmt.Sprint(pk, 4))
+	}
+
+	scan := func(limit int32) (map[string]bool, int) {
+		got := make(map[string]bool)
+		var pages int
+		input := &ScanInput{Limit: limit}
+		for {
+			output, err := db.Scan(context.Background(), input)
+			require.NoError(t, err)
+			require.Equal(t, int32(len(output.Items)), output.Count)
+			if limit > 0 {
+				require.LessOrEqual(t, output.Count, limit)
+			}
+			pages++
+			for _, item := range output.Items {
+				var pk, sk int
+				_, err := fmt.Sscanf(item["pk"].(*types.AttributeValueMemberS).Value, "USER#%d", &pk)
+				require.NoError(t, err)
+				_, err = fmt.Sscanf(item["sk"].(*types.AttributeValueMemberS).Value, "ORDER#%d", &sk)
+				require.NoError(t, err)
+				k := fmt.Sprint(pk, sk)
+				require.False(t, got[k], "duplicated item %s", k)
+				got[k] = true
+				if sk == 0 {
+					require.Equal(t, "2", item["value"].(*types.AttributeValueMemberN).Value)
+				}
+			}
+			if output.LastEvaluatedKey == nil {
+				return got, pages
+			}
+			input.ExclusiveStartKey = output.LastEvaluatedKey
+		}
+	}
+
+	got, pages := scan(0)
+	require.Equal(t, want, got)
+	require.Equal(t, 1, pages)
+
+	got, pages = scan(7)
+	require.Equal(t, want, got)
+	require.GreaterOrEqual(t, pages, 6)
+
+	// page size
+	value := strings.Repeat("v", 250)
+	for i := range 5000 {
+		_, err := db.PutItem(context.Background(), &PutItemInput{Item: map[string]types.AttributeValue{
+			"pk":    &types.AttributeValueMemberS{Value: "LARGE"},
+			"sk":    &types.AttributeValueMemberS{Value: fmt.Sprint(i)},
+			"value": &types.AttributeValueMemberS{Value: value},
+		}})
+		require.NoError(t, err)
+	}
+	output, err := db.Scan(context.Background(), &ScanInput{})
+	require.NoError(t, err)
+	require.NotNil(t, output.LastEvaluatedKey)
+	require.Less(t, output.Count, int32(5000+len(want)))
+}
+
+func TestParallelScan(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-parallel-scan")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Partition.Num = 4
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.PartitionKey = "pk"
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+
+	const N = 200
+	for i := range N {
+		_, err := db.PutItem(context.Background(), &PutItemInput{Item: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: fmt.Sprint(i)},
+		}})
+		require.NoError(t, err)
+	}
+
+	for _, totalSegments := range []int32{1, 3, 4, 10, 33} {
+		t.Run(fmt.Sprint(totalSegments), func(t *testing.T) {
+			var wg sync.WaitGroup
+			results := make([][]string, totalSegments)
+			for segment := range totalSegments {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					input := &ScanInput{Limit: 9, Segment: segment, TotalSegments: totalSegments}
+					for {
+						output, err := db.Scan(context.Background(), input)
+						if !assert.NoError(t, err) {
+							return
+						}
+						for _, item := range output.Items {
+							results[segment] = append(results[segment], item["pk"].(*types.AttributeValueMemberS).Value)
+						}
+						if output.LastEvaluatedKey == nil {
+							return
+						}
+						input.ExclusiveStartKey = output.LastEvaluatedKey
+					}
+				}()
+			}
+			wg.Wait()
+
+			got := make(map[string]int)
+			for _, r := range results {
+				for _, pk := range r {
+					got[pk]++
+				}
+			}
+			require.Equal(t, N, len(got))
+			for pk, n := range got {
+				require.Equal(t, 1, n, pk)
+			}
+		})
+	}
+
+	for _, input := range []*ScanInput{
+		{Segment: 1},
+		{Segment: 4, TotalSegments: 4},
+		{Segment: -1, TotalSegments: 4},
+	} {
+		_, err := db.Scan(context.Background(), input)
+		require.ErrorIs(t, err, ErrInvalidSegment)
+	}
+}
+
+func TestUpdateItem(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-update")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Partition.Num = 4
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.PartitionKey = "pk"
+	c.Table.SortKey = "sk"
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+
+	key := map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: "USER#1"},
+		"sk": &types.AttributeValueMemberS{Value: "PROFILE"},
+	}
+	get := func() map[string]types.AttributeValue {
+		output, err := db.GetItem(context.Background(), &GetItemInput{Key: key})
+		require.NoError(t, err)
+		return output.Item
+	}
+
+	// create
+	_, err = db.UpdateItem(context.Background(), &UpdateItemInput{
+		Key:              key,
+		UpdateExpression: "SET #n = :name, tags = :tags",
+		ExpressionAttributeNames: map[string]string{
+			"#n": "name",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":name": &types.AttributeValueMemberS{Value: "taro"},
+			":tags": &types.AttributeValueMemberSS{Value: []string{"a", "b"}},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]types.AttributeValue{
+		"pk":   key["pk"],
+		"sk":   key["sk"],
+		"name": &types.AttributeValueMemberS{Value: "taro"},
+		"tags": &types.AttributeValueMemberSS{Value: []string{"a", "b"}},
+	}, get())
+
+	// concurrent updates are not lost
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.UpdateItem(context.Background(), &UpdateItemInput{
+				Key:              key,
+				UpdateExpression: "ADD visits :one",
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":one": &types.AttributeValueMemberN{Value: "1"},
+				},
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, &types.AttributeValueMemberN{Value: "50"}, get()["visits"])
+
+	_, err = db.UpdateItem(context.Background(), &UpdateItemInput{
+		Key:              key,
+		UpdateExpression: "REMOVE #n DELETE tags :a",
+		ExpressionAttributeNames: map[string]string{
+			"#n": "name",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":a": &types.AttributeValueMemberSS{Value: []string{"a"}},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]types.AttributeValue{
+		"pk":     key["pk"],
+		"sk":     key["sk"],
+		"tags":   &types.AttributeValueMemberSS{Value: []string{"b"}},
+		"visits": &types.AttributeValueMemberN{Value: "50"},
+	}, get())
+
+	// key attributes
+	for _, expr := range []string{"REMOVE sk", "SET pk = :v"} {
+		_, err = db.UpdateItem(context.Background(), &UpdateItemInput{
+			Key:              key,
+			UpdateExpression: expr,
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":v": &types.AttributeValueMemberS{Value: "USER#2"},
+			},
+		})
+		require.ErrorIs(t, err, ErrUpdateKeyAttribute)
+	}
+}
+
+func TestConditionExpression(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-condition")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Partition.Num = 4
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.PartitionKey = "pk"
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+
+	item := func(version string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"pk":      &types.AttributeValueMemberS{Value: "USER#1"},
+			"version": &types.AttributeValueMemberN{Value: version},
+		}
+	}
+	get := func() map[string]types.AttributeValue {
+		output, err := db.GetItem(context.Background(), &GetItemInput{Key: item("0")})
+		require.NoError(t, err)
+		return output.Item
+	}
+	var ccf *types.ConditionalCheckFailedException
+
+	// create only
+	createOnly := &PutItemInput{
+		Item:                item("1"),
+		ConditionExpression: "attribute_not_exists(pk)",
+	}
+	_, err = db.PutItem(context.Background(), createOnly)
+	require.NoError(t, err)
+	createOnly.Item = item("2")
+	_, err = db.PutItem(context.Background(), createOnly)
+	require.ErrorAs(t, err, &ccf)
+	require.Nil(t, ccf.Item)
+	require.Equal(t, item("1"), get())
+
+	// optimistic concurrency
+	putVersion := func(prev, next string) error {
+		_, err := db.PutItem(context.Background(), &PutItemInput{
+			Item:                item(next),
+			ConditionExpression: "#v = :prev",
+			ExpressionAttributeNames: map[string]string{
+				"#v": "version",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":prev": &types.AttributeValueMemberN{Value: prev},
+			},
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		})
+		return err
+	}
+	require.NoError(t, putVersion("1", "2"))
+	err = putVersion("1", "3")
+	require.ErrorAs(t, err, &ccf)
+	require.Equal(t, item("2"), ccf.Item)
+
+	// only one of the concurrent writers wins
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var wins int
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := putVersion("2", "3"); err == nil {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			} else {
+				var ccf *types.ConditionalCheckFailedException
+				assert.ErrorAs(t, err, &ccf)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, wins)
+
+	// update
+	update := &UpdateItemInput{
+		Key:                 item("0"),
+		UpdateExpression:    "SET version = version + :one",
+		ConditionExpression: "version < :max",
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+			":max": &types.AttributeValueMemberN{Value: "4"},
+		},
+	}
+	_, err = db.UpdateItem(context.Background(), update)
+	require.NoError(t, err)
+	_, err = db.UpdateItem(context.Background(), update)
+	require.ErrorAs(t, err, &ccf)
+	require.Equal(t, item("4"), get())
+
+	// delete
+	deleteIf := func(version string) error {
+		_, err := db.DeleteItem(context.Background(), &DeleteItemInput{
+			Key:                 item("0"),
+			ConditionExpression: "version = :v",
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":v": &types.AttributeValueMemberN{Value: version},
+			},
+		})
+		return err
+	}
+	require.ErrorAs(t, deleteIf("3"), &ccf)
+	require.NotNil(t, get())
+	require.NoError(t, deleteIf("4"))
+	require.Nil(t, get())
+	require.ErrorAs(t, deleteIf("4"), &ccf)
+
+	// invalid expression
+	_, err = db.PutItem(context.Background(), &PutItemInput{
+		Item:                item("1"),
+		ConditionExpression: "attribute_not_exists(",
+	})
+	var e *expression.Error
+	require.ErrorAs(t, err, &e)
+}
+
+func TestReturnValues(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-return-values")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.PartitionKey = "pk"
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := func(v string) types.AttributeValue {
+		return &types.AttributeValueMemberS{Value: v}
+	}
+	key := map[string]types.AttributeValue{"pk": s("QUEUE")}
+	v1 := map[string]types.AttributeValue{
+		"pk":  s("QUEUE"),
+		"a":   s("1"),
+		"doc": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"x": s("x"), "y": s("y")}},
+	}
+
+	// put
+	output, err := db.PutItem(context.Background(), &PutItemInput{Item: v1, ReturnValues: types.ReturnValueAllOld})
+	require.NoError(t, err)
+	require.Nil(t, output.Attributes)
+	output, err = db.PutItem(context.Background(), &PutItemInput{Item: v1, ReturnValues: types.ReturnValueAllOld})
+	require.NoError(t, err)
+	require.Equal(t, v1, output.Attributes)
+	output, err = db.PutItem(context.Background(), &PutItemInput{Item: v1})
+	require.NoError(t, err)
+	require.Nil(t, output.Attributes)
+	_, err = db.PutItem(context.Background(), &PutItemInput{Item: v1, ReturnValues: types.ReturnValueAllNew})
+	require.ErrorIs(t, err, ErrInvalidReturnValues)
+
+	// update
+	update := func(rv types.ReturnValue) map[string]types.AttributeValue {
+		output, err := db.UpdateItem(context.Background(), &UpdateItemInput{
+			Key:              key,
+			UpdateExpression: "SET a = :a, doc.x = :a, b = :a",
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":a": s(string(rv)),
+			},
+			ReturnValues: rv,
+		})
+		require.NoError(t, err)
+		return output.Attributes
+	}
+	require.Nil(t, update(types.ReturnValueNone))
+	require.Equal(t, map[string]types.AttributeValue{
+		"pk":  s("QUEUE"),
+		"a":   s("NONE"),
+		"b":   s("NONE"),
+		"doc": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"x": s("NONE"), "y": s("y")}},
+	}, update(types.ReturnValueAllOld))
+	require.Equal(t, map[string]types.AttributeValue{
+		"a":   s("ALL_OLD"),
+		"b":   s("ALL_OLD"),
+		"doc": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"x": s("ALL_OLD")}},
+	}, update(types.ReturnValueUpdatedOld))
+	require.Equal(t, map[string]types.AttributeValue{
+		"pk":  s("QUEUE"),
+		"a":   s("ALL_NEW"),
+		"b":   s("ALL_NEW"),
+		"doc": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"x": s("ALL_NEW"), "y": s("y")}},
+	}, update(types.ReturnValueAllNew))
+	require.Equal(t, map[string]types.AttributeValue{
+		"a":   s("UPDATED_NEW"),
+		"b":   s("UPDATED_NEW"),
+		"doc": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"x": s("UPDATED_NEW")}},
+	}, update(types.ReturnValueUpdatedNew))
+
+	// delete as pop
+	deleted, err := db.DeleteItem(context.Background(), &DeleteItemInput{Key: key, ReturnValues: types.ReturnValueAllOld})
+	require.NoError(t, err)
+	require.Equal(t, s("UPDATED_NEW"), deleted.Attributes["a"])
+	deleted, err = db.DeleteItem(context.Background(), &DeleteItemInput{Key: key, ReturnValues: types.ReturnValueAllOld})
+	require.NoError(t, err)
+	require.Nil(t, deleted.Attributes)
+}
+
+func TestProjectionExpression(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-projection")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.PartitionKey = "pk"
+	c.Table.SortKey = "sk"
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := func(v string) types.AttributeValue {
+		return &types.AttributeValueMemberS{Value: v}
+	}
+	for _, sk := range []string{"1", "2", "3"} {
+		_, err := db.PutItem(context.Background(), &PutItemInput{Item: map[string]types.AttributeValue{
+			"pk":    s("USER"),
+			"sk":    s(sk),
+			"name":  s("name" + sk),
+			"email": s("email" + sk),
+			"a": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"b": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+					&types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"c": s("c0"), "d": s("d0")}},
+					&types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"c": s("c" + sk), "d": s("d1")}},
+				}},
+				"e": s("e"),
+			}},
+		}})
+		require.NoError(t, err)
+	}
+	want := func(sk string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"name": s("name" + sk),
+			"a": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"b": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+					&types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"c": s("c" + sk)}},
+				}},
+			}},
+		}
+	}
+	names := map[string]string{"#n": "name"}
+
+	// get
+	getOutput, err := db.GetItem(context.Background(), &GetItemInput{
+		Key:                      map[string]types.AttributeValue{"pk": s("USER"), "sk": s("2")},
+		ProjectionExpression:     "#n, a.b[1].c",
+		ExpressionAttributeNames: names,
+	})
+	require.NoError(t, err)
+	require.Equal(t, want("2"), getOutput.Item)
+	getOutput, err = db.GetItem(context.Background(), &GetItemInput{
+		Key:                  map[string]types.AttributeValue{"pk": s("USER"), "sk": s("2")},
+		ProjectionExpression: "missing",
+	})
+	require.NoError(t, err)
+	require.Empty(t, getOutput.Item)
+	_, err = db.GetItem(context.Background(), &GetItemInput{
+		Key:                  map[string]types.AttributeValue{"pk": s("USER"), "sk": s("2")},
+		ProjectionExpression: "a, a.b",
+	})
+	require.Error(t, err)
+
+	// query keeps paging with the key attributes though they are not projected
+	queryOutput, err := db.Query(context.Background(), &QueryInput{
+		KeyConditionExpression:    "pk = :pk",
+		ProjectionExpression:      "#n, a.b[1].c",
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: map[string]types.AttributeValue{":pk": s("USER")},
+		Limit:                     2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []map[string]types.AttributeValue{want("1"), want("2")}, queryOutput.Items)
+	require.Equal(t, map[string]types.AttributeValue{"pk": s("USER"), "sk": s("2")}, queryOutput.LastEvaluatedKey)
+
+	// scan
+	scanOutput, err := db.Scan(context.Background(), &ScanInput{
+		ProjectionExpression: "sk",
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []map[string]types.AttributeValue{
+		{"sk": s("1")}, {"sk": s("2")}, {"sk": s("3")},
+	}, scanOutput.Items)
+}
+
+func TestFilterExpression(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-filter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.PartitionKey = "pk"
+	c.Table.SortKey = "sk"
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := func(v string) types.AttributeValue {
+		return &types.AttributeValueMemberS{Value: v}
+	}
+	for i := range 10 {
+		_, err := db.PutItem(context.Background(), &PutItemInput{Item: map[string]types.AttributeValue{
+			"pk":    s("ORDER"),
+			"sk":    s(fmt.Sprintf("%02d", i)),
+			"price": &types.AttributeValueMemberN{Value: fmt.Sprint(i * 100)},
+		}})
+		require.NoError(t, err)
+	}
+	values := map[string]types.AttributeValue{
+		":pk":  s("ORDER"),
+		":min": &types.AttributeValueMemberN{Value: "550"},
+	}
+
+	// the filter is applied after Limit
+	query := &QueryInput{
+		KeyConditionExpression:    "pk = :pk",
+		FilterExpression:          "price > :min",
+		ProjectionExpression:      "sk",
+		ExpressionAttributeValues: values,
+		Limit:                     7,
+	}
+	queryOutput, err := db.Query(context.Background(), query)
+	require.NoError(t, err)
+	require.Equal(t, []map[string]types.AttributeValue{{"sk": s("06")}}, queryOutput.Items)
+	require.EqualValues(t, 1, queryOutput.Count)
+	require.EqualValues(t, 7, queryOutput.ScannedCount)
+	require.Equal(t, map[string]types.AttributeValue{"pk": s("ORDER"), "sk": s("06")}, queryOutput.LastEvaluatedKey)
+	query.ExclusiveStartKey = queryOutput.LastEvaluatedKey
+	queryOutput, err = db.Query(context.Background(), query)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, queryOutput.Count)
+	require.EqualValues(t, 3, queryOutput.ScannedCount)
+	require.Nil(t, queryOutput.LastEvaluatedKey)
+
+	// a page may be empty after the filter
+	queryOutput, err = db.Query(context.Background(), &QueryInput{
+		KeyConditionExpression:    "pk = :pk",
+		FilterExpression:          "price > :min",
+		ExpressionAttributeValues: values,
+		Limit:                     2,
+	})
+	require.NoError(t, err)
+	require.Empty(t, queryOutput.Items)
+	require.EqualValues(t, 2, queryOutput.ScannedCount)
+	require.NotNil(t, queryOutput.LastEvaluatedKey)
+
+	_, err = db.Query(context.Background(), &QueryInput{
+		KeyConditionExpression:    "pk = :pk",
+		FilterExpression:          "sk > :pk",
+		ExpressionAttributeValues: values,
+	})
+	require.ErrorIs(t, err, ErrFilterKeyAttribute)
+
+	// scan
+	scanOutput, err := db.Scan(context.Background(), &ScanInput{
+		FilterExpression:          "price > :min AND begins_with(sk, :zero)",
+		ExpressionAttributeValues: map[string]types.AttributeValue{":min": values[":min"], ":zero": s("0")},
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 4, scanOutput.Count)
+	require.EqualValues(t, 10, scanOutput.ScannedCount)
+	require.Nil(t, scanOutput.LastEvaluatedKey)
+	_, err = db.Scan(context.Background(), &ScanInput{FilterExpression: "price >"})
+	var e *expression.Error
+	require.ErrorAs(t, err, &e)
+	require.Equal(t, "FilterExpression", e.Expression)
+}
+
+func TestKeyTypes(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-key-types")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.PartitionKey = "id"
+	c.Table.PartitionKeyType = types.ScalarAttributeTypeN
+	c.Table.SortKey = "at"
+	c.Table.SortKeyType = types.ScalarAttributeTypeN
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+
+	n := func(v string) types.AttributeValue {
+		return &types.AttributeValueMemberN{Value: v}
+	}
+	item := func(id, at string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{"id": n(id), "at": n(at)}
+	}
+
+	// the same numbers are the same key
+	for _, id := range []string{"1", "1.0", "10E-1"} {
+		_, err := db.PutItem(context.Background(), &PutItemInput{Item: item(id, "0.5")})
+		require.NoError(t, err)
+	}
+	output, err := db.GetItem(context.Background(), &GetItemInput{Key: item("1.00", "5e-1")})
+	require.NoError(t, err)
+	require.Equal(t, item("10E-1", "0.5"), output.Item)
+
+	// number sort keys are ordered by their values
+	for _, at := range []string{"10", "9", "-2", "1.5", "100"} {
+		_, err := db.PutItem(context.Background(), &PutItemInput{Item: item("1", at)})
+		require.NoError(t, err)
+	}
+	queryOutput, err := db.Query(context.Background(), &QueryInput{
+		KeyConditionExpression:    "id = :id AND at > :at",
+		ExpressionAttributeValues: map[string]types.AttributeValue{":id": n("1"), ":at": n("0")},
+	})
+	require.NoError(t, err)
+	var got []string
+	for _, item := range queryOutput.Items {
+		got = append(got, item["at"].(*types.AttributeValueMemberN).Value)
+	}
+	require.Equal(t, []string{"0.5", "1.5", "9", "10", "100"}, got)
+
+	// wrong types
+	_, err = db.PutItem(context.Background(), &PutItemInput{Item: map[string]types.AttributeValue{
+		"id": &types.AttributeValueMemberS{Value: "1"}, "at": n("1"),
+	}})
+	require.ErrorIs(t, err, ErrInvalidPartitionKeyType)
+	_, err = db.PutItem(context.Background(), &PutItemInput{Item: item("1", "one")})
+	require.ErrorIs(t, err, ErrInvalidSortKeyType)
+	// not decimal
+	_, err = db.PutItem(context.Background(), &PutItemInput{Item: item("0x10", "1")})
+	require.ErrorIs(t, err, ErrInvalidPartitionKeyType)
+	_, err = db.PutItem(context.Background(), &PutItemInput{Item: item("1", "1e999999999")})
+	require.ErrorIs(t, err, ErrInvalidSortKeyType)
+	_, err = db.Query(context.Background(), &QueryInput{
+		KeyConditionExpression:    "id = :id AND at > :at",
+		ExpressionAttributeValues: map[string]types.AttributeValue{":id": n("1"), ":at": &types.AttributeValueMemberS{Value: "0"}},
+	})
+	require.ErrorIs(t, err, ErrInvalidSortKeyType)
+	_, err = db.Query(context.Background(), &QueryInput{
+		KeyConditionExpression:    "id = :id AND begins_with(at, :at)",
+		ExpressionAttributeValues: map[string]types.AttributeValue{":id": n("1"), ":at": n("1")},
+	})
+	var e *expression.Error
+	require.ErrorAs(t, err, &e)
+
+	// binary keys
+	c.Table.PartitionKeyType = types.ScalarAttributeTypeB
+	c.Table.SortKeyType = types.ScalarAttributeTypeB
+	bdb, err := New(dir+"/binary", c)
+	require.NoError(t, err)
+	defer bdb.Close()
+	b := func(v ...byte) types.AttributeValue {
+		return &types.AttributeValueMemberB{Value: v}
+	}
+	for _, at := range [][]byte{{2}, {1, 255}, {1}, {1, 0}} {
+		_, err := bdb.PutItem(context.Background(), &PutItemInput{Item: map[string]types.AttributeValue{"id": b(0), "at": b(at...)}})
+		require.NoError(t, err)
+	}
+	queryOutput, err = bdb.Query(context.Background(), &QueryInput{
+		KeyConditionExpression:    "id = :id AND begins_with(at, :at)",
+		ExpressionAttributeValues: map[string]types.AttributeValue{":id": b(0), ":at": b(1)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []map[string]types.AttributeValue{
+		{"id": b(0), "at": b(1)},
+		{"id": b(0), "at": b(1, 0)},
+		{"id": b(0), "at": b(1, 255)},
+	}, queryOutput.Items)
+
+	c.Table.SortKeyType = "BOOL"
+	_, err = New(dir+"/invalid", c)
+	require.ErrorIs(t, err, ErrInvalidKeyType)
+}
@@ -0,0 +1,92 @@
+package expression
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Compare orders the values of the same scalar type like the comparators of expressions.
+// ok is false when the values are not comparable.
+func Compare(a, b types.AttributeValue) (n int, ok bool) {
+	return compare(a, b)
+}
+
+// compare orders the values of the same scalar type.
+// strings and binaries are ordered by their bytes, and numbers by their values.
+// ok is false when the values are not comparable.
+func compare(a, b types.AttributeValue) (n int, ok bool) {
+	switch a := a.(type) {
+	case *types.AttributeValueMemberS:
+		if b, ok := b.(*types.AttributeValueMemberS); ok {
+			return strings.Compare(a.Value, b.Value), true
+		}
+	case *types.AttributeValueMemberN:
+		if b, ok := b.(*types.AttributeValueMemberN); ok {
+			ra, aok := parseNumber(a.Value)
+			rb, bok := parseNumber(b.Value)
+			if aok && bok {
+				return ra.Cmp(rb), true
+			}
+		}
+	case *types.AttributeValueMemberB:
+		if b, ok := b.(*types.AttributeValueMemberB); ok {
+			return bytes.Compare(a.Value, b.Value), true
+		}
+	}
+	return 0, false
+}
+
+// equal reports whether the values are equal.
+// sets are equal when they have the same elements in any order.
+func equal(a, b types.AttributeValue) bool {
+	switch a := a.(type) {
+	case *types.AttributeValueMemberS, *types.AttributeValueMemberN, *types.AttributeValueMemberB:
+		n, ok := compare(a, b)
+		return ok && n == 0
+	case *types.AttributeValueMemberSS:
+		b, ok := b.(*types.AttributeValueMemberSS)
+		return ok && equalSet(a.Value, b.Value, equalString)
+	case *types.AttributeValueMemberNS:
+		b, ok := b.(*types.AttributeValueMemberNS)
+		return ok && equalSet(a.Value, b.Value, equalNumber)
+	case *types.AttributeValueMemberBS:
+		b, ok := b.(*types.AttributeValueMemberBS)
+		return ok && equalSet(a.Value, b.Value, bytes.Equal)
+	case *types.AttributeValueMemberBOOL:
+		b, ok := b.(*types.AttributeValueMemberBOOL)
+		return ok && a.Value == b.Value
+	case *types.AttributeValueMemberNULL:
+		_, ok := b.(*types.AttributeValueMemberNULL)
+		return ok
+	case *types.AttributeValueMemberL:
+		b, ok := b.(*types.AttributeValueMemberL)
+		if !ok || len(a.Value) != len(b.Value) {
+			return false
+		}
+		for i := range a.Value {
+			if !equal(a.Value[i], b.Value[i]) {
+				return false
+			}
+		}
+		return true
+	case *types.AttributeValueMemberM:
+		b, ok := b.(*types.AttributeValueMemberM)
+		if !ok || len(a.Value) != len(b.Value) {
+			return false
+		}
+		for k, av := range a.Value {
+			bv, found := b.Value[k]
+			if !found || !equal(av, bv) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func equalSet[T any](a, b []T, equal func(T, T) bool) bool {
+	return len(a) == len(b) && len(difference(a, b, equal)) == 0
+}
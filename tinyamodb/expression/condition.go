@@ -0,0 +1,287 @@
+package expression
+
+import (
+	"bytes"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Condition is a parsed condition expression such as
+// 'attribute_not_exists(pk) OR version = :version'.
+type Condition struct {
+	c condition
+}
+
+// ParseCondition parses a condition expression.
+func ParseCondition(expr string, names map[string]string, values map[string]types.AttributeValue) (_ *Condition, err error) {
+	defer func() { err = withExpression(err, "ConditionExpression") }()
+	p, err := newParser(expr, names, values)
+	if err != nil {
+		return nil, err
+	}
+	c, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectEOF(); err != nil {
+		return nil, err
+	}
+	return &Condition{c: c}, nil
+}
+
+// ParseFilter parses a filter expression.
+// it is a condition expression applied to the items read by Query and Scan.
+func ParseFilter(expr string, names map[string]string, values map[string]types.AttributeValue) (_ *Condition, err error) {
+	defer func() { err = withExpression(err, "FilterExpression") }()
+	return ParseCondition(expr, names, values)
+}
+
+// Attributes returns the names of the top level attributes in the condition.
+func (c *Condition) Attributes() []string {
+	var names []string
+	operands := func(os ...operand) {
+		for _, o := range os {
+			switch o := o.(type) {
+			case *path:
+				names = append(names, o.attributeName())
+			case *size:
+				names = append(names, o.path.attributeName())
+			}
+		}
+	}
+	var conditions func(c condition)
+	conditions = func(c condition) {
+		switch c := c.(type) {
+		case *comparison:
+			operands(c.left, c.right)
+		case *between:
+			operands(c.operand, c.lower, c.upper)
+		case *in:
+			operands(c.operand)
+			operands(c.list...)
+		case *and:
+			conditions(c.left)
+			conditions(c.right)
+		case *or:
+			conditions(c.left)
+			conditions(c.right)
+		case *not:
+			conditions(c.condition)
+		case *function:
+			operands(c.args...)
+		}
+	}
+	conditions(c.c)
+	return names
+}
+
+// Evaluate reports whether the item satisfies the condition.
+// item is nil when the item does not exist.
+func (c *Condition) Evaluate(item map[string]types.AttributeValue) (bool, error) {
+	return c.c.eval(item)
+}
+
+// resolve returns the value of the operand, or nil if the attribute does not exist.
+func resolve(o operand, item map[string]types.AttributeValue) types.AttributeValue {
+	switch o := o.(type) {
+	case *value:
+		return o.av
+	case *path:
+		return o.get(item)
+	case *size:
+		return sizeOf(o.path.get(item))
+	}
+	return nil
+}
+
+// sizeOf returns the size of the value, or nil if the value has no size.
+// it is the length of a string or a binary and the number of elements of a set, a list or a map.
+func sizeOf(av types.AttributeValue) types.AttributeValue {
+	var n int
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		n = utf8.RuneCountInString(v.Value)
+	case *types.AttributeValueMemberB:
+		n = len(v.Value)
+	case *types.AttributeValueMemberSS:
+		n = len(v.Value)
+	case *types.AttributeValueMemberNS:
+		n = len(v.Value)
+	case *types.AttributeValueMemberBS:
+		n = len(v.Value)
+	case *types.AttributeValueMemberL:
+		n = len(v.Value)
+	case *types.AttributeValueMemberM:
+		n = len(v.Value)
+	default:
+		return nil
+	}
+	return &types.AttributeValueMemberN{Value: strconv.Itoa(n)}
+}
+
+// attributeTypes are the operands of attribute_type.
+var attributeTypes = []string{"S", "SS", "N", "NS", "B", "BS", "BOOL", "NULL", "L", "M"}
+
+// attributeType returns the data type descriptor of the value such as "S".
+func attributeType(av types.AttributeValue) string {
+	switch av.(type) {
+	case *types.AttributeValueMemberS:
+		return "S"
+	case *types.AttributeValueMemberSS:
+		return "SS"
+	case *types.AttributeValueMemberN:
+		return "N"
+	case *types.AttributeValueMemberNS:
+		return "NS"
+	case *types.AttributeValueMemberB:
+		return "B"
+	case *types.AttributeValueMemberBS:
+		return "BS"
+	case *types.AttributeValueMemberBOOL:
+		return "BOOL"
+	case *types.AttributeValueMemberNULL:
+		return "NULL"
+	case *types.AttributeValueMemberL:
+		return "L"
+	case *types.AttributeValueMemberM:
+		return "M"
+	}
+	return ""
+}
+
+func (c *comparison) eval(item map[string]types.AttributeValue) (bool, error) {
+	left, right := resolve(c.left, item), resolve(c.right, item)
+	if left == nil || right == nil {
+		// a missing attribute is not equal to anything.
+		return c.op == tokenNE, nil
+	}
+	switch c.op {
+	case tokenEQ:
+		return equal(left, right), nil
+	case tokenNE:
+		return !equal(left, right), nil
+	}
+	n, ok := compare(left, right)
+	if !ok {
+		return false, nil
+	}
+	switch c.op {
+	case tokenLT:
+		return n < 0, nil
+	case tokenLE:
+		return n <= 0, nil
+	case tokenGT:
+		return n > 0, nil
+	default:
+		return n >= 0, nil
+	}
+}
+
+func (c *between) eval(item map[string]types.AttributeValue) (bool, error) {
+	v, lower, upper := resolve(c.operand, item), resolve(c.lower, item), resolve(c.upper, item)
+	if v == nil || lower == nil || upper == nil {
+		return false, nil
+	}
+	l, lok := compare(v, lower)
+	u, uok := compare(v, upper)
+	return lok && uok && l >= 0 && u <= 0, nil
+}
+
+func (c *in) eval(item map[string]types.AttributeValue) (bool, error) {
+	v := resolve(c.operand, item)
+	if v == nil {
+		return false, nil
+	}
+	for _, o := range c.list {
+		if e := resolve(o, item); e != nil && equal(v, e) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *and) eval(item map[string]types.AttributeValue) (bool, error) {
+	ok, err := c.left.eval(item)
+	if err != nil || !ok {
+		return false, err
+	}
+	return c.right.eval(item)
+}
+
+func (c *or) eval(item map[string]types.AttributeValue) (bool, error) {
+	ok, err := c.left.eval(item)
+	if err != nil || ok {
+		return ok, err
+	}
+	return c.right.eval(item)
+}
+
+func (c *not) eval(item map[string]types.AttributeValue) (bool, error) {
+	ok, err := c.condition.eval(item)
+	return !ok, err
+}
+
+func (c *function) eval(item map[string]types.AttributeValue) (bool, error) {
+	v := resolve(c.args[0], item)
+	switch c.name {
+	case "attribute_exists":
+		return v != nil, nil
+	case "attribute_not_exists":
+		return v == nil, nil
+	case "attribute_type":
+		t := resolve(c.args[1], item).(*types.AttributeValueMemberS)
+		return v != nil && attributeType(v) == t.Value, nil
+	case "contains":
+		return contains(v, resolve(c.args[1], item)), nil
+	case "begins_with":
+		prefix := resolve(c.args[1], item)
+		switch v := v.(type) {
+		case *types.AttributeValueMemberS:
+			p, ok := prefix.(*types.AttributeValueMemberS)
+			return ok && strings.HasPrefix(v.Value, p.Value), nil
+		case *types.AttributeValueMemberB:
+			p, ok := prefix.(*types.AttributeValueMemberB)
+			return ok && bytes.HasPrefix(v.Value, p.Value), nil
+		}
+		return false, nil
+	}
+	return false, errorf(c.pos, "invalid function name '%s'", c.name)
+}
+
+// contains reports whether the string or the binary contains the substring,
+// or the set or the list contains the element.
+func contains(v, e types.AttributeValue) bool {
+	if v == nil || e == nil {
+		return false
+	}
+	switch v := v.(type) {
+	case *types.AttributeValueMemberS:
+		e, ok := e.(*types.AttributeValueMemberS)
+		return ok && strings.Contains(v.Value, e.Value)
+	case *types.AttributeValueMemberB:
+		e, ok := e.(*types.AttributeValueMemberB)
+		return ok && bytes.Contains(v.Value, e.Value)
+	case *types.AttributeValueMemberSS:
+		e, ok := e.(*types.AttributeValueMemberS)
+		return ok && slices.Contains(v.Value, e.Value)
+	case *types.AttributeValueMemberNS:
+		e, ok := e.(*types.AttributeValueMemberN)
+		return ok && slices.ContainsFunc(v.Value, func(n string) bool {
+			return equalNumber(n, e.Value)
+		})
+	case *types.AttributeValueMemberBS:
+		e, ok := e.(*types.AttributeValueMemberB)
+		return ok && slices.ContainsFunc(v.Value, func(b []byte) bool {
+			return bytes.Equal(b, e.Value)
+		})
+	case *types.AttributeValueMemberL:
+		return slices.ContainsFunc(v.Value, func(av types.AttributeValue) bool {
+			return equal(av, e)
+		})
+	}
+	return false
+}
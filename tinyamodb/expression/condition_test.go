@@ -0,0 +1,157 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCondition(t *testing.T) {
+	item := map[string]types.AttributeValue{
+		"pk":      &types.AttributeValueMemberS{Value: "USER#1"},
+		"version": &types.AttributeValueMemberN{Value: "10"},
+		"bin":     &types.AttributeValueMemberB{Value: []byte{1, 2, 3}},
+		"tags":    &types.AttributeValueMemberSS{Value: []string{"a", "b"}},
+		"nums":    &types.AttributeValueMemberNS{Value: []string{"1", "2.5"}},
+		"name":    &types.AttributeValueMemberS{Value: "こんにちは world"},
+		"doc": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"list": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+				&types.AttributeValueMemberBOOL{Value: true},
+			}},
+		}},
+	}
+	values := map[string]types.AttributeValue{
+		":pk":   &types.AttributeValueMemberS{Value: "USER#1"},
+		":v9":   &types.AttributeValueMemberN{Value: "9"},
+		":v10":  &types.AttributeValueMemberN{Value: "10.0"},
+		":v100": &types.AttributeValueMemberN{Value: "1e2"},
+		":s10":  &types.AttributeValueMemberS{Value: "10"},
+		":b":    &types.AttributeValueMemberB{Value: []byte{1, 2}},
+		":tags": &types.AttributeValueMemberSS{Value: []string{"b", "a"}},
+		":t":    &types.AttributeValueMemberBOOL{Value: true},
+		":a":    &types.AttributeValueMemberS{Value: "a"},
+		":wor":  &types.AttributeValueMemberS{Value: "wor"},
+		":n25":  &types.AttributeValueMemberN{Value: "25E-1"},
+		":n1":   &types.AttributeValueMemberN{Value: "1"},
+		":n2":   &types.AttributeValueMemberN{Value: "2"},
+		":n11":  &types.AttributeValueMemberN{Value: "11"},
+		":S":    &types.AttributeValueMemberS{Value: "S"},
+		":N":    &types.AttributeValueMemberS{Value: "N"},
+		":SS":   &types.AttributeValueMemberS{Value: "SS"},
+		":M":    &types.AttributeValueMemberS{Value: "M"},
+		":X":    &types.AttributeValueMemberS{Value: "X"},
+		":nan":  &types.AttributeValueMemberN{Value: "one"},
+		":s9":   &types.AttributeValueMemberS{Value: "9"},
+	}
+	names := map[string]string{"#v": "version"}
+
+	test := map[string]bool{
+		"attribute_exists(pk)":                  true,
+		"attribute_not_exists(pk)":              false,
+		"attribute_not_exists(nothing)":         true,
+		"attribute_exists(doc.list[0])":         true,
+		"attribute_exists(doc.list[1])":         false,
+		"#v = :v10":                             true,
+		"#v <> :v10":                            false,
+		"#v = :s10":                             false,
+		"#v < :v100":                            true,
+		"#v > :v9 AND #v <= :v10":               true,
+		"#v >= :s10":                            false,
+		"nothing <> :v10":                       true,
+		"nothing = :v10":                        false,
+		"nothing < :v10":                        false,
+		"#v BETWEEN :v9 AND :v100":              true,
+		"#v BETWEEN :v9 AND :v9":                false,
+		"#v IN (:v9, :v10)":                     true,
+		"#v IN (:v9, :s10)":                     false,
+		"tags = :tags":                          true,
+		"doc.list[0] = :t":                      true,
+		"begins_with(pk, :pk)":                  true,
+		"begins_with(bin, :b)":                  true,
+		"begins_with(#v, :s10)":                 false,
+		"NOT attribute_exists(pk)":              false,
+		"attribute_not_exists(pk) OR #v = :v10": true,
+		"attribute_not_exists(pk) OR #v = :v9 AND pk = :pk":   false,
+		"(attribute_not_exists(pk) OR #v = :v9) AND pk = :pk": false,
+		"attribute_exists(pk) OR #v = :v9 AND pk = :s10":      true,
+		"NOT (#v = :v9 OR #v = :v100)":                        true,
+		// numbers are compared by their values, strings by their bytes.
+		"#v > :v9":                       true,
+		"pk > :s9":                       true,
+		":s10 < :s9":                     true,
+		"bin < :b":                       false,
+		"attribute_type(pk, :S)":         true,
+		"attribute_type(#v, :N)":         true,
+		"attribute_type(tags, :SS)":      true,
+		"attribute_type(doc, :M)":        true,
+		"attribute_type(pk, :N)":         false,
+		"attribute_type(none, :S)":       false,
+		"contains(tags, :a)":             true,
+		"contains(tags, :wor)":           false,
+		"contains(nums, :n25)":           true,
+		"contains(nums, :n2)":            false,
+		"contains(name, :wor)":           true,
+		"contains(bin, :b)":              true,
+		"contains(doc.list, :t)":         true,
+		"contains(#v, :n1)":              false,
+		"size(name) = :n11":              true,
+		"size(tags) = :n2":               true,
+		"size(bin) > :n2":                true,
+		"size(doc) = :n1":                true,
+		"size(doc.list) = :n1":           true,
+		"size(#v) = :n2":                 false,
+		"size(none) <> :n2":              true,
+		"size(tags) BETWEEN :n1 AND :n2": true,
+		"size(tags) IN (:n1, :n2)":       true,
+	}
+	for expr, want := range test {
+		t.Run(expr, func(t *testing.T) {
+			c, err := ParseCondition(expr, names, values)
+			require.NoError(t, err)
+			got, err := c.Evaluate(item)
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		})
+	}
+
+	// not exists
+	c, err := ParseCondition("attribute_not_exists(pk)", names, values)
+	require.NoError(t, err)
+	got, err := c.Evaluate(nil)
+	require.NoError(t, err)
+	require.True(t, got)
+
+	errs := map[string]int{
+		"attribute_exists(:pk)":    17,
+		"attribute_exists(pk, #v)": 0,
+		"unknown(pk)":              0,
+		"#v = :v10 AND":            13,
+		"#v IN :v10":               6,
+		"(#v = :v10":               10,
+		"#v = :v10)":               9,
+		"NOT":                      3,
+		"#v < :t":                  5,
+		"#v BETWEEN :t AND :v9":    11,
+		"#v BETWEEN :v10 AND :v9":  3,
+		"#v BETWEEN :v10 AND :s10": 3,
+		"#v = :nan":                5,
+		"attribute_type(pk, :X)":   19,
+		"attribute_type(pk, pk)":   19,
+		"begins_with(pk, :v9)":     16,
+		"size(pk)":                 8,
+		"size(:pk) = :v9":          5,
+		"#v = contains(pk, :pk)":   5,
+		"contains(size(pk), :v9)":  9,
+	}
+	for expr, pos := range errs {
+		t.Run(expr, func(t *testing.T) {
+			_, err := ParseCondition(expr, names, values)
+			var e *Error
+			require.ErrorAs(t, err, &e)
+			require.Equal(t, pos, e.Pos)
+			require.Equal(t, "ValidationException", e.ErrorCode())
+			require.Equal(t, "ConditionExpression", e.Expression)
+		})
+	}
+}
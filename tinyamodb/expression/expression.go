@@ -0,0 +1,128 @@
+// Package expression parses and evaluates the expressions of DynamoDB such as
+// key condition expressions, condition expressions, projection expressions and update expressions.
+// They share one grammar of document paths, operands, comparators and functions.
+//
+// Expression attribute names (#name) and values (:value) are substituted while parsing.
+package expression
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// Error is returned when an expression is invalid.
+// it is a smithy.APIError with the code ValidationException like the errors of DynamoDB.
+type Error struct {
+	// Expression is the kind of the expression such as "ConditionExpression".
+	Expression string
+	// Pos is the byte offset in the expression.
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.ErrorCode(), e.ErrorMessage())
+}
+
+func (e *Error) ErrorCode() string {
+	return "ValidationException"
+}
+
+func (e *Error) ErrorMessage() string {
+	return fmt.Sprintf("Invalid %s: %s at position %d", e.Expression, e.Msg, e.Pos)
+}
+
+func (e *Error) ErrorFault() smithy.ErrorFault {
+	return smithy.FaultClient
+}
+
+func errorf(pos int, format string, a ...any) error {
+	return &Error{Expression: "expression", Pos: pos, Msg: fmt.Sprintf(format, a...)}
+}
+
+// withExpression sets the kind of the expression to the error if it is an *Error.
+func withExpression(err error, expression string) error {
+	var e *Error
+	if errors.As(err, &e) {
+		e.Expression = expression
+	}
+	return err
+}
+
+type condition interface {
+	position() int
+	// eval reports whether the item satisfies the condition.
+	eval(item map[string]types.AttributeValue) (bool, error)
+}
+
+// comparison is 'a = b', 'a <> b', 'a < b', 'a <= b', 'a > b' and 'a >= b'.
+type comparison struct {
+	pos         int
+	op          tokenKind
+	left, right operand
+}
+
+// between is 'a BETWEEN b AND c'.
+type between struct {
+	pos                   int
+	operand, lower, upper operand
+}
+
+// in is 'a IN (b, c, ...)'.
+type in struct {
+	pos     int
+	operand operand
+	list    []operand
+}
+
+type and struct {
+	pos         int
+	left, right condition
+}
+
+type or struct {
+	pos         int
+	left, right condition
+}
+
+type not struct {
+	pos       int
+	condition condition
+}
+
+type function struct {
+	pos  int
+	name string
+	args []operand
+}
+
+func (c *comparison) position() int { return c.pos }
+func (c *between) position() int    { return c.pos }
+func (c *in) position() int         { return c.pos }
+func (c *and) position() int        { return c.pos }
+func (c *or) position() int         { return c.pos }
+func (c *not) position() int        { return c.pos }
+func (c *function) position() int   { return c.pos }
+
+type operand interface {
+	position() int
+}
+
+// value is an expression attribute value.
+type value struct {
+	pos int
+	av  types.AttributeValue
+}
+
+func (o *value) position() int { return o.pos }
+
+// size is 'size(path)'.
+type size struct {
+	pos  int
+	path *path
+}
+
+func (o *size) position() int { return o.pos }
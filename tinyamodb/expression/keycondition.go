@@ -0,0 +1,142 @@
+package expression
+
+import "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+type KeyOperator int
+
+const (
+	KeyEQ KeyOperator = iota + 1
+	KeyLT
+	KeyLE
+	KeyGT
+	KeyGE
+	KeyBetween
+	KeyBeginsWith
+)
+
+// KeyCondition is a condition on one key attribute of a key condition expression.
+type KeyCondition struct {
+	Name     string
+	Operator KeyOperator
+	// Values has two values for KeyBetween, otherwise one.
+	Values []types.AttributeValue
+}
+
+// ParseKeyCondition parses a key condition expression such as
+// 'pk = :pk AND begins_with(sk, :prefix)'.
+// it returns the conditions joined with AND, at most one per key attribute.
+func ParseKeyCondition(expr string, names map[string]string, values map[string]types.AttributeValue) (_ []KeyCondition, err error) {
+	defer func() { err = withExpression(err, "KeyConditionExpression") }()
+	p, err := newParser(expr, names, values)
+	if err != nil {
+		return nil, err
+	}
+	c, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectEOF(); err != nil {
+		return nil, err
+	}
+
+	var conds []KeyCondition
+	var flatten func(c condition) error
+	flatten = func(c condition) error {
+		if a, ok := c.(*and); ok {
+			if err := flatten(a.left); err != nil {
+				return err
+			}
+			return flatten(a.right)
+		}
+		kc, err := toKeyCondition(c)
+		if err != nil {
+			return err
+		}
+		for _, prev := range conds {
+			if prev.Name == kc.Name {
+				return errorf(c.position(), "multiple conditions on key attribute '%s'", kc.Name)
+			}
+		}
+		conds = append(conds, kc)
+		return nil
+	}
+	if err := flatten(c); err != nil {
+		return nil, err
+	}
+	if len(conds) > 2 {
+		return nil, errorf(0, "key condition has more than two conditions")
+	}
+	return conds, nil
+}
+
+func toKeyCondition(c condition) (KeyCondition, error) {
+	switch c := c.(type) {
+	case *comparison:
+		name, nameOk := c.left.(*path)
+		v, valueOk := c.right.(*value)
+		op := c.op
+		if !nameOk || !valueOk {
+			// ':value < key' is 'key > :value'
+			name, nameOk = c.right.(*path)
+			v, valueOk = c.left.(*value)
+			switch op {
+			case tokenLT:
+				op = tokenGT
+			case tokenLE:
+				op = tokenGE
+			case tokenGT:
+				op = tokenLT
+			case tokenGE:
+				op = tokenLE
+			}
+		}
+		if !nameOk || !valueOk || !name.isTopLevel() {
+			return KeyCondition{}, errorf(c.pos, "key condition must compare a key attribute with a value")
+		}
+		kc := KeyCondition{Name: name.attributeName(), Values: []types.AttributeValue{v.av}}
+		switch op {
+		case tokenEQ:
+			kc.Operator = KeyEQ
+		case tokenLT:
+			kc.Operator = KeyLT
+		case tokenLE:
+			kc.Operator = KeyLE
+		case tokenGT:
+			kc.Operator = KeyGT
+		case tokenGE:
+			kc.Operator = KeyGE
+		default:
+			return KeyCondition{}, errorf(c.pos, "unsupported operator in key condition")
+		}
+		return kc, nil
+
+	case *between:
+		name, ok := c.operand.(*path)
+		lower, lok := c.lower.(*value)
+		upper, uok := c.upper.(*value)
+		if !ok || !lok || !uok || !name.isTopLevel() {
+			return KeyCondition{}, errorf(c.pos, "key condition must compare a key attribute with values")
+		}
+		return KeyCondition{
+			Name:     name.attributeName(),
+			Operator: KeyBetween,
+			Values:   []types.AttributeValue{lower.av, upper.av},
+		}, nil
+
+	case *function:
+		if c.name != "begins_with" {
+			return KeyCondition{}, errorf(c.pos, "unsupported function in key condition '%s'", c.name)
+		}
+		name, ok := c.args[0].(*path)
+		prefix, vok := c.args[1].(*value)
+		if !ok || !vok || !name.isTopLevel() {
+			return KeyCondition{}, errorf(c.pos, "begins_with must take a key attribute and a value")
+		}
+		return KeyCondition{
+			Name:     name.attributeName(),
+			Operator: KeyBeginsWith,
+			Values:   []types.AttributeValue{prefix.av},
+		}, nil
+	}
+	return KeyCondition{}, errorf(c.position(), "unsupported key condition")
+}
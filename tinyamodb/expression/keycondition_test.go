@@ -0,0 +1,76 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyCondition(t *testing.T) {
+	pk := &types.AttributeValueMemberS{Value: "USER#1"}
+	from := &types.AttributeValueMemberS{Value: "A"}
+	to := &types.AttributeValueMemberS{Value: "Z"}
+	values := map[string]types.AttributeValue{":pk": pk, ":from": from, ":to": to}
+	names := map[string]string{"#sk": "sk"}
+
+	test := map[string]struct {
+		expr string
+		want []KeyCondition
+	}{
+		"partition key": {
+			expr: "pk = :pk",
+			want: []KeyCondition{{Name: "pk", Operator: KeyEQ, Values: []types.AttributeValue{pk}}},
+		},
+		"reversed": {
+			expr: "(:pk = pk) and (:from < #sk)",
+			want: []KeyCondition{
+				{Name: "pk", Operator: KeyEQ, Values: []types.AttributeValue{pk}},
+				{Name: "sk", Operator: KeyGT, Values: []types.AttributeValue{from}},
+			},
+		},
+		"between": {
+			expr: "pk = :pk AND #sk BETWEEN :from AND :to",
+			want: []KeyCondition{
+				{Name: "pk", Operator: KeyEQ, Values: []types.AttributeValue{pk}},
+				{Name: "sk", Operator: KeyBetween, Values: []types.AttributeValue{from, to}},
+			},
+		},
+		"begins_with": {
+			expr: "pk=:pk AND begins_with ( sk, :from )",
+			want: []KeyCondition{
+				{Name: "pk", Operator: KeyEQ, Values: []types.AttributeValue{pk}},
+				{Name: "sk", Operator: KeyBeginsWith, Values: []types.AttributeValue{from}},
+			},
+		},
+	}
+	for name, tt := range test {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseKeyCondition(tt.expr, names, values)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	errs := map[string]int{
+		"":                                 0,
+		"pk = :undefined":                  5,
+		"pk = #undefined":                  5,
+		"pk <> :pk":                        3,
+		"pk = :pk AND pk = :pk":            16,
+		"pk = :pk AND sk = :from AND ":     28,
+		"pk = :pk OR sk = :from":           9,
+		"pk = :pk AND sk = sk":             16,
+		"pk = :pk AND contains(sk, :from)": 13,
+		"pk == :pk":                        4,
+		"pk = :pk AND $sk = :from":         13,
+	}
+	for expr, pos := range errs {
+		t.Run(expr, func(t *testing.T) {
+			_, err := ParseKeyCondition(expr, names, values)
+			var e *Error
+			require.ErrorAs(t, err, &e)
+			require.Equal(t, pos, e.Pos)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package expression
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF      tokenKind = iota
+	tokenIdent              // attribute name, keyword or function name
+	tokenName               // #name
+	tokenValue              // :value
+	tokenNumber             // list index
+	tokenEQ                 // =
+	tokenNE                 // <>
+	tokenLT                 // <
+	tokenLE                 // <=
+	tokenGT                 // >
+	tokenGE                 // >=
+	tokenLParen             // (
+	tokenRParen             // )
+	tokenComma              // ,
+	tokenDot                // .
+	tokenLBracket           // [
+	tokenRBracket           // ]
+	tokenPlus               // +
+	tokenMinus              // -
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// is reports whether the token is the keyword, case-insensitively.
+func (t token) is(keyword string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, keyword)
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(s) && isIdentPart(s[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: s[i:j], pos: i})
+			i = j
+			continue
+		case isDigit(c):
+			j := i + 1
+			for j < len(s) && isDigit(s[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: s[i:j], pos: i})
+			i = j
+			continue
+		case c == '#' || c == ':':
+			j := i + 1
+			for j < len(s) && isIdentPart(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, errorf(i, "invalid token '%c'", c)
+			}
+			kind := tokenName
+			if c == ':' {
+				kind = tokenValue
+			}
+			tokens = append(tokens, token{kind: kind, text: s[i:j], pos: i})
+			i = j
+			continue
+		}
+
+		kind, width := tokenEOF, 1
+		switch c {
+		case '=':
+			kind = tokenEQ
+		case '<':
+			kind = tokenLT
+			if i+1 < len(s) && s[i+1] == '=' {
+				kind, width = tokenLE, 2
+			} else if i+1 < len(s) && s[i+1] == '>' {
+				kind, width = tokenNE, 2
+			}
+		case '>':
+			kind = tokenGT
+			if i+1 < len(s) && s[i+1] == '=' {
+				kind, width = tokenGE, 2
+			}
+		case '(':
+			kind = tokenLParen
+		case ')':
+			kind = tokenRParen
+		case ',':
+			kind = tokenComma
+		case '.':
+			kind = tokenDot
+		case '[':
+			kind = tokenLBracket
+		case ']':
+			kind = tokenRBracket
+		case '+':
+			kind = tokenPlus
+		case '-':
+			kind = tokenMinus
+		default:
+			return nil, errorf(i, "invalid character '%c'", c)
+		}
+		tokens = append(tokens, token{kind: kind, text: s[i : i+width], pos: i})
+		i += width
+	}
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(s)})
+	return tokens, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
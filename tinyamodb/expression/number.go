@@ -0,0 +1,122 @@
+package expression
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxNumberDigits is the max num of the significant digits of a number.
+	maxNumberDigits = 38
+	// minNumberExponent and maxNumberExponent are the range of the exponent of a number
+	// in the scientific notation, such as 2 of "1.5E2".
+	minNumberExponent = -130
+	maxNumberExponent = 125
+)
+
+// parseNumber parses the value of a number attribute.
+func parseNumber(s string) (*big.Rat, bool) {
+	if !validNumber(s) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// validNumber reports whether s is a decimal number of DynamoDB: an optional sign, the digits
+// with an optional decimal point, and an optional exponent, within the precision and the range of the number.
+func validNumber(s string) bool {
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		s = s[1:]
+	}
+	mantissa, exponent, hasExponent := strings.Cut(strings.ToLower(s), "e")
+	intPart, fracPart, _ := strings.Cut(mantissa, ".")
+	digits := intPart + fracPart
+	if digits == "" || !isDigits(digits) {
+		return false
+	}
+	exp := 0
+	if hasExponent {
+		negative := strings.HasPrefix(exponent, "-")
+		if negative || strings.HasPrefix(exponent, "+") {
+			exponent = exponent[1:]
+		}
+		if exponent == "" || !isDigits(exponent) {
+			return false
+		}
+		exponent = strings.TrimLeft(exponent, "0")
+		if len(exponent) > 4 {
+			// far out of the range
+			return false
+		}
+		exp, _ = strconv.Atoi(exponent)
+		if negative {
+			exp = -exp
+		}
+	}
+	first := strings.IndexFunc(digits, func(r rune) bool { return r != '0' })
+	if first < 0 {
+		// zero
+		return true
+	}
+	last := strings.LastIndexFunc(digits, func(r rune) bool { return r != '0' })
+	if last-first+1 > maxNumberDigits {
+		return false
+	}
+	// the exponent of the first significant digit
+	exp += len(intPart) - 1 - first
+	return minNumberExponent <= exp && exp <= maxNumberExponent
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseNumber parses the value of a number attribute. ok is false when s is not a number.
+func ParseNumber(s string) (r *big.Rat, ok bool) {
+	return parseNumber(s)
+}
+
+// CanonicalNumber returns the number in the canonical decimal form,
+// so that the same numbers such as "1", "1.0" and "10E-1" have the same form.
+// ok is false when s is not a number.
+func CanonicalNumber(s string) (string, bool) {
+	r, ok := parseNumber(s)
+	if !ok {
+		return "", false
+	}
+	return formatNumber(r), true
+}
+
+// formatNumber formats the number in decimal without trailing zeros.
+func formatNumber(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	// the denominator of a decimal is 2^a * 5^b and it has max(a, b) decimal places.
+	d := new(big.Int).Set(r.Denom())
+	var scale int
+	for _, f := range []int64{10, 2, 5} {
+		m := new(big.Int)
+		for {
+			q, rem := new(big.Int).QuoRem(d, big.NewInt(f), m)
+			if rem.Sign() != 0 {
+				break
+			}
+			d = q
+			scale++
+		}
+	}
+	if d.Cmp(big.NewInt(1)) != 0 {
+		// not a decimal. 38 is the precision of the number of DynamoDB.
+		scale = 38
+	}
+	s := r.FloatString(scale)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
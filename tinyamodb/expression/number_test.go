@@ -0,0 +1,52 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalNumber(t *testing.T) {
+	test := map[string]string{
+		"1":        "1",
+		"1.0":      "1",
+		"10E-1":    "1",
+		"+1":       "1",
+		"-0.50":    "-0.5",
+		"0.000":    "0",
+		"1.25e2":   "125",
+		"12345e-8": "0.00012345",
+		".5":       "0.5",
+		"5.":       "5",
+		"-0":       "0",
+		"0e-200":   "0",
+		"1e+2":     "100",
+	}
+	for n, want := range test {
+		got, ok := CanonicalNumber(n)
+		require.True(t, ok, n)
+		require.Equal(t, want, got, n)
+	}
+	// 38 significant digits and the limits of the exponent
+	for _, n := range []string{
+		"12345678901234567890123456789012345678", "0.0012345678901234567890123456789012345678000",
+		"1E125", "-9.9e125", "10e-131", "1e-130",
+	} {
+		_, ok := CanonicalNumber(n)
+		require.True(t, ok, n)
+	}
+	for _, n := range []string{
+		"", "one", "1/2", "1e", ".", "-", "+", "e1", "1.2.3", "1e2.5", "--1", "1e+-2",
+		// not decimal
+		"0x10", "0b1", "0o7", "1_000", "Inf", "NaN",
+		// spaces
+		" 1", "1 ", "1 e2",
+		// more than 38 significant digits
+		"123456789012345678901234567890123456789", "1.00000000000000000000000000000000000001",
+		// out of the range
+		"1e126", "10e125", "1e-131", "0.1e-130", "1e999999999", "1e-999999999",
+	} {
+		_, ok := CanonicalNumber(n)
+		require.False(t, ok, n)
+	}
+}
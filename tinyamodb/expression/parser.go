@@ -0,0 +1,382 @@
+package expression
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type parser struct {
+	tokens []token
+	cur    int
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+func newParser(expr string, names map[string]string, values map[string]types.AttributeValue) (*parser, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return nil, errorf(0, "empty expression")
+	}
+	return &parser{
+		tokens: tokens,
+		names:  names,
+		values: values,
+	}, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.cur]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.cur]
+	if t.kind != tokenEOF {
+		p.cur++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, want string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, errorf(t.pos, "expected %s but got %s", want, t)
+	}
+	return t, nil
+}
+
+func (p *parser) expectEOF() error {
+	if t := p.peek(); t.kind != tokenEOF {
+		return errorf(t.pos, "unexpected %s", t)
+	}
+	return nil
+}
+
+// parseCondition parses 'condition OR condition ...'.
+func (p *parser) parseCondition() (condition, error) {
+	left, err := p.parseAndCondition()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("OR") {
+		t := p.next()
+		right, err := p.parseAndCondition()
+		if err != nil {
+			return nil, err
+		}
+		left = &or{pos: t.pos, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAndCondition parses 'condition AND condition ...'.
+func (p *parser) parseAndCondition() (condition, error) {
+	left, err := p.parseNotCondition()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("AND") {
+		t := p.next()
+		right, err := p.parseNotCondition()
+		if err != nil {
+			return nil, err
+		}
+		left = &and{pos: t.pos, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseNotCondition parses 'NOT condition'.
+func (p *parser) parseNotCondition() (condition, error) {
+	if t := p.peek(); t.is("NOT") {
+		p.next()
+		c, err := p.parseNotCondition()
+		if err != nil {
+			return nil, err
+		}
+		return &not{pos: t.pos, condition: c}, nil
+	}
+	return p.parsePrimaryCondition()
+}
+
+func (p *parser) parsePrimaryCondition() (condition, error) {
+	t := p.peek()
+	if t.kind == tokenLParen {
+		p.next()
+		c, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	if p.isFunction() && !t.is("size") {
+		return p.parseFunction()
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t = p.next()
+	switch {
+	case t.kind >= tokenEQ && t.kind <= tokenGE:
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind != tokenEQ && t.kind != tokenNE {
+			if err := checkComparable(t.text, left, right); err != nil {
+				return nil, err
+			}
+		}
+		return &comparison{pos: t.pos, op: t.kind, left: left, right: right}, nil
+	case t.is("BETWEEN"):
+		lower, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); !t.is("AND") {
+			return nil, errorf(t.pos, "expected AND but got %s", t)
+		}
+		upper, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkComparable("BETWEEN", left, lower, upper); err != nil {
+			return nil, err
+		}
+		l, lok := lower.(*value)
+		u, uok := upper.(*value)
+		if lok && uok {
+			if n, ok := compare(l.av, u.av); !ok || n > 0 {
+				return nil, errorf(t.pos, "the BETWEEN operator requires upper bound to be greater than or equal to lower bound")
+			}
+		}
+		return &between{pos: t.pos, operand: left, lower: lower, upper: upper}, nil
+	case t.is("IN"):
+		if _, err := p.expect(tokenLParen, "'('"); err != nil {
+			return nil, err
+		}
+		c := &in{pos: t.pos, operand: left}
+		for {
+			o, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			c.list = append(c.list, o)
+			t := p.next()
+			if t.kind == tokenRParen {
+				break
+			}
+			if t.kind != tokenComma {
+				return nil, errorf(t.pos, "expected ',' or ')' but got %s", t)
+			}
+		}
+		if len(c.list) > 100 {
+			return nil, errorf(t.pos, "IN takes up to 100 operands")
+		}
+		return c, nil
+	}
+	return nil, errorf(t.pos, "expected comparator but got %s", t)
+}
+
+// isFunction reports whether the next tokens are 'name('.
+func (p *parser) isFunction() bool {
+	return p.peek().kind == tokenIdent && p.tokens[p.cur+1].kind == tokenLParen
+}
+
+// checkComparable returns an error if a value operand is not a string, number or binary.
+// the operands of <, <=, >, >= and BETWEEN must be comparable.
+func checkComparable(operator string, operands ...operand) error {
+	for _, o := range operands {
+		v, ok := o.(*value)
+		if !ok {
+			continue
+		}
+		switch v.av.(type) {
+		case *types.AttributeValueMemberS, *types.AttributeValueMemberN, *types.AttributeValueMemberB:
+		default:
+			return errorf(v.pos, "incorrect operand type for operator or function; operator or function: %s, operand type: %s", operator, attributeType(v.av))
+		}
+	}
+	return nil
+}
+
+// functions are the functions of conditions and their number of arguments.
+var functions = map[string]int{
+	"attribute_exists":     1,
+	"attribute_not_exists": 1,
+	"attribute_type":       2,
+	"begins_with":          2,
+	"contains":             2,
+}
+
+func (p *parser) parseFunction() (condition, error) {
+	t := p.next()
+	name := strings.ToLower(t.text)
+	nargs, found := functions[name]
+	if !found {
+		return nil, errorf(t.pos, "invalid function name '%s'", t.text)
+	}
+	p.next() // (
+	f := &function{pos: t.pos, name: name}
+	for {
+		arg, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		f.args = append(f.args, arg)
+		t := p.next()
+		if t.kind == tokenRParen {
+			break
+		}
+		if t.kind != tokenComma {
+			return nil, errorf(t.pos, "expected ',' or ')' but got %s", t)
+		}
+	}
+	if len(f.args) != nargs {
+		return nil, errorf(f.pos, "incorrect number of operands for function '%s'", name)
+	}
+	if _, ok := f.args[0].(*path); !ok {
+		return nil, errorf(f.args[0].position(), "the first operand of function '%s' must be an attribute path", name)
+	}
+
+	switch name {
+	case "attribute_type":
+		v, ok := f.args[1].(*value)
+		if !ok {
+			return nil, errorf(f.args[1].position(), "the second operand of function '%s' must be an expression attribute value", name)
+		}
+		s, ok := v.av.(*types.AttributeValueMemberS)
+		if !ok || !slices.Contains(attributeTypes, s.Value) {
+			return nil, errorf(v.pos, "invalid attribute type for function '%s'", name)
+		}
+	case "begins_with":
+		if v, ok := f.args[1].(*value); ok {
+			switch v.av.(type) {
+			case *types.AttributeValueMemberS, *types.AttributeValueMemberB:
+			default:
+				return nil, errorf(v.pos, "incorrect operand type for operator or function; operator or function: %s, operand type: %s", name, attributeType(v.av))
+			}
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) parseOperand() (operand, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenIdent, tokenName:
+		if p.isFunction() {
+			return p.parseSize()
+		}
+		return p.parsePath()
+	case tokenValue:
+		p.next()
+		av, found := p.values[t.text]
+		if !found {
+			return nil, errorf(t.pos, "expression attribute value %s is not defined", t)
+		}
+		if err := checkValue(t.pos, av); err != nil {
+			return nil, err
+		}
+		return &value{pos: t.pos, av: av}, nil
+	}
+	return nil, errorf(t.pos, "expected operand but got %s", t)
+}
+
+// parseSize parses 'size(path)'. it is the only function which is an operand in conditions.
+func (p *parser) parseSize() (operand, error) {
+	t := p.next()
+	if !t.is("size") {
+		return nil, errorf(t.pos, "the function '%s' is not allowed here", t.text)
+	}
+	p.next() // (
+	o, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenRParen, "')'"); err != nil {
+		return nil, err
+	}
+	return &size{pos: t.pos, path: o}, nil
+}
+
+// checkValue returns an error if the expression attribute value has an invalid number.
+func checkValue(pos int, av types.AttributeValue) error {
+	var numbers []string
+	switch v := av.(type) {
+	case *types.AttributeValueMemberN:
+		numbers = []string{v.Value}
+	case *types.AttributeValueMemberNS:
+		numbers = v.Value
+	}
+	for _, n := range numbers {
+		if _, ok := parseNumber(n); !ok {
+			return errorf(pos, "invalid number '%s'", n)
+		}
+	}
+	return nil
+}
+
+// parsePath parses a document path such as 'a.#b[1]'.
+func (p *parser) parsePath() (*path, error) {
+	t := p.peek()
+	o := &path{pos: t.pos}
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	o.elements = append(o.elements, pathElement{name: name})
+	for {
+		switch p.peek().kind {
+		case tokenDot:
+			p.next()
+			name, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			o.elements = append(o.elements, pathElement{name: name})
+		case tokenLBracket:
+			p.next()
+			t, err := p.expect(tokenNumber, "list index")
+			if err != nil {
+				return nil, err
+			}
+			index, err := strconv.Atoi(t.text)
+			if err != nil {
+				return nil, errorf(t.pos, "invalid list index %s", t)
+			}
+			if _, err := p.expect(tokenRBracket, "']'"); err != nil {
+				return nil, err
+			}
+			o.elements = append(o.elements, pathElement{index: index, isIndex: true})
+		default:
+			return o, nil
+		}
+	}
+}
+
+// parseName parses an attribute name or an expression attribute name.
+func (p *parser) parseName() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenIdent:
+		return t.text, nil
+	case tokenName:
+		name, found := p.names[t.text]
+		if !found {
+			return "", errorf(t.pos, "expression attribute name %s is not defined", t)
+		}
+		return name, nil
+	}
+	return "", errorf(t.pos, "expected attribute name but got %s", t)
+}
@@ -0,0 +1,235 @@
+package expression
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// path is a document path such as 'a.b[1].c'.
+type path struct {
+	pos      int
+	elements []pathElement
+}
+
+type pathElement struct {
+	name string
+	// index of a list element when isIndex
+	index   int
+	isIndex bool
+}
+
+func (o *path) position() int { return o.pos }
+
+// attributeName returns the name of the top level attribute.
+func (o *path) attributeName() string {
+	return o.elements[0].name
+}
+
+func (o *path) isTopLevel() bool {
+	return len(o.elements) == 1
+}
+
+func (o *path) String() string {
+	var b strings.Builder
+	for i, e := range o.elements {
+		switch {
+		case e.isIndex:
+			fmt.Fprintf(&b, "[%d]", e.index)
+		case i > 0:
+			b.WriteString("." + e.name)
+		default:
+			b.WriteString(e.name)
+		}
+	}
+	return b.String()
+}
+
+// comparePath orders paths element by element. list indexes are compared as numbers.
+func comparePath(a, b *path) int {
+	for i := 0; i < len(a.elements) && i < len(b.elements); i++ {
+		ea, eb := a.elements[i], b.elements[i]
+		switch {
+		case ea.isIndex && eb.isIndex:
+			if ea.index != eb.index {
+				return ea.index - eb.index
+			}
+		case ea.isIndex != eb.isIndex:
+			if ea.isIndex {
+				return 1
+			}
+			return -1
+		default:
+			if c := strings.Compare(ea.name, eb.name); c != 0 {
+				return c
+			}
+		}
+	}
+	return len(a.elements) - len(b.elements)
+}
+
+// overlaps reports whether a path is the same as or the part of the other.
+func overlaps(a, b *path) bool {
+	n := min(len(a.elements), len(b.elements))
+	for i := range n {
+		if a.elements[i] != b.elements[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the value at the path, or nil if not found.
+func (o *path) get(item map[string]types.AttributeValue) types.AttributeValue {
+	var av types.AttributeValue = &types.AttributeValueMemberM{Value: item}
+	for _, e := range o.elements {
+		av = child(av, e)
+		if av == nil {
+			return nil
+		}
+	}
+	return av
+}
+
+func child(av types.AttributeValue, e pathElement) types.AttributeValue {
+	if e.isIndex {
+		l, ok := av.(*types.AttributeValueMemberL)
+		if !ok || e.index >= len(l.Value) {
+			return nil
+		}
+		return l.Value[e.index]
+	}
+	m, ok := av.(*types.AttributeValueMemberM)
+	if !ok {
+		return nil
+	}
+	return m.Value[e.name]
+}
+
+// set puts the value at the path. the parent of the path must exist.
+// an index beyond the end of a list appends the value.
+func (o *path) set(item map[string]types.AttributeValue, v types.AttributeValue) error {
+	parent := (&path{elements: o.elements[:len(o.elements)-1]}).get(item)
+	last := o.elements[len(o.elements)-1]
+	if last.isIndex {
+		l, ok := parent.(*types.AttributeValueMemberL)
+		if !ok {
+			return errorf(o.pos, "document path '%s' is invalid for update", o)
+		}
+		if last.index < len(l.Value) {
+			l.Value[last.index] = v
+		} else {
+			l.Value = append(l.Value, v)
+		}
+		return nil
+	}
+	m, ok := parent.(*types.AttributeValueMemberM)
+	if !ok {
+		return errorf(o.pos, "document path '%s' is invalid for update", o)
+	}
+	m.Value[last.name] = v
+	return nil
+}
+
+// remove deletes the value at the path if exists.
+// removing a list element shifts the following elements.
+func (o *path) remove(item map[string]types.AttributeValue) {
+	parent := (&path{elements: o.elements[:len(o.elements)-1]}).get(item)
+	last := o.elements[len(o.elements)-1]
+	if last.isIndex {
+		l, ok := parent.(*types.AttributeValueMemberL)
+		if !ok || last.index >= len(l.Value) {
+			return
+		}
+		l.Value = append(l.Value[:last.index:last.index], l.Value[last.index+1:]...)
+		return
+	}
+	if m, ok := parent.(*types.AttributeValueMemberM); ok {
+		delete(m.Value, last.name)
+	}
+}
+
+// copyItem returns a deep copy of the item.
+func copyItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	m := make(map[string]types.AttributeValue, len(item))
+	for k, av := range item {
+		m[k] = copyValue(av)
+	}
+	return m
+}
+
+func copyValue(av types.AttributeValue) types.AttributeValue {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberL:
+		l := make([]types.AttributeValue, len(v.Value))
+		for i, av := range v.Value {
+			l[i] = copyValue(av)
+		}
+		return &types.AttributeValueMemberL{Value: l}
+	case *types.AttributeValueMemberM:
+		return &types.AttributeValueMemberM{Value: copyItem(v.Value)}
+	}
+	// scalars and sets are replaced, not modified.
+	return av
+}
+
+// project returns the values at the paths keeping the structure of the item.
+// list elements in the result are ordered by their indexes without gaps.
+func project(item map[string]types.AttributeValue, paths []*path) map[string]types.AttributeValue {
+	root := &projectionNode{}
+	for _, o := range paths {
+		v := o.get(item)
+		if v == nil {
+			continue
+		}
+		n := root
+		for _, e := range o.elements {
+			if n.children == nil {
+				n.children = make(map[pathElement]*projectionNode)
+			}
+			c, found := n.children[e]
+			if !found {
+				c = &projectionNode{}
+				n.children[e] = c
+			}
+			n = c
+		}
+		n.value = v
+	}
+	if root.children == nil {
+		return map[string]types.AttributeValue{}
+	}
+	return root.attributeValue().(*types.AttributeValueMemberM).Value
+}
+
+type projectionNode struct {
+	value    types.AttributeValue
+	children map[pathElement]*projectionNode
+}
+
+func (n *projectionNode) attributeValue() types.AttributeValue {
+	if n.value != nil {
+		return copyValue(n.value)
+	}
+	elements := make([]pathElement, 0, len(n.children))
+	for e := range n.children {
+		elements = append(elements, e)
+	}
+	if elements[0].isIndex {
+		slices.SortFunc(elements, func(a, b pathElement) int {
+			return a.index - b.index
+		})
+		l := make([]types.AttributeValue, len(elements))
+		for i, e := range elements {
+			l[i] = n.children[e].attributeValue()
+		}
+		return &types.AttributeValueMemberL{Value: l}
+	}
+	m := make(map[string]types.AttributeValue, len(elements))
+	for _, e := range elements {
+		m[e.name] = n.children[e].attributeValue()
+	}
+	return &types.AttributeValueMemberM{Value: m}
+}
@@ -0,0 +1,52 @@
+package expression
+
+import "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+// Projection is a parsed projection expression such as 'a, b.c, d[1].e'.
+type Projection struct {
+	paths []*path
+}
+
+// ParseProjection parses a projection expression.
+func ParseProjection(expr string, names map[string]string) (_ *Projection, err error) {
+	defer func() { err = withExpression(err, "ProjectionExpression") }()
+	p, err := newParser(expr, names, nil)
+	if err != nil {
+		return nil, err
+	}
+	proj := &Projection{}
+	for {
+		o, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		for _, prev := range proj.paths {
+			if overlaps(prev, o) {
+				return nil, errorf(o.pos, "two document paths overlap: '%s' and '%s'", prev, o)
+			}
+		}
+		proj.paths = append(proj.paths, o)
+		if p.peek().kind != tokenComma {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectEOF(); err != nil {
+		return nil, err
+	}
+	return proj, nil
+}
+
+// Apply returns the attributes of the item in the projection.
+func (p *Projection) Apply(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	return project(item, p.paths)
+}
+
+// Attributes returns the names of the top level attributes in the projection.
+func (p *Projection) Attributes() []string {
+	names := make([]string, len(p.paths))
+	for i, o := range p.paths {
+		names[i] = o.attributeName()
+	}
+	return names
+}
@@ -0,0 +1,75 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjection(t *testing.T) {
+	s := func(v string) types.AttributeValue {
+		return &types.AttributeValueMemberS{Value: v}
+	}
+	item := map[string]types.AttributeValue{
+		"pk": s("USER#1"),
+		"a": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"b": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+				&types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"c": s("b0c"), "d": s("b0d")}},
+				&types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"c": s("b1c"), "d": s("b1d")}},
+				&types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"c": s("b2c"), "d": s("b2d")}},
+			}},
+			"e": s("e"),
+		}},
+		"f": s("f"),
+	}
+	names := map[string]string{"#a": "a"}
+
+	test := map[string]map[string]types.AttributeValue{
+		"pk, f": {"pk": s("USER#1"), "f": s("f")},
+		"#a.e":  {"a": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"e": s("e")}}},
+		"a.b[1].c": {"a": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"b": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+				&types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"c": s("b1c")}},
+			}},
+		}}},
+		"a.b[2].d, a.b[0].c, a.e": {"a": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"b": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+				&types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"c": s("b0c")}},
+				&types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"d": s("b2d")}},
+			}},
+			"e": s("e"),
+		}}},
+		"nothing, a.b[9], f.g": {},
+	}
+	for expr, want := range test {
+		t.Run(expr, func(t *testing.T) {
+			p, err := ParseProjection(expr, names)
+			require.NoError(t, err)
+			require.Equal(t, want, p.Apply(item))
+		})
+	}
+
+	p, err := ParseProjection("pk, #a.b[1].c, a.e", names)
+	require.NoError(t, err)
+	require.Equal(t, []string{"pk", "a", "a"}, p.Attributes())
+
+	errs := map[string]int{
+		"":        0,
+		"a, a.b":  3,
+		"a,":      2,
+		"a b":     2,
+		"#b":      0,
+		"a[-1]":   2,
+		"a = :v":  2,
+		"a.b[0]]": 6,
+	}
+	for expr, pos := range errs {
+		t.Run(expr, func(t *testing.T) {
+			_, err := ParseProjection(expr, names)
+			var e *Error
+			require.ErrorAs(t, err, &e)
+			require.Equal(t, pos, e.Pos)
+		})
+	}
+}
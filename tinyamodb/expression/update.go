@@ -0,0 +1,450 @@
+package expression
+
+import (
+	"bytes"
+	"math/big"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Update is a parsed update expression such as
+// 'SET a = :a, b = b + :one REMOVE c ADD d :d DELETE e :e'.
+type Update struct {
+	sets    []*setAction
+	removes []*path
+	adds    []*addAction
+	deletes []*addAction
+}
+
+type setAction struct {
+	path  *path
+	value operand
+}
+
+// addAction is an action of ADD and DELETE.
+type addAction struct {
+	path  *path
+	value *value
+}
+
+// ifNotExists is 'if_not_exists(path, operand)'.
+type ifNotExists struct {
+	pos     int
+	path    *path
+	operand operand
+}
+
+// listAppend is 'list_append(operand, operand)'.
+type listAppend struct {
+	pos         int
+	left, right operand
+}
+
+// arithmetic is 'operand + operand' and 'operand - operand'.
+type arithmetic struct {
+	pos         int
+	op          tokenKind
+	left, right operand
+}
+
+func (o *ifNotExists) position() int { return o.pos }
+func (o *listAppend) position() int  { return o.pos }
+func (o *arithmetic) position() int  { return o.pos }
+
+// ParseUpdate parses an update expression.
+func ParseUpdate(expr string, names map[string]string, values map[string]types.AttributeValue) (_ *Update, err error) {
+	defer func() { err = withExpression(err, "UpdateExpression") }()
+	p, err := newParser(expr, names, values)
+	if err != nil {
+		return nil, err
+	}
+	u := &Update{}
+	seen := make(map[string]bool)
+	var paths []*path
+	for p.peek().kind != tokenEOF {
+		t := p.next()
+		clause := strings.ToUpper(t.text)
+		if t.kind != tokenIdent || (clause != "SET" && clause != "REMOVE" && clause != "ADD" && clause != "DELETE") {
+			return nil, errorf(t.pos, "expected SET, REMOVE, ADD or DELETE but got %s", t)
+		}
+		if seen[clause] {
+			return nil, errorf(t.pos, "the %s section can only be used once", clause)
+		}
+		seen[clause] = true
+
+		for {
+			switch clause {
+			case "SET":
+				a, err := p.parseSetAction()
+				if err != nil {
+					return nil, err
+				}
+				u.sets = append(u.sets, a)
+				paths = append(paths, a.path)
+			case "REMOVE":
+				o, err := p.parsePath()
+				if err != nil {
+					return nil, err
+				}
+				u.removes = append(u.removes, o)
+				paths = append(paths, o)
+			case "ADD", "DELETE":
+				a, err := p.parseAddAction()
+				if err != nil {
+					return nil, err
+				}
+				if clause == "ADD" {
+					u.adds = append(u.adds, a)
+				} else {
+					u.deletes = append(u.deletes, a)
+				}
+				paths = append(paths, a.path)
+			}
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	for i, a := range paths {
+		for _, b := range paths[i+1:] {
+			if overlaps(a, b) {
+				return nil, errorf(b.pos, "two document paths overlap: '%s' and '%s'", a, b)
+			}
+		}
+	}
+	return u, nil
+}
+
+func (p *parser) parseSetAction() (*setAction, error) {
+	o, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenEQ, "'='"); err != nil {
+		return nil, err
+	}
+	left, err := p.parseSetOperand()
+	if err != nil {
+		return nil, err
+	}
+	a := &setAction{path: o, value: left}
+	if t := p.peek(); t.kind == tokenPlus || t.kind == tokenMinus {
+		p.next()
+		right, err := p.parseSetOperand()
+		if err != nil {
+			return nil, err
+		}
+		a.value = &arithmetic{pos: t.pos, op: t.kind, left: left, right: right}
+	}
+	return a, nil
+}
+
+// parseSetOperand parses an operand including the functions of SET action.
+func (p *parser) parseSetOperand() (operand, error) {
+	t := p.peek()
+	if t.kind != tokenIdent || p.tokens[p.cur+1].kind != tokenLParen {
+		return p.parseOperand()
+	}
+	p.next()
+	p.next() // (
+	name := strings.ToLower(t.text)
+	var o operand
+	switch name {
+	case "if_not_exists":
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenComma, "','"); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseSetOperand()
+		if err != nil {
+			return nil, err
+		}
+		o = &ifNotExists{pos: t.pos, path: path, operand: operand}
+	case "list_append":
+		left, err := p.parseSetOperand()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenComma, "','"); err != nil {
+			return nil, err
+		}
+		right, err := p.parseSetOperand()
+		if err != nil {
+			return nil, err
+		}
+		o = &listAppend{pos: t.pos, left: left, right: right}
+	default:
+		return nil, errorf(t.pos, "invalid function name '%s' in SET action", t.text)
+	}
+	if _, err := p.expect(tokenRParen, "')'"); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (p *parser) parseAddAction() (*addAction, error) {
+	o, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	if !o.isTopLevel() {
+		return nil, errorf(o.pos, "ADD and DELETE support only top level attributes")
+	}
+	t := p.peek()
+	v, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	value, ok := v.(*value)
+	if !ok {
+		return nil, errorf(t.pos, "expected expression attribute value but got %s", t)
+	}
+	return &addAction{path: o, value: value}, nil
+}
+
+// Apply returns the item updated by the expression. the item is not modified.
+func (u *Update) Apply(item map[string]types.AttributeValue) (_ map[string]types.AttributeValue, err error) {
+	defer func() { err = withExpression(err, "UpdateExpression") }()
+	updated := copyItem(item)
+
+	// the values are evaluated with the item before the update.
+	values := make([]types.AttributeValue, len(u.sets))
+	for i, a := range u.sets {
+		v, err := evalSetOperand(a.value, item)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = copyValue(v)
+	}
+	for i, a := range u.sets {
+		if err := a.path.set(updated, values[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	// remove list elements from the end not to shift the others.
+	removes := slices.Clone(u.removes)
+	slices.SortFunc(removes, func(a, b *path) int {
+		return comparePath(b, a)
+	})
+	for _, o := range removes {
+		o.remove(updated)
+	}
+
+	for _, a := range u.adds {
+		v, err := add(a.path.pos, a.path.get(updated), a.value.av)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.path.set(updated, v); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range u.deletes {
+		v, err := deleteFromSet(a.path.pos, a.path.get(updated), a.value.av)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			a.path.remove(updated)
+		} else if err := a.path.set(updated, v); err != nil {
+			return nil, err
+		}
+	}
+	return updated, nil
+}
+
+// Updated returns the attributes of the item at the paths updated by the expression.
+func (u *Update) Updated(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	var paths []*path
+	for _, a := range u.sets {
+		paths = append(paths, a.path)
+	}
+	paths = append(paths, u.removes...)
+	for _, a := range u.adds {
+		paths = append(paths, a.path)
+	}
+	for _, a := range u.deletes {
+		paths = append(paths, a.path)
+	}
+	return project(item, paths)
+}
+
+func evalSetOperand(o operand, item map[string]types.AttributeValue) (types.AttributeValue, error) {
+	switch o := o.(type) {
+	case *value:
+		return o.av, nil
+	case *path:
+		v := o.get(item)
+		if v == nil {
+			return nil, errorf(o.pos, "the attribute '%s' does not exist in the item", o)
+		}
+		return v, nil
+	case *ifNotExists:
+		if v := o.path.get(item); v != nil {
+			return v, nil
+		}
+		return evalSetOperand(o.operand, item)
+	case *listAppend:
+		left, err := evalSetOperand(o.left, item)
+		if err != nil {
+			return nil, err
+		}
+		right, err := evalSetOperand(o.right, item)
+		if err != nil {
+			return nil, err
+		}
+		l, lok := left.(*types.AttributeValueMemberL)
+		r, rok := right.(*types.AttributeValueMemberL)
+		if !lok || !rok {
+			return nil, errorf(o.pos, "incorrect operand type for list_append")
+		}
+		return &types.AttributeValueMemberL{Value: slices.Concat(l.Value, r.Value)}, nil
+	case *arithmetic:
+		left, err := evalSetOperand(o.left, item)
+		if err != nil {
+			return nil, err
+		}
+		right, err := evalSetOperand(o.right, item)
+		if err != nil {
+			return nil, err
+		}
+		l, lok := numberOf(left)
+		r, rok := numberOf(right)
+		if !lok || !rok {
+			return nil, errorf(o.pos, "incorrect operand type for operator %s", o.opString())
+		}
+		if o.op == tokenPlus {
+			l.Add(l, r)
+		} else {
+			l.Sub(l, r)
+		}
+		return &types.AttributeValueMemberN{Value: formatNumber(l)}, nil
+	}
+	return nil, errorf(o.position(), "unsupported operand")
+}
+
+func (o *arithmetic) opString() string {
+	if o.op == tokenPlus {
+		return "+"
+	}
+	return "-"
+}
+
+// add is the ADD action. it adds numbers or unions sets.
+func add(pos int, cur, v types.AttributeValue) (types.AttributeValue, error) {
+	if cur == nil {
+		switch v.(type) {
+		case *types.AttributeValueMemberN, *types.AttributeValueMemberSS,
+			*types.AttributeValueMemberNS, *types.AttributeValueMemberBS:
+			return v, nil
+		}
+		return nil, errorf(pos, "incorrect operand type for ADD")
+	}
+	switch v := v.(type) {
+	case *types.AttributeValueMemberN:
+		c, cok := numberOf(cur)
+		n, nok := numberOf(v)
+		if cok && nok {
+			return &types.AttributeValueMemberN{Value: formatNumber(c.Add(c, n))}, nil
+		}
+	case *types.AttributeValueMemberSS:
+		if c, ok := cur.(*types.AttributeValueMemberSS); ok {
+			return &types.AttributeValueMemberSS{Value: union(c.Value, v.Value, equalString)}, nil
+		}
+	case *types.AttributeValueMemberNS:
+		if c, ok := cur.(*types.AttributeValueMemberNS); ok {
+			return &types.AttributeValueMemberNS{Value: union(c.Value, v.Value, equalNumber)}, nil
+		}
+	case *types.AttributeValueMemberBS:
+		if c, ok := cur.(*types.AttributeValueMemberBS); ok {
+			return &types.AttributeValueMemberBS{Value: union(c.Value, v.Value, bytes.Equal)}, nil
+		}
+	}
+	return nil, errorf(pos, "incorrect operand type for ADD")
+}
+
+// deleteFromSet is the DELETE action. it returns nil when the set becomes empty or does not exist.
+func deleteFromSet(pos int, cur, v types.AttributeValue) (types.AttributeValue, error) {
+	switch v := v.(type) {
+	case *types.AttributeValueMemberSS:
+		if cur == nil {
+			return nil, nil
+		}
+		if c, ok := cur.(*types.AttributeValueMemberSS); ok {
+			if s := difference(c.Value, v.Value, equalString); len(s) > 0 {
+				return &types.AttributeValueMemberSS{Value: s}, nil
+			}
+			return nil, nil
+		}
+	case *types.AttributeValueMemberNS:
+		if cur == nil {
+			return nil, nil
+		}
+		if c, ok := cur.(*types.AttributeValueMemberNS); ok {
+			if s := difference(c.Value, v.Value, equalNumber); len(s) > 0 {
+				return &types.AttributeValueMemberNS{Value: s}, nil
+			}
+			return nil, nil
+		}
+	case *types.AttributeValueMemberBS:
+		if cur == nil {
+			return nil, nil
+		}
+		if c, ok := cur.(*types.AttributeValueMemberBS); ok {
+			if s := difference(c.Value, v.Value, bytes.Equal); len(s) > 0 {
+				return &types.AttributeValueMemberBS{Value: s}, nil
+			}
+			return nil, nil
+		}
+	}
+	return nil, errorf(pos, "incorrect operand type for DELETE")
+}
+
+func union[T any](a, b []T, equal func(T, T) bool) []T {
+	s := slices.Clone(a)
+	for _, v := range b {
+		if !slices.ContainsFunc(s, func(e T) bool { return equal(e, v) }) {
+			s = append(s, v)
+		}
+	}
+	return s
+}
+
+func difference[T any](a, b []T, equal func(T, T) bool) []T {
+	var s []T
+	for _, v := range a {
+		if !slices.ContainsFunc(b, func(e T) bool { return equal(e, v) }) {
+			s = append(s, v)
+		}
+	}
+	return s
+}
+
+func numberOf(av types.AttributeValue) (*big.Rat, bool) {
+	n, ok := av.(*types.AttributeValueMemberN)
+	if !ok {
+		return nil, false
+	}
+	return parseNumber(n.Value)
+}
+
+func equalString(a, b string) bool {
+	return a == b
+}
+
+func equalNumber(a, b string) bool {
+	ra, aok := parseNumber(a)
+	rb, bok := parseNumber(b)
+	if !aok || !bok {
+		return a == b
+	}
+	return ra.Cmp(rb) == 0
+}
@@ -0,0 +1,163 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdate(t *testing.T) {
+	item := func() map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"pk":    &types.AttributeValueMemberS{Value: "USER#1"},
+			"count": &types.AttributeValueMemberN{Value: "1.5"},
+			"tags":  &types.AttributeValueMemberSS{Value: []string{"a", "b"}},
+			"nums":  &types.AttributeValueMemberNS{Value: []string{"1", "2"}},
+			"list": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+				&types.AttributeValueMemberS{Value: "x"},
+				&types.AttributeValueMemberS{Value: "y"},
+				&types.AttributeValueMemberS{Value: "z"},
+			}},
+			"doc": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"name": &types.AttributeValueMemberS{Value: "taro"},
+			}},
+		}
+	}
+	values := map[string]types.AttributeValue{
+		":one":   &types.AttributeValueMemberN{Value: "1"},
+		":tenth": &types.AttributeValueMemberN{Value: "0.1"},
+		":s":     &types.AttributeValueMemberS{Value: "new"},
+		":tags":  &types.AttributeValueMemberSS{Value: []string{"b", "c"}},
+		":nums":  &types.AttributeValueMemberNS{Value: []string{"2.0", "1"}},
+		":list":  &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberS{Value: "w"}}},
+	}
+	names := map[string]string{"#c": "count", "#n": "name"}
+
+	test := map[string]struct {
+		expr string
+		want func(map[string]types.AttributeValue)
+	}{
+		"set": {
+			expr: "SET a = :s, doc.#n = :s, doc.age = :one, list[1] = :s, list[10] = :one",
+			want: func(m map[string]types.AttributeValue) {
+				m["a"] = values[":s"]
+				doc := m["doc"].(*types.AttributeValueMemberM).Value
+				doc["name"] = values[":s"]
+				doc["age"] = values[":one"]
+				list := m["list"].(*types.AttributeValueMemberL)
+				list.Value[1] = values[":s"]
+				list.Value = append(list.Value, values[":one"])
+			},
+		},
+		"arithmetic": {
+			expr: "SET #c = #c + :tenth, b = :one - #c",
+			want: func(m map[string]types.AttributeValue) {
+				m["count"] = &types.AttributeValueMemberN{Value: "1.6"}
+				m["b"] = &types.AttributeValueMemberN{Value: "-0.5"}
+			},
+		},
+		"if_not_exists": {
+			expr: "SET a = if_not_exists(a, :one), #c = if_not_exists(#c, :one)",
+			want: func(m map[string]types.AttributeValue) {
+				m["a"] = values[":one"]
+			},
+		},
+		"list_append": {
+			expr: "SET list = list_append(:list, list), l = list_append(if_not_exists(l, :list), :list)",
+			want: func(m map[string]types.AttributeValue) {
+				list := m["list"].(*types.AttributeValueMemberL)
+				list.Value = append([]types.AttributeValue{values[":list"].(*types.AttributeValueMemberL).Value[0]}, list.Value...)
+				m["l"] = &types.AttributeValueMemberL{Value: []types.AttributeValue{
+					&types.AttributeValueMemberS{Value: "w"}, &types.AttributeValueMemberS{Value: "w"},
+				}}
+			},
+		},
+		"remove": {
+			expr: "REMOVE #c, list[0], list[2], doc.#n, nothing",
+			want: func(m map[string]types.AttributeValue) {
+				delete(m, "count")
+				list := m["list"].(*types.AttributeValueMemberL)
+				list.Value = list.Value[1:2]
+				delete(m["doc"].(*types.AttributeValueMemberM).Value, "name")
+			},
+		},
+		"add": {
+			expr: "ADD #c :one, tags :tags, nums :nums, new :one",
+			want: func(m map[string]types.AttributeValue) {
+				m["count"] = &types.AttributeValueMemberN{Value: "2.5"}
+				m["tags"] = &types.AttributeValueMemberSS{Value: []string{"a", "b", "c"}}
+				m["new"] = values[":one"]
+			},
+		},
+		"delete": {
+			expr: "DELETE tags :tags, nums :nums, nothing :tags",
+			want: func(m map[string]types.AttributeValue) {
+				m["tags"] = &types.AttributeValueMemberSS{Value: []string{"a"}}
+				delete(m, "nums")
+			},
+		},
+		"clauses": {
+			expr: "add #c :one set a = :s remove doc delete tags :tags",
+			want: func(m map[string]types.AttributeValue) {
+				m["count"] = &types.AttributeValueMemberN{Value: "2.5"}
+				m["a"] = values[":s"]
+				delete(m, "doc")
+				m["tags"] = &types.AttributeValueMemberSS{Value: []string{"a"}}
+			},
+		},
+	}
+	for name, tt := range test {
+		t.Run(name, func(t *testing.T) {
+			u, err := ParseUpdate(tt.expr, names, values)
+			require.NoError(t, err)
+			before := item()
+			got, err := u.Apply(before)
+			require.NoError(t, err)
+			want := item()
+			tt.want(want)
+			require.Equal(t, want, got)
+			// not modified
+			require.Equal(t, item(), before)
+		})
+	}
+
+	parseErrs := map[string]int{
+		"SET a = :s SET b = :s":      11,
+		"SET a = :s, a = :one":       12,
+		"SET doc = :s REMOVE doc.#n": 20,
+		"ADD doc.#n :one":            4,
+		"DELETE tags tags":           12,
+		"SET a = size(b)":            8,
+		"UPSERT a = :s":              0,
+		"SET a[x] = :s":              6,
+	}
+	for expr, pos := range parseErrs {
+		t.Run(expr, func(t *testing.T) {
+			_, err := ParseUpdate(expr, names, values)
+			var e *Error
+			require.ErrorAs(t, err, &e)
+			require.Equal(t, pos, e.Pos)
+		})
+	}
+
+	applyErrs := map[string]int{
+		"SET a = nothing":                8,
+		"SET a = pk + :one":              11,
+		"SET a = list_append(pk, :list)": 8,
+		"SET nothing.a = :s":             4,
+		"ADD pk :one":                    4,
+		"ADD tags :nums":                 4,
+		"DELETE tags :one":               7,
+	}
+	for expr, pos := range applyErrs {
+		t.Run(expr, func(t *testing.T) {
+			u, err := ParseUpdate(expr, names, values)
+			require.NoError(t, err)
+			_, err = u.Apply(item())
+			var e *Error
+			require.ErrorAs(t, err, &e)
+			require.Equal(t, pos, e.Pos)
+		})
+	}
+}
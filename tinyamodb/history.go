@@ -0,0 +1,71 @@
+package tinyamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var ErrInvalidStartVersion = errors.New("invalid exclusive start version")
+
+// GetItemHistory returns the versions of the item stored in the segments, newest first by their timestamps,
+// and the later written first on the same timestamp. the first version is the one GetItem reads.
+// a delete is a version without the item. the versions are read in pages by Limit and ExclusiveStartVersion.
+func (db *Db) GetItemHistory(ctx context.Context, key map[string]types.AttributeValue, opts *GetItemHistoryOptions) (*GetItemHistoryOutput, error) {
+	if opts == nil {
+		opts = &GetItemHistoryOptions{}
+	}
+	t, err := db.table(opts.TableName)
+	if err != nil {
+		return nil, err
+	}
+	defer t.release()
+	item, err := NewTinyamoDbItem(key, t.c)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := t.determinePartition(item.sha256PartitionKey).History(item)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ExclusiveStartVersion != "" {
+		n := -1
+		for i, v := range versions {
+			if v.String() == opts.ExclusiveStartVersion {
+				n = i
+				break
+			}
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("%w: '%s'", ErrInvalidStartVersion, opts.ExclusiveStartVersion)
+		}
+		versions = versions[n+1:]
+	}
+
+	output := &GetItemHistoryOutput{Versions: []ItemVersion{}}
+	for i, v := range versions {
+		if opts.Limit > 0 && i == int(opts.Limit) {
+			output.LastEvaluatedVersion = versions[i-1].String()
+			break
+		}
+		version := ItemVersion{
+			Version:   v.String(),
+			Item:      v.item.Item,
+			Timestamp: time.Unix(0, v.item.UnixNano),
+			Deleted:   v.item.deleted != 0,
+		}
+		if v.item.deleted == systemDelete {
+			version.UserIdentity = &Identity{PrincipalId: ttlPrincipal, Type: "Service"}
+		}
+		output.Versions = append(output.Versions, version)
+	}
+	return output, nil
+}
+
+// String returns the version as '<segment id>-<position in the store>'.
+func (v itemVersion) String() string {
+	return fmt.Sprintf("%d-%d", v.segment, v.pos)
+}
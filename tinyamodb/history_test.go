@@ -0,0 +1,229 @@
+package tinyamodb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetItemHistory(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-history")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Partition.Num = 2
+	// a few records in a segment
+	c.Segment.MaxStoreBytes = 128
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.PartitionKey = "pk"
+	c.TimeToLive.SweepInterval = -1
+	db, err := New(dir, c)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	key := map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: "A"}}
+	item := func(pk string, v int) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: pk},
+			"v":  &types.AttributeValueMemberN{Value: fmt.Sprint(v)},
+		}
+	}
+	put := func(pk string, v int) {
+		_, err := db.PutItem(ctx, &PutItemInput{Item: item(pk, v)})
+		require.NoError(t, err)
+	}
+	put("A", 1)
+	put("B", 1)
+	put("A", 2)
+	_, err = db.DeleteItem(ctx, &DeleteItemInput{Key: key})
+	require.NoError(t, err)
+	put("A", 3)
+	put("B", 2)
+	_, err = db.UpdateItem(ctx, &UpdateItemInput{
+		Key:                       key,
+		UpdateExpression:          "SET v = :v",
+		ExpressionAttributeValues: map[string]types.AttributeValue{":v": &types.AttributeValueMemberN{Value: "1"}},
+	})
+	require.NoError(t, err)
+	// expired by TTL
+	s := "v"
+	enabled := true
+	_, err = db.UpdateTimeToLive(ctx, &UpdateTimeToLiveInput{
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{AttributeName: &s, Enabled: &enabled},
+	})
+	require.NoError(t, err)
+	_, err = db.sweep()
+	require.NoError(t, err)
+	tbl, err := db.table("")
+	require.NoError(t, err)
+	require.Greater(t, len(tbl.partitions[1].segments)+len(tbl.partitions[2].segments), 2)
+	tbl.release()
+
+	check := func() {
+		output, err := db.GetItemHistory(ctx, key, nil)
+		require.NoError(t, err)
+		require.Len(t, output.Versions, 6)
+		require.Empty(t, output.LastEvaluatedVersion)
+
+		deleted := func(v ItemVersion) ItemVersion {
+			require.True(t, v.Deleted)
+			require.Nil(t, v.Item)
+			return v
+		}
+		require.Equal(t, &Identity{PrincipalId: "dynamodb.amazonaws.com", Type: "Service"}, deleted(output.Versions[0]).UserIdentity)
+		require.Equal(t, item("A", 1), output.Versions[1].Item)
+		require.Equal(t, item("A", 3), output.Versions[2].Item)
+		require.Nil(t, deleted(output.Versions[3]).UserIdentity)
+		require.Equal(t, item("A", 2), output.Versions[4].Item)
+		require.Equal(t, item("A", 1), output.Versions[5].Item)
+		for i, v := range output.Versions {
+			require.False(t, v.Timestamp.IsZero())
+			require.WithinDuration(t, time.Now(), v.Timestamp, time.Minute)
+			if i > 0 {
+				require.False(t, v.Timestamp.After(output.Versions[i-1].Timestamp))
+			}
+		}
+
+		// pages
+		var got []ItemVersion
+		var start string
+		for {
+			output, err := db.GetItemHistory(ctx, key, &GetItemHistoryOptions{Limit: 4, ExclusiveStartVersion: start})
+			require.NoError(t, err)
+			got = append(got, output.Versions...)
+			if output.LastEvaluatedVersion == "" {
+				break
+			}
+			start = output.LastEvaluatedVersion
+		}
+		require.Equal(t, output.Versions, got)
+
+		b, err := db.GetItemHistory(ctx, map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: "B"}}, nil)
+		require.NoError(t, err)
+		require.Len(t, b.Versions, 3)
+		require.True(t, b.Versions[0].Deleted)
+		require.Equal(t, item("B", 2), b.Versions[1].Item)
+	}
+	check()
+	got, err := db.GetItem(ctx, &GetItemInput{Key: key})
+	require.NoError(t, err)
+	require.Nil(t, got.Item)
+
+	// reopen
+	require.NoError(t, db.Close())
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+	check()
+
+	none, err := db.GetItemHistory(ctx, map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: "C"}}, nil)
+	require.NoError(t, err)
+	require.Empty(t, none.Versions)
+	_, err = db.GetItemHistory(ctx, key, &GetItemHistoryOptions{ExclusiveStartVersion: "9-9"})
+	require.ErrorIs(t, err, ErrInvalidStartVersion)
+	_, err = db.GetItemHistory(ctx, key, &GetItemHistoryOptions{TableName: "unknown"})
+	require.ErrorIs(t, err, ErrTableNotFound)
+
+	// a version of an earlier time is not read, and is listed after the newer versions. 2100-01-01 is not expired
+	tbl, err = db.table("")
+	require.NoError(t, err)
+	defer tbl.release()
+	stale, err := NewTinyamoDbItem(item("A", 4102444800), tbl.c)
+	require.NoError(t, err)
+	stale.UnixNano = time.Now().Add(-time.Hour).UnixNano()
+	_, err = tbl.determinePartition(stale.sha256PartitionKey).Put(stale)
+	require.NoError(t, err)
+	got, err = db.GetItem(ctx, &GetItemInput{Key: key})
+	require.NoError(t, err)
+	require.Nil(t, got.Item)
+	output, err := db.GetItemHistory(ctx, key, nil)
+	require.NoError(t, err)
+	require.True(t, output.Versions[0].Deleted)
+	require.Len(t, output.Versions, 7)
+	require.Equal(t, item("A", 4102444800), output.Versions[6].Item)
+	require.Equal(t, time.Unix(0, stale.UnixNano), output.Versions[6].Timestamp)
+}
+
+func TestGetItemAsOf(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-asof")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Partition.Num = 2
+	// a few records in a segment
+	c.Segment.MaxStoreBytes = 128
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.PartitionKey = "pk"
+	c.TimeToLive.SweepInterval = -1
+	db, err := New(dir, c)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	key := map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: "A"}}
+	item := func(v int) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: "A"},
+			"v":  &types.AttributeValueMemberN{Value: fmt.Sprint(v)},
+		}
+	}
+	// times between the writes
+	var times []time.Time
+	mark := func() {
+		time.Sleep(time.Millisecond)
+		times = append(times, time.Now())
+		time.Sleep(time.Millisecond)
+	}
+	mark()
+	for v := 1; v <= 3; v++ {
+		_, err := db.PutItem(ctx, &PutItemInput{Item: item(v)})
+		require.NoError(t, err)
+		mark()
+	}
+	_, err = db.DeleteItem(ctx, &DeleteItemInput{Key: key})
+	require.NoError(t, err)
+	mark()
+	_, err = db.PutItem(ctx, &PutItemInput{Item: item(4)})
+	require.NoError(t, err)
+	mark()
+
+	check := func() {
+		want := []map[string]types.AttributeValue{nil, item(1), item(2), item(3), nil, item(4)}
+		for i, asOf := range times {
+			got, err := db.GetItem(ctx, &GetItemInput{Key: key, AsOf: asOf})
+			require.NoError(t, err)
+			require.Equal(t, want[i], got.Item, i)
+		}
+		got, err := db.GetItem(ctx, &GetItemInput{Key: key, AsOf: times[2], ProjectionExpression: "v"})
+		require.NoError(t, err)
+		require.Equal(t, map[string]types.AttributeValue{"v": &types.AttributeValueMemberN{Value: "2"}}, got.Item)
+	}
+	check()
+
+	// reopen
+	require.NoError(t, db.Close())
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+	check()
+
+	// expired at the time by TTL
+	s := "v"
+	enabled := true
+	_, err = db.UpdateTimeToLive(ctx, &UpdateTimeToLiveInput{
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{AttributeName: &s, Enabled: &enabled},
+	})
+	require.NoError(t, err)
+	got, err := db.GetItem(ctx, &GetItemInput{Key: key, AsOf: times[1]})
+	require.NoError(t, err)
+	require.Nil(t, got.Item)
+	got, err = db.GetItem(ctx, &GetItemInput{Key: key, AsOf: time.Unix(0, 0)})
+	require.NoError(t, err)
+	require.Nil(t, got.Item)
+}
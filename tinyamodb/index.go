@@ -3,9 +3,9 @@ package tinyamodb
 import (
 	"bufio"
 	"encoding/binary"
-	"errors"
 	"io"
 	"os"
+	"strings"
 	"sync"
 )
 
@@ -38,6 +38,10 @@ func newIndex(f *os.File) (*index, error) {
 	if err := idx.setup(); err != nil && err != io.EOF {
 		return nil, err
 	}
+	// the file is not opened with O_APPEND. new entries are written after the existing ones.
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return nil, err
+	}
 	return idx, nil
 }
 
@@ -59,6 +63,17 @@ func (i *index) Read(in string) (pos uint64, err error) {
 	return poss[len(poss)-1], nil
 }
 
+// ReadAll returns the positions written for the key in the order of the writes.
+func (i *index) ReadAll(in string) ([]uint64, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.size == 0 {
+		return nil, io.EOF
+	}
+	return i.readAll(in)
+}
+
 func (i *index) Write(in string, pos uint64) error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
@@ -78,44 +93,34 @@ func (i *index) Write(in string, pos uint64) error {
 	return nil
 }
 
-func (i *index) Delete(in string) (poss []uint64, err error) {
+// Keys returns the keys written in the index.
+func (i *index) Keys() []string {
 	i.mu.Lock()
 	defer i.mu.Unlock()
-
-	if i.size == 0 {
-		return nil, io.EOF
-	}
-
-	if err := i.buf.Flush(); err != nil {
-		return nil, err
+	keys := make([]string, 0, len(i.mmap))
+	for k := range i.mmap {
+		keys = append(keys, k)
 	}
+	return keys
+}
 
-	if in == "" {
-		// latest
-		in = i.latestKey
+// filter drops the positions not kept by keep.
+func (i *index) filter(keep func(pos uint64) bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	all := make(map[string][]uint64, len(i.mmap))
+	for k := range i.mmap {
+		all[k], _ = i.readAll(k)
 	}
-
-	poss, err = i.readAll(in)
-	delete(i.mmap, in)
-	delete(i.dmap, in)
-
-	for off := uint64(0); off < i.size; off += entwidth {
-		k := make([]byte, keyWidth)
-		_, err = i.file.ReadAt(k, int64(off))
-		if err != nil {
-			return
-		}
-		key := string(k)
-		if key != in {
-			continue
-		}
-		empty := make([]byte, entwidth)
-		_, err = i.file.WriteAt(empty, int64(off))
-		if err != nil {
-			return
+	i.mmap = make(map[string]uint64, len(all))
+	i.dmap = make(map[string][]uint64)
+	for k, poss := range all {
+		for _, pos := range poss {
+			if keep(pos) {
+				i.writeMem(k, pos)
+			}
 		}
 	}
-	return
 }
 
 func (i *index) Flush() error {
@@ -124,6 +129,16 @@ func (i *index) Flush() error {
 	return i.buf.Flush()
 }
 
+// Sync flushes the buffer and writes the file to the disk.
+func (i *index) Sync() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.buf.Flush(); err != nil {
+		return err
+	}
+	return i.file.Sync()
+}
+
 func (i *index) Close() error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
@@ -165,7 +180,7 @@ func (i *index) writeMem(in string, pos uint64) {
 func (i *index) readAll(in string) (poss []uint64, err error) {
 	pos, ok := i.mmap[in]
 	if !ok {
-		return nil, errors.New("not found")
+		return nil, io.EOF
 	}
 	if pos != 1 {
 		poss = make([]uint64, 1)
@@ -195,7 +210,8 @@ func (i *index) setup() error {
 		if err != nil {
 			return err
 		}
-		key := string(k)
+		// deleted entries are filled with zero
+		key := strings.TrimRight(string(k), "\x00")
 		if key == "" {
 			continue
 		}
@@ -32,13 +32,10 @@ func TestIndex(t *testing.T) {
 
 	err = idx.Write(key+"z", 4)
 	require.NoError(t, err)
-	_, err = idx.Delete(key + "z")
+	pos, err := idx.Read(key + "z")
 	require.NoError(t, err)
+	require.Equal(t, uint64(4), pos)
 	testReadIndex(t, idx)
-
-	testDeleteIndex(t, idx)
-	require.Equal(t, 0, len(idx.mmap))
-	require.Equal(t, 0, len(idx.dmap))
 }
 
 func testWriteIndex(t *testing.T, idx *index) {
@@ -67,12 +64,3 @@ func testReadIndex(t *testing.T, idx *index) {
 		require.Equal(t, i*rate, pos)
 	}
 }
-
-func testDeleteIndex(t *testing.T, idx *index) {
-	for i := uint64(1); i < 4; i++ {
-		k := fmt.Sprintf("%s%d", key, i)
-		ppos, err := idx.Delete(k)
-		require.NoError(t, err)
-		require.Equal(t, []uint64{i * 10, i * 11}, ppos)
-	}
-}
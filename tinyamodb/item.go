@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/yyyoichi/tinyamodb/tinyamodb/expression"
 )
 
 var (
@@ -20,45 +21,130 @@ var (
 type Item interface {
 	SHA256Key() []byte
 	StrSHA2526Key() string
+	StrSHA256PartitionKey() string
+	SortKey() types.AttributeValue
 	Value() ([]byte, error)
 	Unmarshal([]byte) error
 }
 
 var (
 	ErrNotFoundPartitionKey    = errors.New("not found partition key")
-	ErrInvalidPartitionKeyType = errors.New("partition key must be 'string' type")
+	ErrInvalidPartitionKeyType = errors.New("partition key type does not match the key schema")
+	ErrNotFoundSortKey         = errors.New("not found sort key")
+	ErrInvalidSortKeyType      = errors.New("sort key type does not match the key schema")
 	ErrCannotUnmarshal         = errors.New("cannot unmarshal")
+	ErrValueTooLong            = errors.New("string, binary, set, list or map is too long to be stored")
 )
 
 type tinyamodbItem struct {
+	// sha256Key identifies the item. it is the hash of the partition key,
+	// or of the partition key and the sort key if the table has a sort key.
 	sha256Key    []byte
 	strSha256Key string
-	Item         map[string]types.AttributeValue
-	UnixNano     int64
+	// sha256PartitionKey is the hash of the partition key only.
+	// items sharing a partition key are stored in the same partition.
+	sha256PartitionKey    []byte
+	strSha256PartitionKey string
+	sortKey               types.AttributeValue
+	Item                  map[string]types.AttributeValue
+	UnixNano              int64
+	// deleted is the kind of the delete if the item is a tombstone, the record of a deleted key.
+	deleted deleteKind
+	// decodeAttributes are the top level attributes decoded by Unmarshal. nil decodes all.
+	decodeAttributes map[string]struct{}
 }
 
 func NewTinyamoDbItem(item map[string]types.AttributeValue, c Config) (*tinyamodbItem, error) {
-	var av types.AttributeValue
-	for key, v := range item {
-		if key == c.Table.PartitionKey {
-			av = v
-			break
-		}
-	}
-	if av == nil {
+	av, found := item[c.Table.PartitionKey]
+	if !found || av == nil {
 		return nil, ErrNotFoundPartitionKey
 	}
-	avs, ok := (av).(*types.AttributeValueMemberS)
+	pkb, ok := keyBytes(av, c.Table.PartitionKeyType)
 	if !ok {
 		return nil, ErrInvalidPartitionKeyType
 	}
-	key, strKey := sum256([]byte(avs.Value))
+	pkey, strPKey := sum256(pkb)
+	i := &tinyamodbItem{
+		sha256Key:             pkey,
+		strSha256Key:          strPKey,
+		sha256PartitionKey:    pkey,
+		strSha256PartitionKey: strPKey,
+		Item:                  item,
+		UnixNano:              time.Now().UnixNano(),
+	}
+	if c.Table.SortKey == "" {
+		return i, nil
+	}
+
+	av, found = item[c.Table.SortKey]
+	if !found || av == nil {
+		return nil, ErrNotFoundSortKey
+	}
+	skb, ok := keyBytes(av, c.Table.SortKeyType)
+	if !ok {
+		return nil, ErrInvalidSortKeyType
+	}
+	i.sortKey = av
+	i.sha256Key, i.strSha256Key = sum256(joinKey(pkb, skb))
+	return i, nil
+}
+
+// deleteKind is the kind of the delete recorded by a tombstone.
+type deleteKind byte
+
+const (
+	// userDelete is a delete requested by the APIs.
+	userDelete deleteKind = iota + 1
+	// systemDelete is a delete of an expired item by the TTL sweeper.
+	systemDelete
+)
+
+// newTombstone returns the tombstone of the key of the item.
+func newTombstone(key Item, kind deleteKind) *tinyamodbItem {
 	return &tinyamodbItem{
-		sha256Key:    key,
-		strSha256Key: strKey,
-		Item:         item,
-		UnixNano:     time.Now().UnixNano(),
-	}, nil
+		sha256Key:             key.SHA256Key(),
+		strSha256Key:          key.StrSHA2526Key(),
+		strSha256PartitionKey: key.StrSHA256PartitionKey(),
+		sortKey:               key.SortKey(),
+		UnixNano:              time.Now().UnixNano(),
+		deleted:               kind,
+	}
+}
+
+func hashPartitionKey(av types.AttributeValue, c Config) ([]byte, string, error) {
+	b, ok := keyBytes(av, c.Table.PartitionKeyType)
+	if !ok {
+		return nil, "", ErrInvalidPartitionKeyType
+	}
+	key, strKey := sum256(b)
+	return key, strKey, nil
+}
+
+// keyBytes returns the bytes identifying the key attribute value.
+// ok is false when the value is not of the key type. empty type is S.
+// numbers are canonicalized so that "1", "1.0" and "10E-1" are the same key.
+func keyBytes(av types.AttributeValue, t types.ScalarAttributeType) (b []byte, ok bool) {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return []byte(v.Value), t == "" || t == types.ScalarAttributeTypeS
+	case *types.AttributeValueMemberN:
+		n, ok := expression.CanonicalNumber(v.Value)
+		return []byte(n), ok && t == types.ScalarAttributeTypeN
+	case *types.AttributeValueMemberB:
+		return v.Value, t == types.ScalarAttributeTypeB
+	}
+	return nil, false
+}
+
+// keyAttributes returns the primary key attributes of the item.
+func keyAttributes(item map[string]types.AttributeValue, c Config) map[string]types.AttributeValue {
+	key := map[string]types.AttributeValue{
+		c.Table.PartitionKey: item[c.Table.PartitionKey],
+	}
+	if c.Table.SortKey != "" {
+		key[c.Table.SortKey] = item[c.Table.SortKey]
+	}
+	return key
 }
 
 func (i *tinyamodbItem) SHA256Key() []byte {
@@ -67,8 +153,21 @@ func (i *tinyamodbItem) SHA256Key() []byte {
 func (i *tinyamodbItem) StrSHA2526Key() string {
 	return i.strSha256Key
 }
+func (i *tinyamodbItem) StrSHA256PartitionKey() string {
+	return i.strSha256PartitionKey
+}
+func (i *tinyamodbItem) SortKey() types.AttributeValue {
+	return i.sortKey
+}
 func (i *tinyamodbItem) Value() ([]byte, error) {
 	var buf = new(bytes.Buffer)
+	if i.deleted != 0 {
+		if err := binary.Write(buf, enc, uint64(i.UnixNano)); err != nil {
+			return nil, err
+		}
+		buf.Write([]byte{_bd, byte(i.deleted)})
+		return buf.Bytes(), nil
+	}
 	var e encoder
 	err := e.Encode(&types.AttributeValueMemberM{Value: i.Item}, i.UnixNano, buf)
 	if err != nil {
@@ -77,8 +176,15 @@ func (i *tinyamodbItem) Value() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 func (i *tinyamodbItem) Unmarshal(data []byte) error {
+	if len(data) == 10 && data[8] == _bd {
+		i.UnixNano = int64(enc.Uint64(data))
+		i.Item = nil
+		i.deleted = deleteKind(data[9])
+		return nil
+	}
+	i.deleted = 0
 	var r = bytes.NewReader(data)
-	var d decoder
+	var d = decoder{attributes: i.decodeAttributes}
 	av, unixNano, err := d.Decode(r)
 	if err != nil {
 		return err
@@ -103,6 +209,7 @@ const (
 	_bu = byte('u') // NULL
 	_bl = byte('l') // list
 	_bm = byte('m') // map
+	_bd = byte('d') // tombstone followed by the deleteKind
 )
 
 type encoder struct{}
@@ -181,15 +288,27 @@ func (e *encoder) encode(av types.AttributeValue, w io.Writer) error {
 
 func (e *encoder) encodeString(v string, w io.Writer) error {
 	bv := []byte(v)
-	if _, err := w.Write([]byte{byte(len(bv))}); err != nil {
+	if err := e.encodeLen(len(bv), w); err != nil {
 		return err
 	}
 	_, err := w.Write(bv)
 	return err
 }
 
+// maxEncodedLen is the max length of a string, a binary, a set, a list or a map encoded in a byte.
+const maxEncodedLen = 255
+
+// encodeLen writes the length n in a byte. it returns ErrValueTooLong if n is over maxEncodedLen.
+func (e *encoder) encodeLen(n int, w io.Writer) error {
+	if n > maxEncodedLen {
+		return fmt.Errorf("%w: length %d is over %d", ErrValueTooLong, n, maxEncodedLen)
+	}
+	_, err := w.Write([]byte{byte(n)})
+	return err
+}
+
 func (e *encoder) encodeSSet(v []string, w io.Writer) error {
-	if _, err := w.Write([]byte{byte(len(v))}); err != nil {
+	if err := e.encodeLen(len(v), w); err != nil {
 		return err
 	}
 	for _, s := range v {
@@ -201,7 +320,7 @@ func (e *encoder) encodeSSet(v []string, w io.Writer) error {
 }
 
 func (e *encoder) encodeBytes(v []byte, w io.Writer) error {
-	if _, err := w.Write([]byte{byte(len(v))}); err != nil {
+	if err := e.encodeLen(len(v), w); err != nil {
 		return err
 	}
 	_, err := w.Write(v)
@@ -209,7 +328,7 @@ func (e *encoder) encodeBytes(v []byte, w io.Writer) error {
 }
 
 func (e *encoder) encodeBSet(v [][]byte, w io.Writer) error {
-	if _, err := w.Write([]byte{byte(len(v))}); err != nil {
+	if err := e.encodeLen(len(v), w); err != nil {
 		return err
 	}
 	for _, b := range v {
@@ -232,7 +351,7 @@ func (e *encoder) encodeBool(v bool, w io.Writer) error {
 }
 
 func (e *encoder) encodeList(v []types.AttributeValue, w io.Writer) error {
-	if _, err := w.Write([]byte{byte(len(v))}); err != nil {
+	if err := e.encodeLen(len(v), w); err != nil {
 		return err
 	}
 	for _, av := range v {
@@ -244,7 +363,7 @@ func (e *encoder) encodeList(v []types.AttributeValue, w io.Writer) error {
 }
 
 func (e *encoder) encodeMap(v map[string]types.AttributeValue, w io.Writer) error {
-	if _, err := w.Write([]byte{byte(len(v))}); err != nil {
+	if err := e.encodeLen(len(v), w); err != nil {
 		return err
 	}
 	for k, av := range v {
@@ -258,7 +377,10 @@ func (e *encoder) encodeMap(v map[string]types.AttributeValue, w io.Writer) erro
 	return nil
 }
 
-type decoder struct{}
+type decoder struct {
+	// attributes are the top level attributes to decode. nil decodes all.
+	attributes map[string]struct{}
+}
 
 func (d *decoder) Decode(r io.Reader) (types.AttributeValue, int64, error) {
 	var unixNanoB = make([]byte, 8)
@@ -426,6 +548,11 @@ func (d *decoder) decodeList(r io.Reader) ([]types.AttributeValue, error) {
 }
 
 func (d *decoder) decodeMap(r io.Reader) (map[string]types.AttributeValue, error) {
+	// nested maps are decoded entirely.
+	attributes := d.attributes
+	d.attributes = nil
+	defer func() { d.attributes = attributes }()
+
 	l, err := d.decodeLen(r)
 	if err != nil {
 		return nil, err
@@ -436,6 +563,12 @@ func (d *decoder) decodeMap(r io.Reader) (map[string]types.AttributeValue, error
 		if err != nil {
 			return nil, err
 		}
+		if _, found := attributes[s]; attributes != nil && !found {
+			if err := d.skip(r); err != nil {
+				return nil, err
+			}
+			continue
+		}
 		av, err := d.decode(r)
 		if err != nil {
 			return nil, err
@@ -445,6 +578,67 @@ func (d *decoder) decodeMap(r io.Reader) (map[string]types.AttributeValue, error
 	return v, nil
 }
 
+// skip reads an attribute value without decoding.
+func (d *decoder) skip(r io.Reader) error {
+	var _bx = make([]byte, 1)
+	if _, err := r.Read(_bx); err != nil {
+		return err
+	}
+	switch _bx[0] {
+	case _bs, _bn, _bb:
+		return d.skipBytes(r)
+	case _bS, _bN, _bB:
+		l, err := d.decodeLen(r)
+		if err != nil {
+			return err
+		}
+		for range l {
+			if err := d.skipBytes(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	case _bo, _bu:
+		_, err := d.decodeBool(r)
+		return err
+	case _bl:
+		l, err := d.decodeLen(r)
+		if err != nil {
+			return err
+		}
+		for range l {
+			if err := d.skip(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	case _bm:
+		l, err := d.decodeLen(r)
+		if err != nil {
+			return err
+		}
+		for range l {
+			if err := d.skipBytes(r); err != nil {
+				return err
+			}
+			if err := d.skip(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unexpected identifier: '%v'", string(_bx[0]))
+}
+
+func (d *decoder) skipBytes(r io.Reader) error {
+	l, err := d.decodeLen(r)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(io.Discard, r, int64(l))
+	return err
+}
+
 func (d *decoder) decodeLen(r io.Reader) (int, error) {
 	bl := make([]byte, 1)
 	if _, err := r.Read(bl); err != nil {
@@ -453,6 +647,63 @@ func (d *decoder) decodeLen(r io.Reader) (int, error) {
 	return int(bl[0]), nil
 }
 
+// itemSize approximates the size of the item in the way of DynamoDB.
+func itemSize(item map[string]types.AttributeValue) int {
+	size := 0
+	for name, av := range item {
+		size += len(name) + attributeValueSize(av)
+	}
+	return size
+}
+
+func attributeValueSize(av types.AttributeValue) int {
+	size := 0
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		size = len(v.Value)
+	case *types.AttributeValueMemberSS:
+		for _, s := range v.Value {
+			size += len(s)
+		}
+	case *types.AttributeValueMemberN:
+		size = len(v.Value)/2 + 1
+	case *types.AttributeValueMemberNS:
+		for _, n := range v.Value {
+			size += len(n)/2 + 1
+		}
+	case *types.AttributeValueMemberB:
+		size = len(v.Value)
+	case *types.AttributeValueMemberBS:
+		for _, b := range v.Value {
+			size += len(b)
+		}
+	case *types.AttributeValueMemberBOOL, *types.AttributeValueMemberNULL:
+		size = 1
+	case *types.AttributeValueMemberL:
+		size = 3
+		for _, av := range v.Value {
+			size += 1 + attributeValueSize(av)
+		}
+	case *types.AttributeValueMemberM:
+		size = 3
+		for name, av := range v.Value {
+			size += 1 + len(name) + attributeValueSize(av)
+		}
+	}
+	return size
+}
+
+// joinKey concatenates key values with their length so that
+// ("ab", "c") and ("a", "bc") never produce the same bytes.
+func joinKey(values ...[]byte) []byte {
+	var b []byte
+	for _, v := range values {
+		b = enc.AppendUint64(b, uint64(len(v)))
+		b = append(b, v...)
+	}
+	return b
+}
+
 func sum256(data []byte) (sha256Key []byte, strSha256Key string) {
 	h := sha256.Sum256(data)
 	sha256Key = h[:]
@@ -112,4 +112,34 @@ func TestItem(t *testing.T) {
 			})
 		}
 	})
+	t.Run("projected attributes", func(t *testing.T) {
+		t.Parallel()
+		test := map[string]types.AttributeValue{
+			"string":     &types.AttributeValueMemberS{Value: "test string"},
+			"string set": &types.AttributeValueMemberSS{Value: []string{"test", "string", "set"}},
+			"number":     &types.AttributeValueMemberN{Value: "20.14"},
+			"bytes set":  &types.AttributeValueMemberBS{Value: [][]byte{[]byte("test"), []byte("bytes")}},
+			"bool":       &types.AttributeValueMemberBOOL{Value: true},
+			"null":       &types.AttributeValueMemberNULL{Value: true},
+			"list": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+				&types.AttributeValueMemberS{Value: "string1"},
+				&types.AttributeValueMemberNS{Value: []string{"1", "2"}},
+			}},
+			"map": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"string": &types.AttributeValueMemberS{Value: "nested"},
+				"number": &types.AttributeValueMemberN{Value: "1"},
+			}},
+		}
+		var b = new(bytes.Buffer)
+		err := e.Encode(&types.AttributeValueMemberM{Value: test}, time.Now().UnixNano(), b)
+		require.NoError(t, err)
+
+		d := decoder{attributes: map[string]struct{}{"number": {}, "map": {}, "missing": {}}}
+		got, _, err := d.Decode(b)
+		require.NoError(t, err)
+		require.Equal(t, &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"number": test["number"],
+			"map":    test["map"],
+		}}, got)
+	})
 }
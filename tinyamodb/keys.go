@@ -0,0 +1,171 @@
+package tinyamodb
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/yyyoichi/tinyamodb/tinyamodb/expression"
+)
+
+// keyEntry locates an item of a partition.
+type keyEntry struct {
+	partitionKey string // sha-256 of the partition key
+	sortKey      types.AttributeValue
+	key          string // sha-256 of the primary key
+}
+
+func newKeyEntry(item Item) keyEntry {
+	return keyEntry{
+		partitionKey: item.StrSHA256PartitionKey(),
+		sortKey:      item.SortKey(),
+		key:          item.StrSHA2526Key(),
+	}
+}
+
+// sortedKeys keeps the live keys of a partition ordered by partition key and sort key.
+// it is guarded by the lock of the partition.
+type sortedKeys struct {
+	entries []keyEntry
+}
+
+func (k *sortedKeys) Insert(e keyEntry) {
+	i, found := slices.BinarySearchFunc(k.entries, e, compareKeyEntry)
+	if found {
+		return
+	}
+	k.entries = slices.Insert(k.entries, i, e)
+}
+
+func (k *sortedKeys) Remove(e keyEntry) {
+	i, found := slices.BinarySearchFunc(k.entries, e, compareKeyEntry)
+	if !found {
+		return
+	}
+	k.entries = slices.Delete(k.entries, i, i+1)
+}
+
+// Range returns the entries sharing the partition key in sort key order.
+func (k *sortedKeys) Range(partitionKey string) []keyEntry {
+	start, _ := slices.BinarySearchFunc(k.entries, partitionKey, func(e keyEntry, pk string) int {
+		return strings.Compare(e.partitionKey, pk)
+	})
+	end := start
+	for end < len(k.entries) && k.entries[end].partitionKey == partitionKey {
+		end++
+	}
+	return k.entries[start:end]
+}
+
+// Between returns the entries whose partition key is lower or greater and less than upper.
+// empty lower or upper is unbounded.
+func (k *sortedKeys) Between(lower, upper string) []keyEntry {
+	start, end := 0, len(k.entries)
+	if lower != "" {
+		start = sort.Search(len(k.entries), func(i int) bool {
+			return k.entries[i].partitionKey >= lower
+		})
+	}
+	if upper != "" {
+		end = sort.Search(len(k.entries), func(i int) bool {
+			return k.entries[i].partitionKey >= upper
+		})
+	}
+	if start >= end {
+		return nil
+	}
+	return k.entries[start:end]
+}
+
+// Query returns the entries sharing the partition key whose sort key satisfies the condition.
+// cond may be nil.
+func (k *sortedKeys) Query(partitionKey string, cond *expression.KeyCondition) []keyEntry {
+	entries := k.Range(partitionKey)
+	if cond == nil {
+		return entries
+	}
+	// lower returns the index of the first entry whose sort key is v or greater.
+	lower := func(v types.AttributeValue) int {
+		return sort.Search(len(entries), func(i int) bool {
+			return compareSortKey(entries[i].sortKey, v) >= 0
+		})
+	}
+	// upper returns the index of the first entry whose sort key is greater than v.
+	upper := func(v types.AttributeValue) int {
+		return sort.Search(len(entries), func(i int) bool {
+			return compareSortKey(entries[i].sortKey, v) > 0
+		})
+	}
+
+	start, end := 0, len(entries)
+	v := cond.Values[0]
+	switch cond.Operator {
+	case expression.KeyEQ:
+		start, end = lower(v), upper(v)
+	case expression.KeyLT:
+		end = lower(v)
+	case expression.KeyLE:
+		end = upper(v)
+	case expression.KeyGT:
+		start = upper(v)
+	case expression.KeyGE:
+		start = lower(v)
+	case expression.KeyBetween:
+		start, end = lower(v), upper(cond.Values[1])
+	case expression.KeyBeginsWith:
+		start = lower(v)
+		end = start
+		for end < len(entries) && hasSortKeyPrefix(entries[end].sortKey, v) {
+			end++
+		}
+	}
+	if start >= end {
+		return nil
+	}
+	return entries[start:end]
+}
+
+func (k *sortedKeys) Len() int {
+	return len(k.entries)
+}
+
+func compareKeyEntry(a, b keyEntry) int {
+	if c := strings.Compare(a.partitionKey, b.partitionKey); c != 0 {
+		return c
+	}
+	if c := compareSortKey(a.sortKey, b.sortKey); c != 0 {
+		return c
+	}
+	return strings.Compare(a.key, b.key)
+}
+
+// compareSortKey orders sort key values. strings are ordered by their UTF-8 bytes,
+// numbers by their values and binaries by their bytes.
+func compareSortKey(a, b types.AttributeValue) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	n, _ := expression.Compare(a, b)
+	return n
+}
+
+func hasSortKeyPrefix(av, prefix types.AttributeValue) bool {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		p, ok := prefix.(*types.AttributeValueMemberS)
+		return ok && strings.HasPrefix(v.Value, p.Value)
+	case *types.AttributeValueMemberB:
+		p, ok := prefix.(*types.AttributeValueMemberB)
+		return ok && bytes.HasPrefix(v.Value, p.Value)
+	}
+	return false
+}
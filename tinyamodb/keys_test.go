@@ -0,0 +1,40 @@
+package tinyamodb
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortedKeys(t *testing.T) {
+	var k sortedKeys
+	entry := func(pk, sk string) keyEntry {
+		return keyEntry{
+			partitionKey: pk,
+			sortKey:      &types.AttributeValueMemberS{Value: sk},
+			key:          pk + "#" + sk,
+		}
+	}
+
+	k.Insert(entry("b", "2"))
+	k.Insert(entry("a", "3"))
+	k.Insert(entry("b", "1"))
+	k.Insert(entry("a", "1"))
+	k.Insert(entry("b", "10"))
+	// duplication
+	k.Insert(entry("b", "1"))
+	require.Equal(t, 5, k.Len())
+
+	got := k.Range("b")
+	require.Equal(t, []keyEntry{entry("b", "1"), entry("b", "10"), entry("b", "2")}, got)
+	got = k.Range("a")
+	require.Equal(t, []keyEntry{entry("a", "1"), entry("a", "3")}, got)
+	require.Empty(t, k.Range("c"))
+
+	k.Remove(entry("b", "10"))
+	k.Remove(entry("c", "1"))
+	require.Equal(t, 4, k.Len())
+	got = k.Range("b")
+	require.Equal(t, []keyEntry{entry("b", "1"), entry("b", "2")}, got)
+}
@@ -1,6 +1,9 @@
 package tinyamodb
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -9,27 +12,76 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/yyyoichi/tinyamodb/tinyamodb/expression"
 )
 
+// maxPageSize is the size of the items read at once by Query and Scan.
+const maxPageSize = 1024 * 1024
+
+// page limits the items read at once.
+type page struct {
+	limit int // 0 is unlimited
+	count int
+	size  int
+}
+
+func newPage(limit int32) *page {
+	return &page{limit: int(limit)}
+}
+
+func (pg *page) Full() bool {
+	return (pg.limit > 0 && pg.count >= pg.limit) || pg.size >= maxPageSize
+}
+
+func (pg *page) Add(item *tinyamodbItem) {
+	pg.count++
+	pg.size += itemSize(item.Item)
+}
+
 type partition struct {
-	mu     sync.RWMutex
+	// mu is shared with the partitions of the local secondary indexes.
+	mu     *sync.RWMutex
 	dir    string
 	config Config
+	// newItem returns the item keyed by the key schema of the partition.
+	newItem func(item map[string]types.AttributeValue) (*tinyamodbItem, error)
+	// indexes are the secondary indexes updated on the writes of the partition.
+	indexes []*secondaryIndex
+	// stream is the shard recording the writes of the partition. nil is disabled.
+	stream *streamShard
 
 	activeSegment *segment
 	segments      []*segment
+	// synced is the number of the segments synced and not written since.
+	synced int
+	keys   sortedKeys
+	// latest is the newest record of each key as read resolves them, deletes included.
+	// it is nil on the partitions opened read-only, which are read as of a time.
+	latest map[string]record
 }
 
 func newPartition(dir string, id int, c Config) (*partition, error) {
+	return openPartition(dir, id, c, func(item map[string]types.AttributeValue) (*tinyamodbItem, error) {
+		return NewTinyamoDbItem(item, c)
+	})
+}
+
+// openPartition opens the partition whose items are keyed by newItem.
+func openPartition(dir string, id int, c Config, newItem func(map[string]types.AttributeValue) (*tinyamodbItem, error)) (*partition, error) {
 	if c.Segment.MaxStoreBytes == 0 {
-		c.Segment.MaxStoreBytes = 1024
+		c.Segment.MaxStoreBytes = defaultMaxStoreBytes
 	}
 	if c.Segment.MaxIndexBytes == 0 {
-		c.Segment.MaxIndexBytes = 1024
+		c.Segment.MaxIndexBytes = defaultMaxIndexBytes
 	}
 	p := &partition{
-		dir:    fmt.Sprintf("%s/%d", dir, id),
-		config: c,
+		mu:      new(sync.RWMutex),
+		dir:     fmt.Sprintf("%s/%d", dir, id),
+		config:  c,
+		newItem: newItem,
+		latest:  make(map[string]record),
 	}
 	if _, err := os.Stat(p.dir); err != nil {
 		if err = os.Mkdir(p.dir, 0755); err != nil {
@@ -40,10 +92,239 @@ func newPartition(dir string, id int, c Config) (*partition, error) {
 	return p, p.setup()
 }
 
+// openPartitionReadOnly opens the segments of the partition to read the records, without writing to the files.
+// the partition may be written by another process. the records it has not flushed yet are not read.
+func openPartitionReadOnly(dir string, id int, c Config) (_ *partition, err error) {
+	p := &partition{
+		mu:     new(sync.RWMutex),
+		dir:    fmt.Sprintf("%s/%d", dir, id),
+		config: c,
+	}
+	defer func() {
+		if err != nil {
+			p.Close()
+		}
+	}()
+	segmentIds, err := readSegmentIds(p.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range segmentIds {
+		s, err := openSegment(p.dir, id, c, os.O_RDONLY, os.O_RDONLY)
+		if err != nil {
+			return nil, err
+		}
+		p.segments = append(p.segments, s)
+		s.dropUnwritten()
+	}
+	return p, nil
+}
+
+// Put writes the item and returns the previous one, or nil if not found.
 func (p *partition) Put(item Item) (old Item, err error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return nil, p.write(item)
+	old, err = p.get(item)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.commit(item, old, item); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// writeRequest is a put of the item, or a delete of the key of the item.
+type writeRequest struct {
+	item   Item
+	delete bool
+}
+
+// WriteBatch writes the requests in order under one lock until ctx is done,
+// and returns the number of the requests written.
+func (p *partition) WriteBatch(ctx context.Context, requests []writeRequest) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for n, r := range requests {
+		if ctx.Err() != nil {
+			return n, nil
+		}
+		if err := p.apply(r); err != nil {
+			return n, err
+		}
+	}
+	return len(requests), nil
+}
+
+// apply writes the request. the caller must hold the lock.
+func (p *partition) apply(r writeRequest) error {
+	var old Item
+	if len(p.indexes) > 0 || p.stream != nil || r.delete {
+		var err error
+		if old, err = p.get(r.item); err != nil {
+			return err
+		}
+	}
+	if r.delete {
+		// stamped as the request like the items put, so a transaction stamps its deletes with its writes.
+		tombstone := newTombstone(r.item, userDelete)
+		tombstone.UnixNano = r.item.(*tinyamodbItem).UnixNano
+		return p.commit(r.item, old, tombstone)
+	}
+	return p.commit(r.item, old, r.item)
+}
+
+// recover writes the request of a transaction not committed before a crash. the request may have been written
+// before the crash: then its record is the newest of the key stamped with the request, and it is not written again.
+// the secondary indexes are updated from the record before it, and the stream record is appended if missing.
+// the caller must hold the lock.
+func (p *partition) recover(r writeRequest) error {
+	item := r.item.(*tinyamodbItem)
+	records, err := p.records(item.strSha256Key)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 || records[0].unixNano != item.UnixNano {
+		return p.apply(r)
+	}
+	written, err := p.readRecord(item, records[0])
+	if err != nil {
+		return err
+	}
+	if (written.deleted != 0) != r.delete {
+		return p.apply(r)
+	}
+	var old Item
+	if len(records) > 1 {
+		prev, err := p.readRecord(item, records[1])
+		if err != nil {
+			return err
+		}
+		if prev.deleted == 0 {
+			old = prev
+		}
+	}
+	if written.deleted != 0 && old == nil {
+		// nothing was deleted
+		return nil
+	}
+	entries, err := p.indexEntries(written)
+	if err != nil {
+		return err
+	}
+	if err := p.updateIndexes(old, entries, written.UnixNano); err != nil {
+		return err
+	}
+	if p.stream == nil {
+		return nil
+	}
+	found, err := p.stream.has(written)
+	if err != nil || found {
+		return err
+	}
+	return p.stream.append(old, written)
+}
+
+// commit writes the item, or deletes the key when item is nil or a tombstone, and updates the secondary
+// indexes from old, the current item. nil item is a user delete. the caller must hold the lock.
+func (p *partition) commit(key, old, item Item) error {
+	if item == nil {
+		item = newTombstone(key, userDelete)
+	}
+	deleted := item.(*tinyamodbItem).deleted != 0
+	if deleted && old == nil {
+		// nothing to delete
+		return nil
+	}
+	if r, found := p.latest[key.StrSHA2526Key()]; found && item.(*tinyamodbItem).UnixNano < r.unixNano {
+		// older than the newest record. it is kept in the history, and never read as the newest.
+		return p.write(item)
+	}
+	// the item is not written if it cannot be indexed.
+	entries, err := p.indexEntries(item.(*tinyamodbItem))
+	if err != nil {
+		return err
+	}
+
+	if deleted {
+		if err := p.delete(item); err != nil {
+			return err
+		}
+	} else {
+		if err := p.write(item); err != nil {
+			return err
+		}
+		p.keys.Insert(newKeyEntry(item))
+	}
+	if err := p.updateIndexes(old, entries, item.(*tinyamodbItem).UnixNano); err != nil {
+		return err
+	}
+	if p.stream != nil {
+		return p.stream.append(old, item)
+	}
+	return nil
+}
+
+// check returns the error writing the item returns before writing anything:
+// the item cannot be stored or the keys of the secondary indexes are invalid.
+func (p *partition) check(item *tinyamodbItem) error {
+	if _, err := item.Value(); err != nil {
+		return err
+	}
+	_, err := p.indexEntries(item)
+	return err
+}
+
+// indexEntries returns the entries of the item in the secondary indexes, stamped with the item.
+// nil entry is not indexed, and a tombstone has no entries.
+func (p *partition) indexEntries(item *tinyamodbItem) ([]*tinyamodbItem, error) {
+	entries := make([]*tinyamodbItem, len(p.indexes))
+	if item.deleted != 0 {
+		return entries, nil
+	}
+	for i, x := range p.indexes {
+		entry, err := x.newItem(item.Item)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			entry.UnixNano = item.UnixNano
+		}
+		entries[i] = entry
+	}
+	return entries, nil
+}
+
+// updateIndexes replaces the entries of old with the entries in the secondary indexes at unixNano.
+// the caller must hold the lock.
+func (p *partition) updateIndexes(old Item, entries []*tinyamodbItem, unixNano int64) error {
+	for i, x := range p.indexes {
+		if err := x.update(old, entries[i], unixNano); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update reads the item having the key and writes the item returned by fn under the lock.
+// the item is deleted when fn returns nil or a tombstone. old is nil when the item is not found.
+func (p *partition) Update(key Item, fn func(old Item) (Item, error)) (old Item, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old, err = p.get(key)
+	if err != nil {
+		return nil, err
+	}
+	item, err := fn(old)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.commit(key, old, item); err != nil {
+		return nil, err
+	}
+	return old, nil
 }
 
 func (p *partition) Read(item Item) error {
@@ -54,17 +335,120 @@ func (p *partition) Read(item Item) error {
 	return err
 }
 
-func (p *partition) Delete(item Item) (Item, error) {
+// ReadBatch reads the items under one lock until ctx is done, and returns the number of the items read.
+// the items not found are left empty.
+func (p *partition) ReadBatch(ctx context.Context, items []*tinyamodbItem) (int, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for n, item := range items {
+		if ctx.Err() != nil {
+			return n, nil
+		}
+		if _, err := p.read(item); err != nil && !errors.Is(err, io.EOF) {
+			return n, err
+		}
+	}
+	return len(items), nil
+}
+
+// Query reads the items sharing the partition key whose sort key satisfies the condition.
+// it reads the items after exclusiveStart until the page is full, and reports whether the page stopped the reading.
+// attributes are the top level attributes to decode. nil decodes all.
+// fetch is called with each item read under the lock if not nil.
+func (p *partition) Query(partitionKey string, cond *expression.KeyCondition, forward bool, exclusiveStart *keyEntry, pg *page, attributes map[string]struct{}, fetch func(*tinyamodbItem) error) ([]*tinyamodbItem, bool, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	items, limited, err := p.readEntries(p.keys.Query(partitionKey, cond), forward, exclusiveStart, pg, attributes)
+	if err != nil || fetch == nil {
+		return items, limited, err
+	}
+	for _, item := range items {
+		if err := fetch(item); err != nil {
+			return nil, false, err
+		}
+	}
+	return items, limited, nil
+}
+
+// Scan reads the live items of the partition in key order whose partition key is in [lower, upper).
+// it reads the items after exclusiveStart until the page is full, and reports whether the page stopped the reading.
+// attributes are the top level attributes to decode. nil decodes all.
+func (p *partition) Scan(lower, upper string, exclusiveStart *keyEntry, pg *page, attributes map[string]struct{}) ([]*tinyamodbItem, bool, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.readEntries(p.keys.Between(lower, upper), true, exclusiveStart, pg, attributes)
+}
+
+// Delete deletes the item and returns it, or nil if not found.
+func (p *partition) Delete(item Item) (old Item, err error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	key := item.StrSHA2526Key()
-	for _, s := range p.segments {
-		if err := s.Delete(key); err != nil {
+	old, err = p.get(item)
+	if err != nil || old == nil {
+		return nil, err
+	}
+	return old, p.commit(item, old, nil)
+}
+
+// Expire deletes the items expired by e as system deletes, and returns the number of the items deleted.
+// the expired items are found under the read lock, and are checked again under the lock to be deleted.
+func (p *partition) Expire(e expiry) (int, error) {
+	expired, err := p.expired(e)
+	if err != nil || len(expired) == 0 {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var n int
+	for _, key := range expired {
+		// the secondary indexes are updated from all the attributes.
+		old, err := p.get(key)
+		if err != nil {
+			return n, err
+		}
+		// updated or deleted since found.
+		if old == nil || !e.expired(old.(*tinyamodbItem).Item) {
+			continue
+		}
+		if err := p.commit(key, old, newTombstone(key, systemDelete)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// expired returns the keys of the items expired by e.
+func (p *partition) expired(e expiry) ([]Item, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	attributes := map[string]struct{}{e.attribute: {}}
+	var expired []Item
+	for _, entry := range p.keys.Between("", "") {
+		item := &tinyamodbItem{
+			strSha256Key:          entry.key,
+			strSha256PartitionKey: entry.partitionKey,
+			sortKey:               entry.sortKey,
+			decodeAttributes:      attributes,
+		}
+		if _, err := p.read(item); err != nil {
+			if errors.Is(err, io.EOF) {
+				continue
+			}
 			return nil, err
 		}
+		if e.expired(item.Item) {
+			expired = append(expired, item)
+		}
 	}
-	return nil, nil
+	return expired, nil
 }
 
 func (p *partition) Close() error {
@@ -79,20 +463,238 @@ func (p *partition) Close() error {
 	return nil
 }
 
+// sync writes the segments written since the last sync and the stream shard to the disk.
+// the caller must hold the lock.
+func (p *partition) sync() error {
+	for _, s := range p.segments[p.synced:] {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+	// the active segment is written again.
+	p.synced = len(p.segments) - 1
+	if p.stream != nil {
+		return p.stream.sync()
+	}
+	return nil
+}
+
+// get reads the item having the key. it returns nil when the item is not found.
+func (p *partition) get(key Item) (Item, error) {
+	item := &tinyamodbItem{
+		sha256Key:             key.SHA256Key(),
+		strSha256Key:          key.StrSHA2526Key(),
+		strSha256PartitionKey: key.StrSHA256PartitionKey(),
+		sortKey:               key.SortKey(),
+	}
+	if _, err := p.read(item); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return item, nil
+}
+
+// read reads the newest record of the key of the item into it, and returns the segment having it.
+// the newest record is the one having the greatest UnixNano, and the last written of them on the same UnixNano.
+// it returns io.EOF when the item is not found or deleted.
 func (p *partition) read(item Item) (*segment, error) {
-	key := item.StrSHA2526Key()
+	r, found := p.latest[item.StrSHA2526Key()]
+	if !found {
+		return nil, io.EOF
+	}
+	return p.readLive(item, r)
+}
 
-	for _, s := range p.segments {
-		data, _ := s.Read(key)
-		if len(data) > 0 {
-			if err := item.Unmarshal(data); err == nil {
-				return s, nil
+// itemVersion is a record of a key in a segment.
+type itemVersion struct {
+	segment uint64
+	pos     uint64
+	// item is a tombstone if the key is deleted.
+	item *tinyamodbItem
+}
+
+// History reads all the records of the key of the item, newest first as read resolves them.
+func (p *partition) History(key Item) ([]itemVersion, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	records, err := p.records(key.StrSHA2526Key())
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]itemVersion, 0, len(records))
+	for _, r := range records {
+		item, err := p.readRecord(key.(*tinyamodbItem), r)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, itemVersion{segment: r.segment.id, pos: r.pos, item: item})
+	}
+	return versions, nil
+}
+
+// record is the position of a record of a key in a segment.
+type record struct {
+	segment  *segment
+	pos      uint64
+	unixNano int64
+}
+
+// records returns the records of the key newest first, by their UnixNano and then by the order of the writes
+// on the same UnixNano. only the UnixNano of the records are read.
+func (p *partition) records(key string) ([]record, error) {
+	var records []record
+	for n := len(p.segments) - 1; n >= 0; n-- {
+		s := p.segments[n]
+		poss, err := s.ReadAll(key)
+		if errors.Is(err, io.EOF) {
+			// not written in the segment
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := len(poss) - 1; i >= 0; i-- {
+			unixNano, err := s.UnixNanoAt(poss[i])
+			if err != nil {
+				return nil, err
 			}
+			records = append(records, record{segment: s, pos: poss[i], unixNano: unixNano})
+		}
+	}
+	// the records are in the order of the writes, newest first.
+	slices.SortStableFunc(records, func(a, b record) int {
+		return cmp.Compare(b.unixNano, a.unixNano)
+	})
+	return records, nil
+}
+
+// readRecord reads the record of the key of the item.
+func (p *partition) readRecord(key *tinyamodbItem, r record) (*tinyamodbItem, error) {
+	data, err := r.segment.ReadAt(r.pos)
+	if err != nil {
+		return nil, err
+	}
+	item := newReadItem(key, nil)
+	if err := item.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// ReadAsOf reads the item as it was at unixNano, the latest record written not after it.
+// it returns io.EOF if the key was deleted or not yet written at unixNano.
+func (p *partition) ReadAsOf(item Item, unixNano int64) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, err := p.readAsOf(item, unixNano)
+	return err
+}
+
+// ReadAllAsOf reads the items not deleted at unixNano under one lock.
+func (p *partition) ReadAllAsOf(unixNano int64) ([]*tinyamodbItem, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	// the keys ever written, including the deleted ones
+	keys := make(map[string]struct{})
+	for _, s := range p.segments {
+		for _, key := range s.index.Keys() {
+			keys[key] = struct{}{}
+		}
+	}
+	var items []*tinyamodbItem
+	for key := range keys {
+		item := &tinyamodbItem{strSha256Key: key}
+		_, err := p.readAsOf(item, unixNano)
+		if errors.Is(err, io.EOF) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// readAsOf reads the newest record of the key, as read resolves it, whose UnixNano is not after unixNano
+// into the item, and returns the segment having it. it returns io.EOF when the key is not written or deleted at unixNano.
+func (p *partition) readAsOf(item Item, unixNano int64) (*segment, error) {
+	records, err := p.records(item.StrSHA2526Key())
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if r.unixNano > unixNano {
+			// written after unixNano
+			continue
 		}
+		return p.readLive(item, r)
 	}
 	return nil, io.EOF
 }
 
+// readLive reads the record into the item, and returns the segment having it. it returns io.EOF when the record is a tombstone.
+func (p *partition) readLive(item Item, r record) (*segment, error) {
+	data, err := r.segment.ReadAt(r.pos)
+	if err != nil {
+		return nil, err
+	}
+	if err := item.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	if item.(*tinyamodbItem).deleted != 0 {
+		return nil, io.EOF
+	}
+	return r.segment, nil
+}
+
+func (p *partition) readEntries(entries []keyEntry, forward bool, exclusiveStart *keyEntry, pg *page, attributes map[string]struct{}) (items []*tinyamodbItem, limited bool, err error) {
+	for n := range entries {
+		e := entries[n]
+		if !forward {
+			e = entries[len(entries)-1-n]
+		}
+		if exclusiveStart != nil {
+			c := compareKeyEntry(e, *exclusiveStart)
+			if (forward && c <= 0) || (!forward && c >= 0) {
+				continue
+			}
+		}
+		if pg.Full() {
+			return items, true, nil
+		}
+		item := &tinyamodbItem{
+			strSha256Key:          e.key,
+			strSha256PartitionKey: e.partitionKey,
+			sortKey:               e.sortKey,
+			decodeAttributes:      attributes,
+		}
+		if _, err := p.read(item); err != nil {
+			if errors.Is(err, io.EOF) {
+				continue
+			}
+			return nil, false, err
+		}
+		items = append(items, item)
+		pg.Add(item)
+	}
+	return items, false, nil
+}
+
+// delete writes the tombstone of the key. the records of the key before it are kept as the history.
+func (p *partition) delete(tombstone Item) error {
+	if err := p.write(tombstone); err != nil {
+		return err
+	}
+	p.keys.Remove(newKeyEntry(tombstone))
+	return nil
+}
+
 func (p *partition) write(item Item) error {
 	if p.activeSegment.IsMaxed() {
 		if err := p.newSegment(0); err != nil {
@@ -105,14 +707,42 @@ func (p *partition) write(item Item) error {
 	if err != nil {
 		return err
 	}
-	return p.activeSegment.Write(key, data)
+	pos, err := p.activeSegment.Write(key, data)
+	if err != nil {
+		return err
+	}
+	r := record{segment: p.activeSegment, pos: pos, unixNano: item.(*tinyamodbItem).UnixNano}
+	if latest, found := p.latest[key]; !found || r.unixNano >= latest.unixNano {
+		p.latest[key] = r
+	}
+	return nil
 }
 
 func (p *partition) setup() error {
-	files, err := os.ReadDir(p.dir)
+	segmentIds, err := readSegmentIds(p.dir)
 	if err != nil {
 		return err
 	}
+	for _, id := range segmentIds {
+		if err = p.newSegment(id); err != nil {
+			return err
+		}
+	}
+
+	if p.activeSegment == nil {
+		if err := p.newSegment(0); err != nil {
+			return err
+		}
+	}
+	return p.setupKeys()
+}
+
+// readSegmentIds returns the ids of the segments having both files in dir in order.
+func readSegmentIds(dir string) ([]uint64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
 	segmentIdMap := make(map[uint64]int, len(files)/2)
 	for _, file := range files {
 		if file.IsDir() {
@@ -139,15 +769,39 @@ func (p *partition) setup() error {
 	}
 
 	slices.Sort(segmentIds)
-	for _, id := range segmentIds {
-		if err = p.newSegment(id); err != nil {
-			return err
-		}
-	}
+	return segmentIds, nil
+}
 
-	if p.activeSegment == nil {
-		if err := p.newSegment(0); err != nil {
-			return err
+// setupKeys rebuilds the newest records and the sorted keys of the keys stored in the segments.
+func (p *partition) setupKeys() error {
+	for n := len(p.segments) - 1; n >= 0; n-- {
+		s := p.segments[n]
+		for _, key := range s.index.Keys() {
+			if _, found := p.latest[key]; found {
+				continue
+			}
+			records, err := p.records(key)
+			if err != nil {
+				return err
+			}
+			p.latest[key] = records[0]
+			stored := &tinyamodbItem{strSha256Key: key}
+			_, err = p.read(stored)
+			if errors.Is(err, io.EOF) {
+				// deleted
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			item, err := p.newItem(stored.Item)
+			if err != nil {
+				return err
+			}
+			if item == nil {
+				continue
+			}
+			p.keys.Insert(newKeyEntry(item))
 		}
 	}
 	return nil
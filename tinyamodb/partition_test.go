@@ -1,6 +1,8 @@
 package tinyamodb
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"testing"
 
@@ -78,3 +80,108 @@ func TestPartition(t *testing.T) {
 	err = p.Read(want0)
 	require.Error(t, err)
 }
+
+func TestPartitionLastWriterWins(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-partition-lww")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	const PARTITION_ID = 1
+	var c Config
+	// two records in a segment
+	c.Segment.MaxIndexBytes = entwidth * 2
+	c.Table.PartitionKey = "key"
+	p, err := newPartition(dir, PARTITION_ID, c)
+	require.NoError(t, err)
+
+	item := func(key string, value int) *tinyamodbItem {
+		item, err := NewTinyamoDbItem(map[string]types.AttributeValue{
+			"key":   &types.AttributeValueMemberS{Value: key},
+			"value": &types.AttributeValueMemberN{Value: fmt.Sprint(value)},
+		}, c)
+		require.NoError(t, err)
+		return item
+	}
+	keys := []string{"key0", "key1", "key2"}
+	const rounds = 8
+	for v := 0; v < rounds; v++ {
+		for _, key := range keys {
+			_, err := p.Put(item(key, v))
+			require.NoError(t, err)
+		}
+	}
+	// more than 9 segments for the ids of 2 digits
+	require.Greater(t, len(p.segments), 10)
+
+	check := func() {
+		for _, key := range keys {
+			got := newReadItem(item(key, 0), nil)
+			require.NoError(t, p.Read(got))
+			require.Equal(t, item(key, rounds-1).Item, got.Item, key)
+		}
+		require.Equal(t, len(keys), p.keys.Len())
+	}
+	check()
+
+	// close and open
+	require.NoError(t, p.Close())
+	p, err = newPartition(dir, PARTITION_ID, c)
+	require.NoError(t, err)
+	check()
+
+	// the record of the later time wins over the later written one
+	latest := item("key0", rounds)
+	stale := item("key0", -1)
+	stale.UnixNano = latest.UnixNano - 1
+	_, err = p.Put(latest)
+	require.NoError(t, err)
+	_, err = p.Put(stale)
+	require.NoError(t, err)
+	// and the later written one wins on the same time
+	tied := item("key1", rounds)
+	tiedLater := item("key1", -1)
+	tiedLater.UnixNano = tied.UnixNano
+	_, err = p.Put(tied)
+	require.NoError(t, err)
+	_, err = p.Put(tiedLater)
+	require.NoError(t, err)
+
+	checkLatest := func() {
+		for _, want := range []*tinyamodbItem{latest, tiedLater} {
+			got := newReadItem(want, nil)
+			require.NoError(t, p.Read(got))
+			require.Equal(t, want.Item, got.Item)
+		}
+		require.Equal(t, len(keys), p.keys.Len())
+		// the newest records kept in memory are the ones resolved from all the records
+		for key, r := range p.latest {
+			records, err := p.records(key)
+			require.NoError(t, err)
+			require.Equal(t, records[0], r)
+		}
+	}
+	checkLatest()
+
+	require.NoError(t, p.Close())
+	p, err = newPartition(dir, PARTITION_ID, c)
+	require.NoError(t, err)
+	defer p.Close()
+	checkLatest()
+
+	// a record older than a delete is not read either
+	_, err = p.Delete(item("key2", 0))
+	require.NoError(t, err)
+	stale = item("key2", rounds)
+	stale.UnixNano = latest.UnixNano
+	_, err = p.Put(stale)
+	require.NoError(t, err)
+	require.ErrorIs(t, p.Read(newReadItem(stale, nil)), io.EOF)
+	require.Equal(t, len(keys)-1, p.keys.Len())
+	// an error reading a segment is not taken as not found
+	for _, s := range p.segments {
+		require.NoError(t, s.store.File.Close())
+	}
+	err = p.Read(newReadItem(item("key2", 0), nil))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,191 @@
+package tinyamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrRestoreTargetExists = errors.New("restore target already exists")
+
+// RestoreToPointInTime restores the db in srcDir as of t into the new db in dstDir.
+// srcDir is opened read-only without recovering it, so it can be restored while another process serves it.
+// the writes that process has not flushed to the files yet are not restored,
+// and a transaction interrupted in srcDir is restored as it is written. use Db.RestoreToPointInTime in that process to restore them.
+// t after now is now. dstDir is removed on error.
+func RestoreToPointInTime(srcDir, dstDir string, t time.Time) (err error) {
+	children, err := os.ReadDir(srcDir)
+	if err != nil {
+		return err
+	}
+	t, err = createRestoreTarget(dstDir, t)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dstDir)
+		}
+	}()
+	for _, child := range children {
+		name := child.Name()
+		if !child.IsDir() || !isTableDir(filepath.Join(srcDir, name)) {
+			continue
+		}
+		if err := restoreTableDir(srcDir, name, dstDir, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreTableDir restores the table in dir read-only into the new table of the same name in dstDir.
+func restoreTableDir(dir, name, dstDir string, at time.Time) (err error) {
+	m, err := readTableMetadata(dir, name)
+	if err != nil {
+		return err
+	}
+	c := m.config(name, Config{})
+	partitions := make([]*partition, 0, m.Partitions)
+	defer func() {
+		for _, p := range partitions {
+			if cerr := p.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}()
+	for i := 1; i <= m.Partitions; i++ {
+		p, err := openPartitionReadOnly(filepath.Join(dir, name), i, c)
+		if err != nil {
+			return err
+		}
+		partitions = append(partitions, p)
+	}
+	return restoreTable(context.Background(), dstDir, name, m, c, partitions, at)
+}
+
+// RestoreToPointInTime writes the items of the tables live at t into the new db in dir.
+// the items are compacted into new segments, and the tables keep their schema, indexes and TTL but not the stream.
+// the partitions are read one by one under the read lock, so the db keeps serving while the restore runs.
+// the tables are restored as they are found: the tables created after the restore starts are not restored.
+// t after now is now. dir is removed on error.
+func (db *Db) RestoreToPointInTime(ctx context.Context, dir string, t time.Time) (err error) {
+	t, err = createRestoreTarget(dir, t)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+	db.mu.RLock()
+	names := make([]string, 0, len(db.tables))
+	for name := range db.tables {
+		names = append(names, name)
+	}
+	db.mu.RUnlock()
+	for _, name := range names {
+		if err := db.restoreTable(ctx, name, dir, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreTable writes the items of the table of the name live at t into dir.
+// the table deleted since the restore started is not restored.
+func (db *Db) restoreTable(ctx context.Context, name, dir string, t time.Time) error {
+	src, err := db.table(name)
+	if err != nil {
+		if errors.Is(err, ErrTableNotFound) {
+			return nil
+		}
+		return err
+	}
+	defer src.release()
+	return src.restore(ctx, dir, t)
+}
+
+// createRestoreTarget creates the new directory dir of the restore, and returns t not after now.
+func createRestoreTarget(dir string, t time.Time) (time.Time, error) {
+	if now := time.Now(); t.After(now) {
+		// the writes after the restore starts are not restored
+		t = now
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return t, fmt.Errorf("%w: '%s'", ErrRestoreTargetExists, dir)
+		}
+		return t, err
+	}
+	return t, nil
+}
+
+// restore writes the items of the table live at t into the new table of the same name in dir.
+func (t *table) restore(ctx context.Context, dir string, at time.Time) error {
+	t.mu.RLock()
+	m, err := readTableMetadata(filepath.Dir(t.dir), t.name)
+	t.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	partitions := make([]*partition, 0, len(t.partitions))
+	for i := 1; i <= len(t.partitions); i++ {
+		partitions = append(partitions, t.partitions[i])
+	}
+	return restoreTable(ctx, dir, t.name, m, t.c, partitions, at)
+}
+
+// restoreTable writes the items of the partitions live at t into the new table of the name in dir.
+// m is the metadata of the table, and c is its config.
+func restoreTable(ctx context.Context, dir, name string, m tableMetadata, c Config, partitions []*partition, at time.Time) (err error) {
+	dst, err := createTable(dir, name, c, m.GlobalSecondaryIndexes, m.LocalSecondaryIndexes, "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	if m.TimeToLiveAttribute != "" {
+		dst.mu.Lock()
+		err = dst.updateMetadata(func(dm *tableMetadata) { dm.TimeToLiveAttribute = m.TimeToLiveAttribute })
+		dst.timeToLiveAttribute = m.TimeToLiveAttribute
+		dst.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	// the items expired at t are not live
+	e := expiry{attribute: m.TimeToLiveAttribute, now: at.Unix()}
+	for _, p := range partitions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		items, err := p.ReadAllAsOf(at.UnixNano())
+		if err != nil {
+			return err
+		}
+		for _, v := range items {
+			if e.expired(v.Item) {
+				continue
+			}
+			item, err := NewTinyamoDbItem(v.Item, dst.c)
+			if err != nil {
+				return err
+			}
+			// the time of the write is kept
+			item.UnixNano = v.UnixNano
+			if _, err := dst.determinePartition(item.sha256PartitionKey).Put(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
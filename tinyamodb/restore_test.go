@@ -0,0 +1,223 @@
+package tinyamodb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreToPointInTime(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-restore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+
+	var c Config
+	c.Partition.Num = 2
+	// a few records in a segment
+	c.Segment.MaxStoreBytes = 128
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.PartitionKey = "pk"
+	c.TimeToLive.SweepInterval = -1
+	db, err := New(src, c)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	s := func(v string) *string { return &v }
+	_, err = db.CreateTable(ctx, &CreateTableInput{
+		TableName: "orders",
+		KeySchema: []types.KeySchemaElement{{AttributeName: s("user"), KeyType: types.KeyTypeHash}},
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: s("user"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: s("status"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName:  s("status"),
+			KeySchema:  []types.KeySchemaElement{{AttributeName: s("status"), KeyType: types.KeyTypeHash}},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		}},
+		PartitionNum: 2,
+	})
+	require.NoError(t, err)
+
+	item := func(pk string, v int) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: pk},
+			"v":  &types.AttributeValueMemberN{Value: fmt.Sprint(v)},
+		}
+	}
+	order := func(user, status string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"user":   &types.AttributeValueMemberS{Value: user},
+			"status": &types.AttributeValueMemberS{Value: status},
+		}
+	}
+	put := func(table string, item map[string]types.AttributeValue) {
+		_, err := db.PutItem(ctx, &PutItemInput{TableName: table, Item: item})
+		require.NoError(t, err)
+	}
+	put("", item("A", 1))
+	put("", item("B", 1))
+	put("", item("C", 1))
+	put("orders", order("U1", "open"))
+	time.Sleep(time.Millisecond)
+	at := time.Now()
+	time.Sleep(time.Millisecond)
+	put("", item("A", 2))
+	_, err = db.DeleteItem(ctx, &DeleteItemInput{Key: map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: "B"}}})
+	require.NoError(t, err)
+	put("", item("D", 1))
+	put("orders", order("U1", "closed"))
+
+	check := func(dir string, want []map[string]types.AttributeValue, status string) {
+		db, err := New(dir, c)
+		require.NoError(t, err)
+		defer db.Close()
+		output, err := db.Scan(ctx, &ScanInput{})
+		require.NoError(t, err)
+		require.ElementsMatch(t, want, output.Items)
+		orders, err := db.Query(ctx, &QueryInput{
+			TableName:                 "orders",
+			IndexName:                 "status",
+			KeyConditionExpression:    "#s = :s",
+			ExpressionAttributeNames:  map[string]string{"#s": "status"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":s": &types.AttributeValueMemberS{Value: status}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []map[string]types.AttributeValue{order("U1", status)}, orders.Items)
+		// compacted
+		history, err := db.GetItemHistory(ctx, map[string]types.AttributeValue{"pk": want[0]["pk"]}, nil)
+		require.NoError(t, err)
+		require.Len(t, history.Versions, 1)
+	}
+
+	// the db keeps serving while the restore runs
+	var wg sync.WaitGroup
+	var werr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20 && werr == nil; i++ {
+			_, werr = db.PutItem(ctx, &PutItemInput{Item: item("E", i)})
+		}
+	}()
+	dst := filepath.Join(dir, "dst")
+	require.NoError(t, db.RestoreToPointInTime(ctx, dst, at))
+	wg.Wait()
+	require.NoError(t, werr)
+	check(dst, []map[string]types.AttributeValue{item("A", 1), item("B", 1), item("C", 1)}, "open")
+	require.ErrorIs(t, db.RestoreToPointInTime(ctx, dst, at), ErrRestoreTargetExists)
+	// the target is removed on error
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, db.RestoreToPointInTime(canceled, filepath.Join(dir, "canceled"), at), context.Canceled)
+	require.NoDirExists(t, filepath.Join(dir, "canceled"))
+
+	// the tables are created while the restore runs
+	db.mu.RLock()
+	locked := db.tables["orders"]
+	db.mu.RUnlock()
+	locked.mu.Lock()
+	restoring := make(chan error, 1)
+	dst = filepath.Join(dir, "creating")
+	go func() {
+		restoring <- db.RestoreToPointInTime(ctx, dst, at)
+	}()
+	// the restore waits for the lock of orders
+	require.Eventually(t, func() bool {
+		locked.useMu.Lock()
+		defer locked.useMu.Unlock()
+		return locked.users == 1
+	}, time.Second, time.Millisecond)
+	created := make(chan error, 1)
+	go func() {
+		_, err := db.CreateTable(ctx, &CreateTableInput{
+			TableName:            "created",
+			KeySchema:            []types.KeySchemaElement{{AttributeName: s("id"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: s("id"), AttributeType: types.ScalarAttributeTypeS}},
+		})
+		created <- err
+	}()
+	select {
+	case err := <-created:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("CreateTable is blocked by the restore")
+	}
+	locked.mu.Unlock()
+	require.NoError(t, <-restoring)
+	require.DirExists(t, filepath.Join(dst, "orders"))
+	require.NoDirExists(t, filepath.Join(dst, "created"))
+
+	// expired at the time by TTL
+	enabled := true
+	_, err = db.UpdateTimeToLive(ctx, &UpdateTimeToLiveInput{
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{AttributeName: s("v"), Enabled: &enabled},
+	})
+	require.NoError(t, err)
+	// 2100-01-01
+	put("", item("E", 4102444800))
+	put("", item("F", 4102444800))
+	orders, err := db.table("orders")
+	require.NoError(t, err)
+	require.NoError(t, orders.sync([]int{1, 2}))
+	orders.release()
+	tbl, err := db.table("")
+	require.NoError(t, err)
+	require.NoError(t, tbl.sync([]int{1, 2}))
+	// the store of the latest F is not flushed but its index is
+	put("", item("F", 4102444801))
+	for _, p := range tbl.partitions {
+		for _, s := range p.segments {
+			require.NoError(t, s.index.Flush())
+		}
+	}
+	tbl.release()
+
+	// the files of the db serving are not written by the restore
+	files := func() map[string]string {
+		files := make(map[string]string)
+		err := filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			files[path] = string(data)
+			return err
+		})
+		require.NoError(t, err)
+		return files
+	}
+	before := files()
+	dst = filepath.Join(dir, "serving")
+	require.NoError(t, RestoreToPointInTime(src, dst, time.Now()))
+	require.Equal(t, before, files())
+	check(dst, []map[string]types.AttributeValue{item("E", 4102444800), item("F", 4102444800)}, "closed")
+	require.NoError(t, db.Close())
+
+	dst = filepath.Join(dir, "latest")
+	require.NoError(t, RestoreToPointInTime(src, dst, time.Now()))
+	check(dst, []map[string]types.AttributeValue{item("E", 4102444800), item("F", 4102444801)}, "closed")
+	restored, err := New(dst, c)
+	require.NoError(t, err)
+	ttl, err := restored.DescribeTimeToLive(ctx, &DescribeTimeToLiveInput{})
+	require.NoError(t, err)
+	require.Equal(t, types.TimeToLiveStatusEnabled, ttl.TimeToLiveDescription.TimeToLiveStatus)
+	require.NoError(t, restored.Close())
+
+	require.Error(t, RestoreToPointInTime(filepath.Join(dir, "unknown"), filepath.Join(dir, "none"), time.Now()))
+	require.NoDirExists(t, filepath.Join(dir, "none"))
+	// the target is removed on error
+	broken := filepath.Join(dir, "broken")
+	require.NoError(t, os.MkdirAll(filepath.Join(broken, "orders"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(broken, "orders", tableMetadataName), []byte("{"), 0644))
+	require.ErrorIs(t, RestoreToPointInTime(broken, filepath.Join(dir, "none"), time.Now()), ErrTableFormat)
+	require.NoDirExists(t, filepath.Join(dir, "none"))
+}
@@ -0,0 +1,393 @@
+package tinyamodb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// the max nums of the secondary indexes of a table.
+const (
+	maxGlobalSecondaryIndexes = 20
+	maxLocalSecondaryIndexes  = 5
+)
+
+var (
+	ErrIndexNotFound = errors.New("index not found")
+	ErrInvalidIndex  = errors.New("invalid secondary index")
+)
+
+// indexesDirName is the directory of the secondary indexes in the table directory.
+const indexesDirName = "indexes"
+
+// keySpace is the partitions of a table or of a secondary index, keyed by its key schema.
+type keySpace interface {
+	// config returns the config having the key schema in Table.
+	config() Config
+	// newItem returns the item keyed by the key schema. it returns nil if the item is not in the key space.
+	newItem(item map[string]types.AttributeValue) (*tinyamodbItem, error)
+	// keyAttributes returns the attributes identifying the item in the key space.
+	keyAttributes(item map[string]types.AttributeValue) map[string]types.AttributeValue
+	determinePartition(sha256key []byte) *partition
+}
+
+func (t *table) config() Config {
+	return t.c
+}
+
+func (t *table) newItem(item map[string]types.AttributeValue) (*tinyamodbItem, error) {
+	return NewTinyamoDbItem(item, t.c)
+}
+
+func (t *table) keyAttributes(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	return keyAttributes(item, t.c)
+}
+
+// indexMetadata is the schema of a secondary index.
+type indexMetadata struct {
+	Name             string
+	PartitionKey     string
+	PartitionKeyType types.ScalarAttributeType
+	SortKey          string                    `json:",omitempty"`
+	SortKeyType      types.ScalarAttributeType `json:",omitempty"`
+	ProjectionType   types.ProjectionType
+	NonKeyAttributes []string `json:",omitempty"`
+}
+
+// secondaryIndex is a secondary index of a table. it is stored like a table whose items are
+// the projections of the items of the table, keyed by the keys of the index and of the table.
+// items without the key attributes of the index are not indexed.
+//
+// a local secondary index shares the partition key with the table. the partition i of the index
+// has the items of the partition i of the table and shares its lock, so that the index is updated
+// with the items and read consistently with them.
+type secondaryIndex struct {
+	name  string
+	local bool
+	// c has the key schema of the index in Table.
+	c Config
+	// base has the key schema of the table.
+	base             Config
+	projectionType   types.ProjectionType
+	nonKeyAttributes []string
+	// partition id start with 1
+	partitions map[int]*partition
+}
+
+// newSecondaryIndex opens the index in the directory <table dir>/indexes/<name>.
+// the index is local if tablePartitions, the partitions of the table, is not nil.
+func newSecondaryIndex(dir string, m indexMetadata, base Config, tablePartitions map[int]*partition) (*secondaryIndex, error) {
+	x := &secondaryIndex{
+		name:             m.Name,
+		local:            tablePartitions != nil,
+		c:                base,
+		base:             base,
+		projectionType:   m.ProjectionType,
+		nonKeyAttributes: m.NonKeyAttributes,
+		partitions:       make(map[int]*partition, base.Partition.Num),
+	}
+	x.c.Table.PartitionKey = m.PartitionKey
+	x.c.Table.PartitionKeyType = m.PartitionKeyType
+	x.c.Table.SortKey = m.SortKey
+	x.c.Table.SortKeyType = m.SortKeyType
+
+	xdir := filepath.Join(dir, indexesDirName, m.Name)
+	if err := os.MkdirAll(xdir, 0755); err != nil {
+		return nil, err
+	}
+	for i := 1; i <= int(base.Partition.Num); i++ {
+		var err error
+		x.partitions[i], err = openPartition(xdir, i, x.c, x.newItem)
+		if err != nil {
+			return nil, err
+		}
+		if x.local {
+			x.partitions[i].mu = tablePartitions[i].mu
+		}
+	}
+	return x, nil
+}
+
+func (x *secondaryIndex) config() Config {
+	return x.c
+}
+
+// newItem returns the item of the index projected from the item of the table.
+// it returns nil if the item does not have the key attributes of the index.
+func (x *secondaryIndex) newItem(item map[string]types.AttributeValue) (*tinyamodbItem, error) {
+	for _, name := range []string{x.c.Table.PartitionKey, x.c.Table.SortKey} {
+		if av, found := item[name]; name != "" && (!found || av == nil) {
+			return nil, nil
+		}
+	}
+	entry, err := NewTinyamoDbItem(x.project(item), x.c)
+	if err != nil {
+		return nil, fmt.Errorf("index '%s': %w", x.name, err)
+	}
+	base, err := NewTinyamoDbItem(item, x.base)
+	if err != nil {
+		return nil, err
+	}
+	// items sharing the keys of the index are identified by the keys of the table.
+	entry.sha256Key, entry.strSha256Key = sum256(joinKey(entry.sha256Key, base.sha256Key))
+	return entry, nil
+}
+
+// project returns the attributes of the item projected into the index.
+func (x *secondaryIndex) project(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	if x.projectionType == types.ProjectionTypeAll {
+		return item
+	}
+	projected := x.keyAttributes(item)
+	if x.projectionType == types.ProjectionTypeInclude {
+		for _, name := range x.nonKeyAttributes {
+			if av, found := item[name]; found {
+				projected[name] = av
+			}
+		}
+	}
+	return projected
+}
+
+// projected reports whether the attributes of the items of the table are in the index.
+func (x *secondaryIndex) projected(names []string) bool {
+	if x.projectionType == types.ProjectionTypeAll {
+		return true
+	}
+	projected := x.keyAttributes(nil)
+	for _, name := range x.nonKeyAttributes {
+		projected[name] = nil
+	}
+	for _, name := range names {
+		if _, found := projected[name]; !found {
+			return false
+		}
+	}
+	return true
+}
+
+// fetch returns the function replacing the item of the local index with the item of the table,
+// when the attributes are not projected into the index. otherwise it returns nil.
+// attributes are the top level attributes to decode. nil decodes all.
+// the function is called under the lock of the partition shared with the table.
+func (x *secondaryIndex) fetch(t *table, names []string, attributes map[string]struct{}) func(*tinyamodbItem) error {
+	if !x.local || x.projected(names) {
+		return nil
+	}
+	return func(item *tinyamodbItem) error {
+		key, err := t.newItem(item.Item)
+		if err != nil {
+			return err
+		}
+		base := newReadItem(key, attributes)
+		if _, err := t.determinePartition(key.sha256PartitionKey).read(base); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		item.Item = base.Item
+		return nil
+	}
+}
+
+// expired returns the function reporting whether the entry of the index is expired by e.
+// the TTL attribute not projected into the index is read from the table, so the entries
+// are checked after the index is read: the partitions of a global index are locked after the ones of the table.
+func (x *secondaryIndex) expired(t *table, e expiry) func(*tinyamodbItem) (bool, error) {
+	if e.attribute == "" || x.projected([]string{e.attribute}) {
+		return func(item *tinyamodbItem) (bool, error) {
+			return e.expired(item.Item), nil
+		}
+	}
+	attributes := map[string]struct{}{e.attribute: {}}
+	return func(item *tinyamodbItem) (bool, error) {
+		key, err := t.newItem(item.Item)
+		if err != nil {
+			return false, err
+		}
+		base := newReadItem(key, attributes)
+		if err := t.determinePartition(key.sha256PartitionKey).Read(base); err != nil {
+			if errors.Is(err, io.EOF) {
+				return false, nil
+			}
+			return false, err
+		}
+		return e.expired(base.Item), nil
+	}
+}
+
+// keyAttributes returns the key attributes of the index and of the table.
+func (x *secondaryIndex) keyAttributes(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	key := keyAttributes(item, x.base)
+	for name, av := range keyAttributes(item, x.c) {
+		key[name] = av
+	}
+	return key
+}
+
+// update replaces the entry of the old item of the table with the new entry. nil entry is not indexed.
+// the entry of the old item is deleted at unixNano, the time of the write of the table.
+// the caller must hold the lock of the partition of the table.
+func (x *secondaryIndex) update(old Item, entry *tinyamodbItem, unixNano int64) error {
+	if old != nil {
+		prev, err := x.newItem(old.(*tinyamodbItem).Item)
+		if err != nil {
+			return err
+		}
+		if prev != nil && (entry == nil || prev.strSha256Key != entry.strSha256Key) {
+			prev.UnixNano = unixNano
+			if err := x.apply(writeRequest{item: prev, delete: true}); err != nil {
+				return err
+			}
+		}
+	}
+	if entry == nil {
+		return nil
+	}
+	return x.apply(writeRequest{item: entry})
+}
+
+// apply writes the request to the partition of the index.
+// the partition of a local index is locked with the partition of the table.
+func (x *secondaryIndex) apply(r writeRequest) error {
+	p := x.determinePartition(r.item.(*tinyamodbItem).sha256PartitionKey)
+	if !x.local {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+	}
+	return p.apply(r)
+}
+
+// describeGlobal returns the description of the global index.
+func (x *secondaryIndex) describeGlobal() types.GlobalSecondaryIndexDescription {
+	name := x.name
+	count := x.itemCount()
+	return types.GlobalSecondaryIndexDescription{
+		IndexName:   &name,
+		IndexStatus: types.IndexStatusActive,
+		KeySchema:   keySchema(x.c),
+		Projection:  x.projection(),
+		ItemCount:   &count,
+	}
+}
+
+// describeLocal returns the description of the local index.
+func (x *secondaryIndex) describeLocal() types.LocalSecondaryIndexDescription {
+	name := x.name
+	count := x.itemCount()
+	return types.LocalSecondaryIndexDescription{
+		IndexName:  &name,
+		KeySchema:  keySchema(x.c),
+		Projection: x.projection(),
+		ItemCount:  &count,
+	}
+}
+
+func (x *secondaryIndex) projection() *types.Projection {
+	return &types.Projection{
+		ProjectionType:   x.projectionType,
+		NonKeyAttributes: x.nonKeyAttributes,
+	}
+}
+
+func (x *secondaryIndex) itemCount() int64 {
+	var count int64
+	for _, p := range x.partitions {
+		p.mu.RLock()
+		count += int64(p.keys.Len())
+		p.mu.RUnlock()
+	}
+	return count
+}
+
+func (x *secondaryIndex) Close() error {
+	for _, p := range x.partitions {
+		if err := p.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *secondaryIndex) determinePartition(sha256key []byte) *partition {
+	return x.partitions[partitionId(sha256key, len(x.partitions))]
+}
+
+// parseSecondaryIndexes returns the schemas of the global and local indexes of the table.
+// the types of the keys are of attributeTypes, and the names of the keys are added to used.
+func parseSecondaryIndexes(input *CreateTableInput, c Config, attributeTypes map[string]types.ScalarAttributeType, used map[string]struct{}) (global, local []indexMetadata, err error) {
+	if len(input.GlobalSecondaryIndexes) > maxGlobalSecondaryIndexes {
+		return nil, nil, fmt.Errorf("%w: a table can have up to %d global secondary indexes", ErrInvalidIndex, maxGlobalSecondaryIndexes)
+	}
+	if len(input.LocalSecondaryIndexes) > maxLocalSecondaryIndexes {
+		return nil, nil, fmt.Errorf("%w: a table can have up to %d local secondary indexes", ErrInvalidIndex, maxLocalSecondaryIndexes)
+	}
+	seen := make(map[string]struct{})
+	for _, gsi := range input.GlobalSecondaryIndexes {
+		m, err := parseSecondaryIndex(gsi.IndexName, gsi.KeySchema, gsi.Projection, c, attributeTypes, used, seen)
+		if err != nil {
+			return nil, nil, err
+		}
+		global = append(global, m)
+	}
+	for _, lsi := range input.LocalSecondaryIndexes {
+		m, err := parseSecondaryIndex(lsi.IndexName, lsi.KeySchema, lsi.Projection, c, attributeTypes, used, seen)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.Table.SortKey == "" {
+			return nil, nil, fmt.Errorf("%w: local index '%s' needs a table having a sort key", ErrInvalidIndex, m.Name)
+		}
+		if m.PartitionKey != c.Table.PartitionKey || m.SortKey == "" {
+			return nil, nil, fmt.Errorf("%w: local index '%s' must have the partition key of the table and a sort key", ErrInvalidIndex, m.Name)
+		}
+		local = append(local, m)
+	}
+	return global, local, nil
+}
+
+func parseSecondaryIndex(indexName *string, schema []types.KeySchemaElement, projection *types.Projection, c Config, attributeTypes map[string]types.ScalarAttributeType, used, seen map[string]struct{}) (indexMetadata, error) {
+	name := stringValue(indexName)
+	if !tableNamePattern.MatchString(name) {
+		return indexMetadata{}, fmt.Errorf("%w: invalid index name '%s'", ErrInvalidIndex, name)
+	}
+	if _, found := seen[name]; found {
+		return indexMetadata{}, fmt.Errorf("%w: duplicate index name '%s'", ErrInvalidIndex, name)
+	}
+	seen[name] = struct{}{}
+
+	xc, err := withKeySchema(c, schema, attributeTypes, used)
+	if err != nil {
+		return indexMetadata{}, fmt.Errorf("index '%s': %w", name, err)
+	}
+	m := indexMetadata{
+		Name:             name,
+		PartitionKey:     xc.Table.PartitionKey,
+		PartitionKeyType: xc.Table.PartitionKeyType,
+		SortKey:          xc.Table.SortKey,
+		SortKeyType:      xc.Table.SortKeyType,
+	}
+	if projection == nil {
+		return m, fmt.Errorf("%w: index '%s' has no projection", ErrInvalidIndex, name)
+	}
+	m.ProjectionType = projection.ProjectionType
+	m.NonKeyAttributes = projection.NonKeyAttributes
+	switch m.ProjectionType {
+	case types.ProjectionTypeAll, types.ProjectionTypeKeysOnly:
+		if len(m.NonKeyAttributes) > 0 {
+			return m, fmt.Errorf("%w: NonKeyAttributes of index '%s' are only for INCLUDE", ErrInvalidIndex, name)
+		}
+	case types.ProjectionTypeInclude:
+		if len(m.NonKeyAttributes) == 0 {
+			return m, fmt.Errorf("%w: index '%s' includes no NonKeyAttributes", ErrInvalidIndex, name)
+		}
+	default:
+		return m, fmt.Errorf("%w: invalid projection type '%s' of index '%s'", ErrInvalidIndex, m.ProjectionType, name)
+	}
+	return m, nil
+}
@@ -0,0 +1,447 @@
+package tinyamodb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobalSecondaryIndex(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-gsi")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	db, err := New(dir, c)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	s := func(v string) *string { return &v }
+	attr := func(name string, t types.ScalarAttributeType) types.AttributeDefinition {
+		return types.AttributeDefinition{AttributeName: s(name), AttributeType: t}
+	}
+	keys := func(pk, sk string) []types.KeySchemaElement {
+		schema := []types.KeySchemaElement{{AttributeName: s(pk), KeyType: types.KeyTypeHash}}
+		if sk != "" {
+			schema = append(schema, types.KeySchemaElement{AttributeName: s(sk), KeyType: types.KeyTypeRange})
+		}
+		return schema
+	}
+	input := &CreateTableInput{
+		TableName: "orders",
+		KeySchema: keys("user", "id"),
+		AttributeDefinitions: []types.AttributeDefinition{
+			attr("user", types.ScalarAttributeTypeS),
+			attr("id", types.ScalarAttributeTypeS),
+			attr("status", types.ScalarAttributeTypeS),
+			attr("created", types.ScalarAttributeTypeN),
+			attr("customer", types.ScalarAttributeTypeS),
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName:  s("status-created"),
+				KeySchema:  keys("status", "created"),
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			},
+			{
+				IndexName:  s("status-keys"),
+				KeySchema:  keys("status", ""),
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeKeysOnly},
+			},
+			{
+				IndexName:  s("customer"),
+				KeySchema:  keys("customer", "created"),
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeInclude, NonKeyAttributes: []string{"amount"}},
+			},
+		},
+		PartitionNum: 3,
+	}
+	_, err = db.CreateTable(ctx, input)
+	require.NoError(t, err)
+
+	order := func(user, id, status string, created int) map[string]types.AttributeValue {
+		item := map[string]types.AttributeValue{
+			"user":     &types.AttributeValueMemberS{Value: user},
+			"id":       &types.AttributeValueMemberS{Value: id},
+			"created":  &types.AttributeValueMemberN{Value: fmt.Sprint(created)},
+			"customer": &types.AttributeValueMemberS{Value: "C#" + user},
+			"amount":   &types.AttributeValueMemberN{Value: "100"},
+			"note":     &types.AttributeValueMemberS{Value: "note"},
+		}
+		if status != "" {
+			item["status"] = &types.AttributeValueMemberS{Value: status}
+		}
+		return item
+	}
+	put := func(item map[string]types.AttributeValue) {
+		_, err := db.PutItem(ctx, &PutItemInput{TableName: "orders", Item: item})
+		require.NoError(t, err)
+	}
+	query := func(index, pk string, v string, limit int32, start map[string]types.AttributeValue) *QueryOutput {
+		output, err := db.Query(ctx, &QueryInput{
+			TableName:                 "orders",
+			IndexName:                 index,
+			KeyConditionExpression:    "#pk = :pk",
+			ExpressionAttributeNames:  map[string]string{"#pk": pk},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":pk": &types.AttributeValueMemberS{Value: v}},
+			Limit:                     limit,
+			ExclusiveStartKey:         start,
+		})
+		require.NoError(t, err)
+		return output
+	}
+	ids := func(items []map[string]types.AttributeValue) []string {
+		var ids []string
+		for _, item := range items {
+			ids = append(ids, item["id"].(*types.AttributeValueMemberS).Value)
+		}
+		return ids
+	}
+
+	put(order("U1", "O1", "OPEN", 3))
+	put(order("U1", "O2", "OPEN", 1))
+	put(order("U2", "O3", "OPEN", 2))
+	put(order("U2", "O4", "CLOSED", 4))
+	// sparse
+	put(order("U3", "O5", "", 5))
+
+	// projection ALL in the order of the sort key of the index
+	output := query("status-created", "status", "OPEN", 0, nil)
+	require.Equal(t, []string{"O2", "O3", "O1"}, ids(output.Items))
+	require.Equal(t, order("U2", "O3", "OPEN", 2), output.Items[1])
+	require.Equal(t, []string{"O4"}, ids(query("status-created", "status", "CLOSED", 0, nil).Items))
+
+	// projection KEYS_ONLY
+	output = query("status-keys", "status", "CLOSED", 0, nil)
+	require.Equal(t, []map[string]types.AttributeValue{{
+		"user":   &types.AttributeValueMemberS{Value: "U2"},
+		"id":     &types.AttributeValueMemberS{Value: "O4"},
+		"status": &types.AttributeValueMemberS{Value: "CLOSED"},
+	}}, output.Items)
+
+	// projection INCLUDE
+	output = query("customer", "customer", "C#U3", 0, nil)
+	require.Equal(t, []map[string]types.AttributeValue{{
+		"user":     &types.AttributeValueMemberS{Value: "U3"},
+		"id":       &types.AttributeValueMemberS{Value: "O5"},
+		"customer": &types.AttributeValueMemberS{Value: "C#U3"},
+		"created":  &types.AttributeValueMemberN{Value: "5"},
+		"amount":   &types.AttributeValueMemberN{Value: "100"},
+	}}, output.Items)
+
+	// pages of the items sharing the keys of the index
+	var got []string
+	var start map[string]types.AttributeValue
+	for {
+		output := query("status-keys", "status", "OPEN", 1, start)
+		got = append(got, ids(output.Items)...)
+		if output.LastEvaluatedKey == nil {
+			break
+		}
+		require.Len(t, output.LastEvaluatedKey, 3)
+		start = output.LastEvaluatedKey
+	}
+	require.ElementsMatch(t, []string{"O1", "O2", "O3"}, got)
+
+	// maintained by update, delete, batch and transaction
+	_, err = db.UpdateItem(ctx, &UpdateItemInput{
+		TableName:                 "orders",
+		Key:                       map[string]types.AttributeValue{"user": &types.AttributeValueMemberS{Value: "U1"}, "id": &types.AttributeValueMemberS{Value: "O1"}},
+		UpdateExpression:          "SET #s = :s",
+		ExpressionAttributeNames:  map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":s": &types.AttributeValueMemberS{Value: "CLOSED"}},
+	})
+	require.NoError(t, err)
+	_, err = db.UpdateItem(ctx, &UpdateItemInput{
+		TableName:                "orders",
+		Key:                      map[string]types.AttributeValue{"user": &types.AttributeValueMemberS{Value: "U2"}, "id": &types.AttributeValueMemberS{Value: "O4"}},
+		UpdateExpression:         "REMOVE #s",
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"O2", "O3"}, ids(query("status-created", "status", "OPEN", 0, nil).Items))
+	require.Equal(t, []string{"O1"}, ids(query("status-created", "status", "CLOSED", 0, nil).Items))
+
+	_, err = db.DeleteItem(ctx, &DeleteItemInput{
+		TableName: "orders",
+		Key:       map[string]types.AttributeValue{"user": &types.AttributeValueMemberS{Value: "U1"}, "id": &types.AttributeValueMemberS{Value: "O2"}},
+	})
+	require.NoError(t, err)
+	_, err = db.BatchWriteItem(ctx, &BatchWriteItemInput{RequestItems: map[string][]types.WriteRequest{
+		"orders": {{PutRequest: &types.PutRequest{Item: order("U3", "O6", "OPEN", 0)}}},
+	}})
+	require.NoError(t, err)
+	_, err = db.TransactWriteItems(ctx, &TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Put: &types.Put{TableName: s("orders"), Item: order("U3", "O7", "OPEN", 9)}},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"O6", "O3", "O7"}, ids(query("status-created", "status", "OPEN", 0, nil).Items))
+
+	// the item is not written if the key of an index has another type
+	invalid := order("U9", "O9", "OPEN", 1)
+	invalid["status"] = &types.AttributeValueMemberN{Value: "1"}
+	_, err = db.PutItem(ctx, &PutItemInput{TableName: "orders", Item: invalid})
+	require.ErrorIs(t, err, ErrInvalidPartitionKeyType)
+	got2, err := db.GetItem(ctx, &GetItemInput{TableName: "orders", Key: map[string]types.AttributeValue{"user": invalid["user"], "id": invalid["id"]}})
+	require.NoError(t, err)
+	require.Nil(t, got2.Item)
+
+	// reopen
+	require.NoError(t, db.Close())
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, []string{"O6", "O3", "O7"}, ids(query("status-created", "status", "OPEN", 0, nil).Items))
+	desc, err := db.DescribeTable(ctx, &DescribeTableInput{TableName: "orders"})
+	require.NoError(t, err)
+	require.Len(t, desc.Table.GlobalSecondaryIndexes, 3)
+	require.Equal(t, "status-created", *desc.Table.GlobalSecondaryIndexes[0].IndexName)
+	require.Equal(t, int64(4), *desc.Table.GlobalSecondaryIndexes[0].ItemCount)
+	require.Equal(t, int64(6), *desc.Table.GlobalSecondaryIndexes[2].ItemCount)
+	require.Len(t, desc.Table.AttributeDefinitions, 5)
+
+	_, err = db.Query(ctx, &QueryInput{
+		TableName:                 "orders",
+		IndexName:                 "unknown",
+		KeyConditionExpression:    "user = :u",
+		ExpressionAttributeValues: map[string]types.AttributeValue{":u": &types.AttributeValueMemberS{Value: "U1"}},
+	})
+	require.ErrorIs(t, err, ErrIndexNotFound)
+
+	// invalid indexes
+	for name, gsi := range map[string]types.GlobalSecondaryIndex{
+		"no projection":  {IndexName: s("index"), KeySchema: keys("status", "")},
+		"invalid name":   {IndexName: s("x"), KeySchema: keys("status", ""), Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll}},
+		"undefined key":  {IndexName: s("index"), KeySchema: keys("unknown", ""), Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll}},
+		"empty include":  {IndexName: s("index"), KeySchema: keys("status", ""), Projection: &types.Projection{ProjectionType: types.ProjectionTypeInclude}},
+		"invalid schema": {IndexName: s("index"), KeySchema: keys("", ""), Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			input := *input
+			input.TableName = "invalid"
+			input.GlobalSecondaryIndexes = []types.GlobalSecondaryIndex{gsi}
+			_, err := db.CreateTable(ctx, &input)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestLocalSecondaryIndex(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-lsi")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	db, err := New(dir, c)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	s := func(v string) *string { return &v }
+	attr := func(name string, t types.ScalarAttributeType) types.AttributeDefinition {
+		return types.AttributeDefinition{AttributeName: s(name), AttributeType: t}
+	}
+	keys := func(pk, sk string) []types.KeySchemaElement {
+		schema := []types.KeySchemaElement{{AttributeName: s(pk), KeyType: types.KeyTypeHash}}
+		if sk != "" {
+			schema = append(schema, types.KeySchemaElement{AttributeName: s(sk), KeyType: types.KeyTypeRange})
+		}
+		return schema
+	}
+	input := &CreateTableInput{
+		TableName: "orders",
+		KeySchema: keys("user", "id"),
+		AttributeDefinitions: []types.AttributeDefinition{
+			attr("user", types.ScalarAttributeTypeS),
+			attr("id", types.ScalarAttributeTypeS),
+			attr("created", types.ScalarAttributeTypeN),
+			attr("status", types.ScalarAttributeTypeS),
+		},
+		LocalSecondaryIndexes: []types.LocalSecondaryIndex{
+			{
+				IndexName:  s("created"),
+				KeySchema:  keys("user", "created"),
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			},
+			{
+				IndexName:  s("status"),
+				KeySchema:  keys("user", "status"),
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeInclude, NonKeyAttributes: []string{"amount"}},
+			},
+		},
+		PartitionNum: 3,
+	}
+	_, err = db.CreateTable(ctx, input)
+	require.NoError(t, err)
+
+	order := func(user, id, status string, created int) map[string]types.AttributeValue {
+		item := map[string]types.AttributeValue{
+			"user":    &types.AttributeValueMemberS{Value: user},
+			"id":      &types.AttributeValueMemberS{Value: id},
+			"created": &types.AttributeValueMemberN{Value: fmt.Sprint(created)},
+			"amount":  &types.AttributeValueMemberN{Value: fmt.Sprint(created * 100)},
+			"note":    &types.AttributeValueMemberS{Value: "note " + id},
+		}
+		if status != "" {
+			item["status"] = &types.AttributeValueMemberS{Value: status}
+		}
+		return item
+	}
+	for _, item := range []map[string]types.AttributeValue{
+		order("U1", "O1", "OPEN", 3),
+		order("U1", "O2", "CLOSED", 1),
+		order("U1", "O3", "OPEN", 2),
+		order("U1", "O4", "", 4),
+		order("U2", "O5", "OPEN", 5),
+	} {
+		_, err := db.PutItem(ctx, &PutItemInput{TableName: "orders", Item: item})
+		require.NoError(t, err)
+	}
+	query := func(input *QueryInput) *QueryOutput {
+		input.TableName = "orders"
+		if input.KeyConditionExpression == "" {
+			input.KeyConditionExpression = "#u = :u"
+		}
+		if input.ExpressionAttributeNames == nil {
+			input.ExpressionAttributeNames = map[string]string{}
+		}
+		input.ExpressionAttributeNames["#u"] = "user"
+		if input.ExpressionAttributeValues == nil {
+			input.ExpressionAttributeValues = map[string]types.AttributeValue{}
+		}
+		input.ExpressionAttributeValues[":u"] = &types.AttributeValueMemberS{Value: "U1"}
+		output, err := db.Query(ctx, input)
+		require.NoError(t, err)
+		return output
+	}
+	ids := func(items []map[string]types.AttributeValue) []string {
+		var ids []string
+		for _, item := range items {
+			ids = append(ids, item["id"].(*types.AttributeValueMemberS).Value)
+		}
+		return ids
+	}
+
+	// in the order of the sort key of the index
+	output := query(&QueryInput{IndexName: "created"})
+	require.Equal(t, []string{"O2", "O3", "O1", "O4"}, ids(output.Items))
+	require.Equal(t, order("U1", "O3", "OPEN", 2), output.Items[1])
+	output = query(&QueryInput{
+		IndexName:                 "created",
+		KeyConditionExpression:    "#u = :u AND created BETWEEN :lo AND :hi",
+		ExpressionAttributeValues: map[string]types.AttributeValue{":lo": &types.AttributeValueMemberN{Value: "2"}, ":hi": &types.AttributeValueMemberN{Value: "3"}},
+		ScanIndexForward:          new(bool),
+	})
+	require.Equal(t, []string{"O1", "O3"}, ids(output.Items))
+
+	// sparse, with the projected attributes
+	output = query(&QueryInput{IndexName: "status"})
+	require.Equal(t, []string{"O2"}, ids(output.Items[:1]))
+	require.ElementsMatch(t, []string{"O1", "O3"}, ids(output.Items[1:]))
+	require.Equal(t, map[string]types.AttributeValue{
+		"user":   &types.AttributeValueMemberS{Value: "U1"},
+		"id":     &types.AttributeValueMemberS{Value: "O2"},
+		"status": &types.AttributeValueMemberS{Value: "CLOSED"},
+		"amount": &types.AttributeValueMemberN{Value: "100"},
+	}, output.Items[0])
+
+	// the attributes not projected are read from the table
+	output = query(&QueryInput{
+		IndexName:                "status",
+		KeyConditionExpression:   "#u = :u AND #s = :s",
+		ProjectionExpression:     "id, note, created",
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s": &types.AttributeValueMemberS{Value: "OPEN"},
+		},
+	})
+	require.ElementsMatch(t, []map[string]types.AttributeValue{
+		{"id": &types.AttributeValueMemberS{Value: "O1"}, "note": &types.AttributeValueMemberS{Value: "note O1"}, "created": &types.AttributeValueMemberN{Value: "3"}},
+		{"id": &types.AttributeValueMemberS{Value: "O3"}, "note": &types.AttributeValueMemberS{Value: "note O3"}, "created": &types.AttributeValueMemberN{Value: "2"}},
+	}, output.Items)
+	output = query(&QueryInput{
+		IndexName:                 "status",
+		FilterExpression:          "note = :n",
+		ExpressionAttributeValues: map[string]types.AttributeValue{":n": &types.AttributeValueMemberS{Value: "note O3"}},
+	})
+	require.Equal(t, []map[string]types.AttributeValue{{
+		"user":   &types.AttributeValueMemberS{Value: "U1"},
+		"id":     &types.AttributeValueMemberS{Value: "O3"},
+		"status": &types.AttributeValueMemberS{Value: "OPEN"},
+		"amount": &types.AttributeValueMemberN{Value: "200"},
+	}}, output.Items)
+	require.Equal(t, int32(3), output.ScannedCount)
+
+	// maintained by update and delete
+	_, err = db.UpdateItem(ctx, &UpdateItemInput{
+		TableName:                 "orders",
+		Key:                       map[string]types.AttributeValue{"user": &types.AttributeValueMemberS{Value: "U1"}, "id": &types.AttributeValueMemberS{Value: "O2"}},
+		UpdateExpression:          "SET created = :c REMOVE #s",
+		ExpressionAttributeNames:  map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":c": &types.AttributeValueMemberN{Value: "9"}},
+	})
+	require.NoError(t, err)
+	_, err = db.DeleteItem(ctx, &DeleteItemInput{
+		TableName: "orders",
+		Key:       map[string]types.AttributeValue{"user": &types.AttributeValueMemberS{Value: "U1"}, "id": &types.AttributeValueMemberS{Value: "O1"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"O3", "O4", "O2"}, ids(query(&QueryInput{IndexName: "created"}).Items))
+	require.Equal(t, []string{"O3"}, ids(query(&QueryInput{IndexName: "status"}).Items))
+
+	// reopen
+	require.NoError(t, db.Close())
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, []string{"O3", "O4", "O2"}, ids(query(&QueryInput{IndexName: "created"}).Items))
+	desc, err := db.DescribeTable(ctx, &DescribeTableInput{TableName: "orders"})
+	require.NoError(t, err)
+	require.Empty(t, desc.Table.GlobalSecondaryIndexes)
+	require.Len(t, desc.Table.LocalSecondaryIndexes, 2)
+	require.Equal(t, "status", *desc.Table.LocalSecondaryIndexes[1].IndexName)
+	require.Equal(t, keys("user", "status"), desc.Table.LocalSecondaryIndexes[1].KeySchema)
+	require.Equal(t, int64(4), *desc.Table.LocalSecondaryIndexes[0].ItemCount)
+	require.Equal(t, int64(2), *desc.Table.LocalSecondaryIndexes[1].ItemCount)
+
+	// invalid indexes
+	all := &types.Projection{ProjectionType: types.ProjectionTypeAll}
+	for name, input := range map[string]CreateTableInput{
+		"another partition key": {KeySchema: keys("user", "id"), LocalSecondaryIndexes: []types.LocalSecondaryIndex{
+			{IndexName: s("index"), KeySchema: keys("created", "status"), Projection: all},
+		}},
+		"no sort key": {KeySchema: keys("user", "id"), LocalSecondaryIndexes: []types.LocalSecondaryIndex{
+			{IndexName: s("index"), KeySchema: keys("user", ""), Projection: all},
+		}},
+		"table without sort key": {KeySchema: keys("user", ""), LocalSecondaryIndexes: []types.LocalSecondaryIndex{
+			{IndexName: s("index"), KeySchema: keys("user", "created"), Projection: all},
+		}},
+		"duplicate name": {
+			KeySchema:              keys("user", "id"),
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{IndexName: s("index"), KeySchema: keys("status", ""), Projection: all}},
+			LocalSecondaryIndexes:  []types.LocalSecondaryIndex{{IndexName: s("index"), KeySchema: keys("user", "created"), Projection: all}},
+		},
+		"too many": {KeySchema: keys("user", "id"), LocalSecondaryIndexes: make([]types.LocalSecondaryIndex, maxLocalSecondaryIndexes+1)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			input.TableName = "invalid"
+			for _, key := range input.KeySchema {
+				for _, def := range []types.AttributeDefinition{attr("user", types.ScalarAttributeTypeS), attr("id", types.ScalarAttributeTypeS)} {
+					if *def.AttributeName == *key.AttributeName {
+						input.AttributeDefinitions = append(input.AttributeDefinitions, def)
+					}
+				}
+			}
+			input.AttributeDefinitions = append(input.AttributeDefinitions, attr("created", types.ScalarAttributeTypeN), attr("status", types.ScalarAttributeTypeS))
+			_, err := db.CreateTable(ctx, &input)
+			require.ErrorIs(t, err, ErrInvalidIndex)
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 )
 
 type segment struct {
+	id    uint64
 	store *store
 	index *index
 
@@ -14,12 +15,18 @@ type segment struct {
 }
 
 func newSegment(dir string, segmentId uint64, c Config) (*segment, error) {
+	return openSegment(dir, segmentId, c, os.O_RDWR|os.O_CREATE|os.O_APPEND, os.O_RDWR|os.O_CREATE)
+}
+
+// openSegment opens the store and the index of the segment with the flags of the files.
+func openSegment(dir string, segmentId uint64, c Config, storeFlag, indexFlag int) (*segment, error) {
 	s := &segment{
+		id:     segmentId,
 		config: c,
 	}
 	storeFile, err := os.OpenFile(
 		filepath.Join(dir, fmt.Sprintf("%d.store", segmentId)),
-		os.O_RDWR|os.O_CREATE|os.O_APPEND,
+		storeFlag,
 		0600,
 	)
 	if err != nil {
@@ -32,7 +39,7 @@ func newSegment(dir string, segmentId uint64, c Config) (*segment, error) {
 
 	indexFile, err := os.OpenFile(
 		filepath.Join(dir, fmt.Sprintf("%d.index", segmentId)),
-		os.O_RDWR|os.O_CREATE,
+		indexFlag,
 		0600,
 	)
 	if err != nil {
@@ -53,20 +60,48 @@ func (s *segment) Read(in string) ([]byte, error) {
 	return s.store.Read(pos)
 }
 
-func (s *segment) Write(in string, data []byte) error {
-	_, pos, err := s.store.Append(data)
+// Write writes the data of the key, and returns the position of the data.
+func (s *segment) Write(in string, data []byte) (pos uint64, err error) {
+	_, pos, err = s.store.Append(data)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if err = s.index.Write(in, pos); err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	return pos, nil
 }
 
-func (s *segment) Delete(in string) error {
-	_, err := s.index.Delete(in)
-	return err
+// ReadAll returns the positions of the data written for the key in the order of the writes.
+func (s *segment) ReadAll(in string) ([]uint64, error) {
+	return s.index.ReadAll(in)
+}
+
+func (s *segment) ReadAt(pos uint64) ([]byte, error) {
+	return s.store.Read(pos)
+}
+
+// UnixNanoAt reads the UnixNano heading the record at pos without reading the rest of it.
+func (s *segment) UnixNanoAt(pos uint64) (int64, error) {
+	b := make([]byte, 8)
+	if _, err := s.store.ReadAt(b, int64(pos+lenWidth)); err != nil {
+		return 0, err
+	}
+	return int64(enc.Uint64(b)), nil
+}
+
+// dropUnwritten drops the index entries of the records not in the store file yet.
+// a process writing the segment flushes the index and the store separately.
+func (s *segment) dropUnwritten() {
+	s.index.filter(s.store.written)
+}
+
+// Sync writes the store and then the index to the disk, so the index never points past the store.
+func (s *segment) Sync() error {
+	if err := s.store.Sync(); err != nil {
+		return err
+	}
+	return s.index.Sync()
 }
 
 func (s *segment) IsMaxed() bool {
@@ -31,7 +31,7 @@ func TestSegment(t *testing.T) {
 
 	for i := uint64(0); i < 3; i++ {
 		k := fmt.Sprintf("%s%d", key, i)
-		err := s.Write(k, want)
+		_, err := s.Write(k, want)
 		require.NoError(t, err)
 
 		got, err := s.Read(k)
@@ -51,7 +51,7 @@ func TestSegment(t *testing.T) {
 	require.False(t, s.IsMaxed())
 
 	k := fmt.Sprintf("%s%d", key, 3)
-	err = s.Write(k, want)
+	_, err = s.Write(k, want)
 	require.NoError(t, err)
 	require.True(t, s.IsMaxed())
 
@@ -80,6 +80,27 @@ func (s *store) ReadAt(p []byte, off int64) (int, error) {
 	return s.File.ReadAt(p, off)
 }
 
+// written reports whether the record at pos is in the file, not in the buffer of another process writing it.
+func (s *store) written(pos uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size := make([]byte, lenWidth)
+	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
+		return false
+	}
+	return pos+lenWidth+enc.Uint64(size) <= s.size
+}
+
+// Sync flushes the buffer and writes the file to the disk.
+func (s *store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	return s.File.Sync()
+}
+
 func (s *store) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
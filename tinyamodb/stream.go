@@ -0,0 +1,583 @@
+package tinyamodb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var (
+	ErrStreamNotEnabled      = errors.New("stream is not enabled")
+	ErrInvalidStream         = errors.New("invalid stream specification")
+	ErrInvalidShardIterator  = errors.New("invalid shard iterator")
+	ErrTrimmedDataAccess     = errors.New("sequence number is beyond the trim horizon")
+	ErrInvalidSequenceNumber = errors.New("invalid sequence number")
+)
+
+// streamsDirName is the directory of the stream logs in the table directory.
+const streamsDirName = "streams"
+
+// defaultStreamRetention is the retention of the stream records without Config.Stream.
+const defaultStreamRetention = 24 * time.Hour
+
+// defaultStreamMaxSegmentBytes is the size of the log files of the shards without Config.Stream.
+const defaultStreamMaxSegmentBytes = 1024 * 1024
+
+// maxGetRecords is the max num of the records returned by GetRecords.
+const maxGetRecords = 1000
+
+// ttlPrincipal is the principal of the deletes of the expired items in the stream records.
+const ttlPrincipal = "dynamodb.amazonaws.com"
+
+// stream is the change log of the items of a table. each partition of the table has a shard,
+// an append-only log of the changes of its items.
+// the sequence numbers increase across the shards of the table in the order of the writes.
+type stream struct {
+	viewType  types.StreamViewType
+	retention time.Duration
+	// maxSegmentBytes is the size at which the log files are rotated.
+	maxSegmentBytes uint64
+	// c has the key schema of the table in Table.
+	c Config
+
+	mu sync.Mutex
+	// seq is the last sequence number.
+	seq uint64
+	// shard id start with 1
+	shards map[int]*streamShard
+}
+
+func newStream(dir string, viewType types.StreamViewType, c Config) (*stream, error) {
+	s := &stream{
+		viewType:        viewType,
+		retention:       c.Stream.Retention,
+		maxSegmentBytes: c.Stream.MaxSegmentBytes,
+		c:               c,
+		shards:          make(map[int]*streamShard, c.Partition.Num),
+	}
+	if s.retention == 0 {
+		s.retention = defaultStreamRetention
+	}
+	if s.maxSegmentBytes == 0 {
+		s.maxSegmentBytes = defaultStreamMaxSegmentBytes
+	}
+	for i := 1; i <= int(c.Partition.Num); i++ {
+		sh, err := s.openShard(filepath.Join(dir, streamsDirName, strconv.Itoa(i)))
+		if err != nil {
+			return nil, err
+		}
+		if n := len(sh.records); n > 0 && sh.records[n-1].seq > s.seq {
+			s.seq = sh.records[n-1].seq
+		}
+		s.shards[i] = sh
+	}
+	return s, nil
+}
+
+// nextSequenceNumber returns the sequence number of a new record.
+func (s *stream) nextSequenceNumber() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	return s.seq
+}
+
+func (s *stream) lastSequenceNumber() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seq
+}
+
+// horizon returns the time of the oldest record retained.
+func (s *stream) horizon() int64 {
+	return time.Now().Add(-s.retention).UnixNano()
+}
+
+func (s *stream) Close() error {
+	for _, sh := range s.shards {
+		if err := sh.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamShard is the log of a partition. the log is split into the files <first sequence number>.log
+// rotated at Stream.MaxSegmentBytes, and the files whose records are older than the retention are removed.
+type streamShard struct {
+	stream *stream
+	dir    string
+
+	mu    sync.Mutex
+	files []*store
+	// records are the positions of the records of the files in order.
+	records []streamPosition
+	// removed is the last sequence number of the files removed.
+	removed uint64
+}
+
+type streamPosition struct {
+	seq      uint64
+	unixNano int64
+	file     *store
+	pos      uint64
+}
+
+func (s *stream) openShard(dir string) (*streamShard, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	sh := &streamShard{stream: s, dir: dir}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var firsts []uint64
+	for _, e := range entries {
+		first, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), ".log"), 10, 64)
+		if err != nil || e.IsDir() || filepath.Ext(e.Name()) != ".log" {
+			continue
+		}
+		firsts = append(firsts, first)
+	}
+	slices.Sort(firsts)
+	for _, first := range firsts {
+		if err := sh.openFile(first); err != nil {
+			return nil, err
+		}
+	}
+	return sh, sh.trim()
+}
+
+// openFile opens the file of the first sequence number and reads the positions of its records.
+func (sh *streamShard) openFile(first uint64) error {
+	f, err := os.OpenFile(filepath.Join(sh.dir, fmt.Sprintf("%d.log", first)), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	file, err := newStore(f)
+	if err != nil {
+		return err
+	}
+	sh.files = append(sh.files, file)
+	for pos := uint64(0); pos < file.size; {
+		data, err := file.Read(pos)
+		if err != nil {
+			return err
+		}
+		if len(data) < 16 {
+			return fmt.Errorf("unexpected error: invalid stream record in '%s'", file.Name())
+		}
+		sh.records = append(sh.records, streamPosition{
+			seq:      enc.Uint64(data),
+			unixNano: int64(enc.Uint64(data[8:])),
+			file:     file,
+			pos:      pos,
+		})
+		pos += lenWidth + uint64(len(data))
+	}
+	return nil
+}
+
+// append writes the record of the change from old to item. item is a tombstone if deleted.
+// the caller must hold the lock of the partition.
+func (sh *streamShard) append(old, item Item) error {
+	s := sh.stream
+	live := item.(*tinyamodbItem)
+	var prev map[string]types.AttributeValue
+	if old != nil {
+		prev = old.(*tinyamodbItem).Item
+	}
+	record := map[string]types.AttributeValue{}
+	switch {
+	case live.deleted != 0:
+		record["EventName"] = &types.AttributeValueMemberS{Value: string(OperationTypeRemove)}
+		record["Keys"] = &types.AttributeValueMemberM{Value: keyAttributes(prev, s.c)}
+	case old == nil:
+		record["EventName"] = &types.AttributeValueMemberS{Value: string(OperationTypeInsert)}
+		record["Keys"] = &types.AttributeValueMemberM{Value: keyAttributes(live.Item, s.c)}
+	default:
+		record["EventName"] = &types.AttributeValueMemberS{Value: string(OperationTypeModify)}
+		record["Keys"] = &types.AttributeValueMemberM{Value: keyAttributes(live.Item, s.c)}
+	}
+	if live.deleted == systemDelete {
+		record["UserIdentity"] = &types.AttributeValueMemberS{Value: ttlPrincipal}
+	}
+	switch s.viewType {
+	case types.StreamViewTypeNewImage, types.StreamViewTypeNewAndOldImages:
+		if live.deleted == 0 {
+			record["NewImage"] = &types.AttributeValueMemberM{Value: live.Item}
+		}
+	}
+	switch s.viewType {
+	case types.StreamViewTypeOldImage, types.StreamViewTypeNewAndOldImages:
+		if prev != nil {
+			record["OldImage"] = &types.AttributeValueMemberM{Value: prev}
+		}
+	}
+
+	seq := s.nextSequenceNumber()
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, enc, seq); err != nil {
+		return err
+	}
+	var e encoder
+	if err := e.Encode(&types.AttributeValueMemberM{Value: record}, live.UnixNano, &buf); err != nil {
+		return err
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if len(sh.files) == 0 || sh.files[len(sh.files)-1].size >= s.maxSegmentBytes {
+		if err := sh.openFile(seq); err != nil {
+			return err
+		}
+		if err := sh.trim(); err != nil {
+			return err
+		}
+	}
+	file := sh.files[len(sh.files)-1]
+	_, pos, err := file.Append(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	sh.records = append(sh.records, streamPosition{seq: seq, unixNano: live.UnixNano, file: file, pos: pos})
+	return nil
+}
+
+// has reports whether the shard has the record of the change of the key of the item at the UnixNano of the item.
+func (sh *streamShard) has(item *tinyamodbItem) (bool, error) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	for _, p := range sh.records {
+		if p.unixNano != item.UnixNano {
+			continue
+		}
+		data, err := p.file.Read(p.pos)
+		if err != nil {
+			return false, err
+		}
+		var d decoder
+		av, _, err := d.Decode(bytes.NewReader(data[8:]))
+		if err != nil {
+			return false, err
+		}
+		keys, ok := av.(*types.AttributeValueMemberM).Value["Keys"].(*types.AttributeValueMemberM)
+		if !ok {
+			continue
+		}
+		key, err := NewTinyamoDbItem(keys.Value, sh.stream.c)
+		if err != nil {
+			return false, err
+		}
+		if key.strSha256Key == item.strSha256Key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// sync writes the files of the shard to the disk.
+func (sh *streamShard) sync() error {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	for _, file := range sh.files {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trim removes the files whose records are older than the retention except the last file.
+// the caller must hold sh.mu.
+func (sh *streamShard) trim() error {
+	horizon := sh.stream.horizon()
+	for len(sh.files) > 1 {
+		file := sh.files[0]
+		n := 0
+		for n < len(sh.records) && sh.records[n].file == file {
+			if sh.records[n].unixNano >= horizon {
+				return nil
+			}
+			n++
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		if err := os.Remove(file.Name()); err != nil {
+			return err
+		}
+		if n > 0 {
+			sh.removed = sh.records[n-1].seq
+		}
+		sh.files = sh.files[1:]
+		sh.records = sh.records[n:]
+	}
+	return nil
+}
+
+// read returns the records retained from the sequence number up to limit. 0 is the trim horizon.
+// it returns ErrTrimmedDataAccess if the record of the sequence number is not retained.
+func (sh *streamShard) read(from uint64, limit int) ([]Record, error) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if from > 0 && from <= sh.lastTrimmed() {
+		return nil, fmt.Errorf("%w: '%d'", ErrTrimmedDataAccess, from)
+	}
+	horizon := sh.stream.horizon()
+	i, _ := slices.BinarySearchFunc(sh.records, from, func(p streamPosition, seq uint64) int {
+		return compareUint64(p.seq, seq)
+	})
+	var records []Record
+	for _, p := range sh.records[i:] {
+		if len(records) >= limit {
+			break
+		}
+		if p.unixNano < horizon {
+			continue
+		}
+		data, err := p.file.Read(p.pos)
+		if err != nil {
+			return nil, err
+		}
+		var d decoder
+		av, unixNano, err := d.Decode(bytes.NewReader(data[8:]))
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, newRecord(p.seq, unixNano, av.(*types.AttributeValueMemberM).Value, sh.stream.viewType))
+	}
+	return records, nil
+}
+
+// trimHorizon returns the oldest sequence number retained, or 0 if no records are retained.
+func (sh *streamShard) trimHorizon() uint64 {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	horizon := sh.stream.horizon()
+	for _, p := range sh.records {
+		if p.unixNano >= horizon {
+			return p.seq
+		}
+	}
+	return 0
+}
+
+// trimmed returns the last sequence number of the records not retained, or 0 if all are retained.
+func (sh *streamShard) trimmed() uint64 {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.lastTrimmed()
+}
+
+// lastTrimmed returns the last sequence number of the records not retained. the caller must hold sh.mu.
+func (sh *streamShard) lastTrimmed() uint64 {
+	horizon := sh.stream.horizon()
+	seq := sh.removed
+	for _, p := range sh.records {
+		if p.unixNano >= horizon {
+			break
+		}
+		seq = p.seq
+	}
+	return seq
+}
+
+func (sh *streamShard) Close() error {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	for _, file := range sh.files {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compareUint64(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func newRecord(seq uint64, unixNano int64, record map[string]types.AttributeValue, viewType types.StreamViewType) Record {
+	r := Record{
+		SequenceNumber:              strconv.FormatUint(seq, 10),
+		ApproximateCreationDateTime: time.Unix(0, unixNano),
+		StreamViewType:              viewType,
+	}
+	if v, ok := record["EventName"].(*types.AttributeValueMemberS); ok {
+		r.EventName = OperationType(v.Value)
+	}
+	if v, ok := record["Keys"].(*types.AttributeValueMemberM); ok {
+		r.Keys = v.Value
+	}
+	if v, ok := record["NewImage"].(*types.AttributeValueMemberM); ok {
+		r.NewImage = v.Value
+	}
+	if v, ok := record["OldImage"].(*types.AttributeValueMemberM); ok {
+		r.OldImage = v.Value
+	}
+	if v, ok := record["UserIdentity"].(*types.AttributeValueMemberS); ok {
+		r.UserIdentity = &Identity{PrincipalId: v.Value, Type: "Service"}
+	}
+	return r
+}
+
+// shardIterator is the position of a consumer in a shard. it is encoded as '<table>/<shard>/<sequence number>'
+// and stays valid after the db is reopened.
+type shardIterator struct {
+	table string
+	shard int
+	// next is the sequence number of the next record to read.
+	next uint64
+}
+
+func (it shardIterator) String() string {
+	return fmt.Sprintf("%s/%d/%d", it.table, it.shard, it.next)
+}
+
+func parseShardIterator(s string) (shardIterator, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return shardIterator{}, fmt.Errorf("%w: '%s'", ErrInvalidShardIterator, s)
+	}
+	shard, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return shardIterator{}, fmt.Errorf("%w: '%s'", ErrInvalidShardIterator, s)
+	}
+	next, err := strconv.ParseUint(parts[2], 10, 64)
+	if err != nil {
+		return shardIterator{}, fmt.Errorf("%w: '%s'", ErrInvalidShardIterator, s)
+	}
+	return shardIterator{table: parts[0], shard: shard, next: next}, nil
+}
+
+// parseStreamSpecification returns the view type of the stream. empty is disabled.
+func parseStreamSpecification(spec *types.StreamSpecification) (types.StreamViewType, error) {
+	if spec == nil || spec.StreamEnabled == nil || !*spec.StreamEnabled {
+		return "", nil
+	}
+	switch spec.StreamViewType {
+	case types.StreamViewTypeKeysOnly, types.StreamViewTypeNewImage, types.StreamViewTypeOldImage, types.StreamViewTypeNewAndOldImages:
+		return spec.StreamViewType, nil
+	}
+	return "", fmt.Errorf("%w: invalid view type '%s'", ErrInvalidStream, spec.StreamViewType)
+}
+
+// tableStream returns the stream of the table in use. the caller must release the table after the use.
+func (db *Db) tableStream(name string) (*table, *stream, error) {
+	t, err := db.table(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.stream == nil {
+		t.release()
+		return nil, nil, fmt.Errorf("%w: table '%s'", ErrStreamNotEnabled, t.name)
+	}
+	return t, t.stream, nil
+}
+
+// DescribeStream returns the view type and the shards of the stream of the table.
+func (db *Db) DescribeStream(ctx context.Context, input *DescribeStreamInput) (*DescribeStreamOutput, error) {
+	t, s, err := db.tableStream(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	defer t.release()
+	desc := &StreamDescription{
+		TableName:      t.name,
+		StreamViewType: s.viewType,
+	}
+	for i := 1; i <= len(s.shards); i++ {
+		shard := Shard{ShardId: strconv.Itoa(i)}
+		if seq := s.shards[i].trimHorizon(); seq > 0 {
+			shard.StartingSequenceNumber = strconv.FormatUint(seq, 10)
+		}
+		desc.Shards = append(desc.Shards, shard)
+	}
+	return &DescribeStreamOutput{StreamDescription: desc}, nil
+}
+
+// GetShardIterator returns the iterator reading the shard from the position.
+func (db *Db) GetShardIterator(ctx context.Context, input *GetShardIteratorInput) (*GetShardIteratorOutput, error) {
+	t, s, err := db.tableStream(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	defer t.release()
+	id, err := strconv.Atoi(input.ShardId)
+	if err != nil || s.shards[id] == nil {
+		return nil, fmt.Errorf("%w: shard '%s' of table '%s'", ErrInvalidShardIterator, input.ShardId, t.name)
+	}
+	it := shardIterator{table: t.name, shard: id}
+	switch input.ShardIteratorType {
+	case ShardIteratorTypeTrimHorizon:
+	case ShardIteratorTypeLatest:
+		it.next = s.lastSequenceNumber() + 1
+	case ShardIteratorTypeAtSequenceNumber, ShardIteratorTypeAfterSequenceNumber:
+		seq, err := strconv.ParseUint(input.SequenceNumber, 10, 64)
+		if err != nil || seq == 0 {
+			return nil, fmt.Errorf("%w: '%s'", ErrInvalidSequenceNumber, input.SequenceNumber)
+		}
+		it.next = seq
+		if input.ShardIteratorType == ShardIteratorTypeAfterSequenceNumber {
+			it.next++
+		}
+		if it.next <= s.shards[id].trimmed() {
+			return nil, fmt.Errorf("%w: '%s' of shard '%s'", ErrTrimmedDataAccess, input.SequenceNumber, input.ShardId)
+		}
+	default:
+		return nil, fmt.Errorf("%w: invalid type '%s'", ErrInvalidShardIterator, input.ShardIteratorType)
+	}
+	return &GetShardIteratorOutput{ShardIterator: it.String()}, nil
+}
+
+// GetRecords returns the records from the iterator and the iterator of the next records.
+// it returns ErrTrimmedDataAccess if the records of the iterator are older than the retention.
+// the iterator of TRIM_HORIZON starts at the oldest record retained.
+func (db *Db) GetRecords(ctx context.Context, input *GetRecordsInput) (*GetRecordsOutput, error) {
+	it, err := parseShardIterator(input.ShardIterator)
+	if err != nil {
+		return nil, err
+	}
+	t, s, err := db.tableStream(it.table)
+	if err != nil {
+		return nil, err
+	}
+	defer t.release()
+	sh, found := s.shards[it.shard]
+	if !found {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidShardIterator, input.ShardIterator)
+	}
+	limit := int(input.Limit)
+	if limit <= 0 || limit > maxGetRecords {
+		limit = maxGetRecords
+	}
+	records, err := sh.read(it.next, limit)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(records); n > 0 {
+		seq, _ := strconv.ParseUint(records[n-1].SequenceNumber, 10, 64)
+		it.next = seq + 1
+	}
+	return &GetRecordsOutput{Records: records, NextShardIterator: it.String()}, nil
+}
@@ -0,0 +1,349 @@
+package tinyamodb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-stream")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.TimeToLive.SweepInterval = -1
+	db, err := New(dir, c)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	s := func(v string) *string { return &v }
+	enabled := true
+	create := func(name string, viewType types.StreamViewType) error {
+		_, err := db.CreateTable(ctx, &CreateTableInput{
+			TableName:            name,
+			KeySchema:            []types.KeySchemaElement{{AttributeName: s("id"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: s("id"), AttributeType: types.ScalarAttributeTypeS}},
+			StreamSpecification:  &types.StreamSpecification{StreamEnabled: &enabled, StreamViewType: viewType},
+			PartitionNum:         3,
+		})
+		return err
+	}
+	require.NoError(t, create("events", types.StreamViewTypeNewAndOldImages))
+	require.NoError(t, create("keys", types.StreamViewTypeKeysOnly))
+	require.ErrorIs(t, create("invalid", "ALL"), ErrInvalidStream)
+
+	item := func(id string, v int) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+			"v":  &types.AttributeValueMemberN{Value: strconv.Itoa(v)},
+		}
+	}
+	key := func(id string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}}
+	}
+	put := func(table, id string, v int) {
+		_, err := db.PutItem(ctx, &PutItemInput{TableName: table, Item: item(id, v)})
+		require.NoError(t, err)
+	}
+	// records reads the records of all the shards from the iterators of the type in the order of the sequence numbers,
+	// and returns the iterators of the next records by shard.
+	records := func(table string, iteratorType ShardIteratorType) ([]Record, map[string]string) {
+		desc, err := db.DescribeStream(ctx, &DescribeStreamInput{TableName: table})
+		require.NoError(t, err)
+		require.Len(t, desc.StreamDescription.Shards, 3)
+		var all []Record
+		next := make(map[string]string)
+		for _, shard := range desc.StreamDescription.Shards {
+			it, err := db.GetShardIterator(ctx, &GetShardIteratorInput{TableName: table, ShardId: shard.ShardId, ShardIteratorType: iteratorType})
+			require.NoError(t, err)
+			iterator := it.ShardIterator
+			for {
+				output, err := db.GetRecords(ctx, &GetRecordsInput{ShardIterator: iterator, Limit: 2})
+				require.NoError(t, err)
+				iterator = output.NextShardIterator
+				if len(output.Records) == 0 {
+					break
+				}
+				all = append(all, output.Records...)
+			}
+			next[shard.ShardId] = iterator
+		}
+		slices.SortFunc(all, func(a, b Record) int {
+			x, _ := strconv.ParseUint(a.SequenceNumber, 10, 64)
+			y, _ := strconv.ParseUint(b.SequenceNumber, 10, 64)
+			return compareUint64(x, y)
+		})
+		return all, next
+	}
+	events := func(records []Record) []string {
+		var events []string
+		for _, r := range records {
+			events = append(events, fmt.Sprintf("%s %s", r.EventName, r.Keys["id"].(*types.AttributeValueMemberS).Value))
+		}
+		return events
+	}
+
+	latest, _ := records("events", ShardIteratorTypeLatest)
+	require.Empty(t, latest)
+
+	put("events", "A", 1)
+	_, err = db.UpdateItem(ctx, &UpdateItemInput{
+		TableName:                 "events",
+		Key:                       key("A"),
+		UpdateExpression:          "SET v = :v",
+		ExpressionAttributeValues: map[string]types.AttributeValue{":v": &types.AttributeValueMemberN{Value: "2"}},
+	})
+	require.NoError(t, err)
+	put("events", "B", 1)
+	_, err = db.DeleteItem(ctx, &DeleteItemInput{TableName: "events", Key: key("A")})
+	require.NoError(t, err)
+	// deleting the item not found is not recorded
+	_, err = db.DeleteItem(ctx, &DeleteItemInput{TableName: "events", Key: key("A")})
+	require.NoError(t, err)
+	_, err = db.BatchWriteItem(ctx, &BatchWriteItemInput{RequestItems: map[string][]types.WriteRequest{
+		"events": {{PutRequest: &types.PutRequest{Item: item("C", 1)}}},
+	}})
+	require.NoError(t, err)
+	_, err = db.TransactWriteItems(ctx, &TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Put: &types.Put{TableName: s("events"), Item: item("D", 1)}},
+		{Delete: &types.Delete{TableName: s("events"), Key: key("B")}},
+	}})
+	require.NoError(t, err)
+	// deleted by TTL
+	_, err = db.UpdateTimeToLive(ctx, &UpdateTimeToLiveInput{
+		TableName:               "events",
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{AttributeName: s("v"), Enabled: &enabled},
+	})
+	require.NoError(t, err)
+	n, err := db.sweep()
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	got, next := records("events", ShardIteratorTypeTrimHorizon)
+	require.Equal(t, []string{"INSERT A", "MODIFY A", "INSERT B", "REMOVE A", "INSERT C", "INSERT D", "REMOVE B"}, events(got)[:7])
+	// the sweeper walks the partitions in any order
+	require.ElementsMatch(t, []string{"REMOVE C", "REMOVE D"}, events(got)[7:])
+	for i, r := range got {
+		require.Equal(t, strconv.Itoa(i+1), r.SequenceNumber)
+		require.Equal(t, types.StreamViewTypeNewAndOldImages, r.StreamViewType)
+		require.WithinDuration(t, time.Now(), r.ApproximateCreationDateTime, time.Minute)
+	}
+	require.Equal(t, item("A", 1), got[0].NewImage)
+	require.Nil(t, got[0].OldImage)
+	require.Equal(t, item("A", 2), got[1].NewImage)
+	require.Equal(t, item("A", 1), got[1].OldImage)
+	require.Nil(t, got[3].NewImage)
+	require.Equal(t, item("A", 2), got[3].OldImage)
+	require.Nil(t, got[3].UserIdentity)
+	require.Equal(t, &Identity{PrincipalId: "dynamodb.amazonaws.com", Type: "Service"}, got[7].UserIdentity)
+
+	// keys only
+	put("keys", "A", 1)
+	got, _ = records("keys", ShardIteratorTypeTrimHorizon)
+	require.Len(t, got, 1)
+	require.Equal(t, key("A"), got[0].Keys)
+	require.Nil(t, got[0].NewImage)
+	require.Equal(t, "1", got[0].SequenceNumber)
+
+	// resume after reopen
+	require.NoError(t, db.Close())
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+	put("events", "E", 1)
+	put("events", "F", 1)
+	var resumed []string
+	for _, iterator := range next {
+		output, err := db.GetRecords(ctx, &GetRecordsInput{ShardIterator: iterator})
+		require.NoError(t, err)
+		for _, r := range output.Records {
+			resumed = append(resumed, r.SequenceNumber)
+		}
+	}
+	require.ElementsMatch(t, []string{"10", "11"}, resumed)
+	desc, err := db.DescribeStream(ctx, &DescribeStreamInput{TableName: "events"})
+	require.NoError(t, err)
+	var after []Record
+	for _, shard := range desc.StreamDescription.Shards {
+		for _, iteratorType := range []ShardIteratorType{ShardIteratorTypeAtSequenceNumber, ShardIteratorTypeAfterSequenceNumber} {
+			it, err := db.GetShardIterator(ctx, &GetShardIteratorInput{TableName: "events", ShardId: shard.ShardId, ShardIteratorType: iteratorType, SequenceNumber: "10"})
+			require.NoError(t, err)
+			output, err := db.GetRecords(ctx, &GetRecordsInput{ShardIterator: it.ShardIterator})
+			require.NoError(t, err)
+			after = append(after, output.Records...)
+		}
+	}
+	// E and F at 10, and F after 10
+	require.ElementsMatch(t, []string{"INSERT E", "INSERT F", "INSERT F"}, events(after))
+
+	// errors
+	_, err = db.CreateTable(ctx, &CreateTableInput{
+		TableName:            "nostream",
+		KeySchema:            []types.KeySchemaElement{{AttributeName: s("id"), KeyType: types.KeyTypeHash}},
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: s("id"), AttributeType: types.ScalarAttributeTypeS}},
+	})
+	require.NoError(t, err)
+	_, err = db.DescribeStream(ctx, &DescribeStreamInput{TableName: "nostream"})
+	require.ErrorIs(t, err, ErrStreamNotEnabled)
+	_, err = db.GetRecords(ctx, &GetRecordsInput{ShardIterator: "events/x"})
+	require.ErrorIs(t, err, ErrInvalidShardIterator)
+	_, err = db.GetShardIterator(ctx, &GetShardIteratorInput{TableName: "events", ShardId: "9", ShardIteratorType: ShardIteratorTypeLatest})
+	require.ErrorIs(t, err, ErrInvalidShardIterator)
+	_, err = db.GetShardIterator(ctx, &GetShardIteratorInput{TableName: "events", ShardId: "1", ShardIteratorType: ShardIteratorTypeAtSequenceNumber, SequenceNumber: "x"})
+	require.ErrorIs(t, err, ErrInvalidSequenceNumber)
+}
+
+func TestStreamRetention(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-stream-retention")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Stream.Retention = 200 * time.Millisecond
+	c.Stream.MaxSegmentBytes = 128
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	s := func(v string) *string { return &v }
+	enabled := true
+	_, err = db.CreateTable(ctx, &CreateTableInput{
+		TableName:            "events",
+		KeySchema:            []types.KeySchemaElement{{AttributeName: s("id"), KeyType: types.KeyTypeHash}},
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: s("id"), AttributeType: types.ScalarAttributeTypeS}},
+		StreamSpecification:  &types.StreamSpecification{StreamEnabled: &enabled, StreamViewType: types.StreamViewTypeNewImage},
+		PartitionNum:         1,
+	})
+	require.NoError(t, err)
+	put := func(id string) {
+		_, err := db.PutItem(ctx, &PutItemInput{TableName: "events", Item: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		}})
+		require.NoError(t, err)
+	}
+	read := func() []string {
+		it, err := db.GetShardIterator(ctx, &GetShardIteratorInput{TableName: "events", ShardId: "1", ShardIteratorType: ShardIteratorTypeTrimHorizon})
+		require.NoError(t, err)
+		output, err := db.GetRecords(ctx, &GetRecordsInput{ShardIterator: it.ShardIterator})
+		require.NoError(t, err)
+		var seqs []string
+		for _, r := range output.Records {
+			seqs = append(seqs, r.SequenceNumber)
+		}
+		return seqs
+	}
+	for i := range 10 {
+		put(fmt.Sprint("old", i))
+	}
+	require.Len(t, read(), 10)
+	it, err := db.GetShardIterator(ctx, &GetShardIteratorInput{TableName: "events", ShardId: "1", ShardIteratorType: ShardIteratorTypeTrimHorizon})
+	require.NoError(t, err)
+	behind, err := db.GetRecords(ctx, &GetRecordsInput{ShardIterator: it.ShardIterator, Limit: 5})
+	require.NoError(t, err)
+	require.Len(t, behind.Records, 5)
+	files, err := os.ReadDir(filepath.Join(dir, "events", streamsDirName, "1"))
+	require.NoError(t, err)
+	require.Greater(t, len(files), 1)
+
+	time.Sleep(300 * time.Millisecond)
+	// the old records are skipped at once, and the files of them are removed on the rotation.
+	require.Empty(t, read())
+	// the iterator left behind the trim horizon does not skip the records lost
+	_, err = db.GetRecords(ctx, &GetRecordsInput{ShardIterator: behind.NextShardIterator})
+	require.ErrorIs(t, err, ErrTrimmedDataAccess)
+	for i := range 10 {
+		put(fmt.Sprint("new", i))
+	}
+	_, err = db.GetRecords(ctx, &GetRecordsInput{ShardIterator: behind.NextShardIterator})
+	require.ErrorIs(t, err, ErrTrimmedDataAccess)
+	require.Equal(t, []string{"11", "12", "13", "14", "15", "16", "17", "18", "19", "20"}, read())
+	files, err = os.ReadDir(filepath.Join(dir, "events", streamsDirName, "1"))
+	require.NoError(t, err)
+	for _, file := range files {
+		first, err := strconv.Atoi(strings.TrimSuffix(file.Name(), ".log"))
+		require.NoError(t, err)
+		require.Greater(t, first, 10)
+	}
+	desc, err := db.DescribeStream(ctx, &DescribeStreamInput{TableName: "events"})
+	require.NoError(t, err)
+	require.Equal(t, "11", desc.StreamDescription.Shards[0].StartingSequenceNumber)
+	_, err = db.GetShardIterator(ctx, &GetShardIteratorInput{TableName: "events", ShardId: "1", ShardIteratorType: ShardIteratorTypeAfterSequenceNumber, SequenceNumber: "5"})
+	require.ErrorIs(t, err, ErrTrimmedDataAccess)
+}
+
+func TestStreamLargeImages(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-stream-large")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	s := func(v string) *string { return &v }
+	enabled := true
+	_, err = db.CreateTable(ctx, &CreateTableInput{
+		TableName:            "events",
+		KeySchema:            []types.KeySchemaElement{{AttributeName: s("id"), KeyType: types.KeyTypeHash}},
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: s("id"), AttributeType: types.ScalarAttributeTypeS}},
+		StreamSpecification:  &types.StreamSpecification{StreamEnabled: &enabled, StreamViewType: types.StreamViewTypeNewAndOldImages},
+		PartitionNum:         1,
+	})
+	require.NoError(t, err)
+
+	// 255 attributes, and a name and a string of 255 bytes
+	item := func(v string) map[string]types.AttributeValue {
+		item := map[string]types.AttributeValue{
+			"id":                     &types.AttributeValueMemberS{Value: "A"},
+			strings.Repeat("n", 255): &types.AttributeValueMemberS{Value: v},
+		}
+		for i := len(item); i < 255; i++ {
+			item[fmt.Sprint("a", i)] = &types.AttributeValueMemberN{Value: strconv.Itoa(i)}
+		}
+		return item
+	}
+	put := func(item map[string]types.AttributeValue) error {
+		_, err := db.PutItem(ctx, &PutItemInput{TableName: "events", Item: item})
+		return err
+	}
+	first, second := item(strings.Repeat("1", 255)), item(strings.Repeat("2", 255))
+	require.NoError(t, put(first))
+	require.NoError(t, put(second))
+
+	// the values too long to be stored are rejected, and not recorded
+	require.ErrorIs(t, put(item(strings.Repeat("3", 256))), ErrValueTooLong)
+	many := item("3")
+	many["a255"] = &types.AttributeValueMemberN{Value: "255"}
+	require.ErrorIs(t, put(many), ErrValueTooLong)
+
+	it, err := db.GetShardIterator(ctx, &GetShardIteratorInput{TableName: "events", ShardId: "1", ShardIteratorType: ShardIteratorTypeTrimHorizon})
+	require.NoError(t, err)
+	output, err := db.GetRecords(ctx, &GetRecordsInput{ShardIterator: it.ShardIterator})
+	require.NoError(t, err)
+	require.Len(t, output.Records, 2)
+	require.Equal(t, first, output.Records[0].NewImage)
+	require.Equal(t, second, output.Records[1].NewImage)
+	require.Equal(t, first, output.Records[1].OldImage)
+	got, err := db.GetItem(ctx, &GetItemInput{TableName: "events", Key: map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "A"}}})
+	require.NoError(t, err)
+	require.Equal(t, second, got.Item)
+}
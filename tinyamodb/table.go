@@ -0,0 +1,636 @@
+package tinyamodb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var (
+	ErrTableNotFound    = errors.New("table not found")
+	ErrTableExists      = errors.New("table already exists")
+	ErrInvalidTableName = errors.New("table name must be 3 to 255 characters of a-z, A-Z, 0-9, '_', '-' and '.'")
+	ErrInvalidKeySchema = errors.New("invalid key schema")
+	ErrConfigMismatch   = errors.New("config conflicts with the table metadata")
+	ErrTableFormat      = errors.New("unsupported table format")
+)
+
+// defaultTableName is the name of the table of Config.Table when Config.Table.Name is empty.
+const defaultTableName = "default"
+
+// tableMetadataName is the file of the table metadata in the table directory.
+const tableMetadataName = "table.json"
+
+// tableFormatVersion is the version of the files of a table. it is written in the metadata
+// and a table of another version is not opened.
+const tableFormatVersion = 1
+
+// the segment limits of the tables created without Config.Segment.
+const (
+	defaultMaxStoreBytes = 1024
+	defaultMaxIndexBytes = 1024
+)
+
+var tableNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,255}$`)
+
+// table is a named table stored in its own directory <db dir>/<name>.
+type table struct {
+	name string
+	dir  string
+	// partition id start with 1
+	partitions map[int]*partition
+	c          Config
+	createdAt  time.Time
+	// indexes are the global and local secondary indexes of the table in the order of their creation.
+	indexes []*secondaryIndex
+
+	// stream is the change log of the items. nil is disabled.
+	stream *stream
+
+	// mu guards the settings changed after the creation.
+	mu sync.RWMutex
+	// timeToLiveAttribute is the TTL attribute. empty is disabled.
+	timeToLiveAttribute string
+
+	// useMu guards the uses of the table by the requests. DeleteTable waits on idle for them to end.
+	useMu   sync.Mutex
+	idle    *sync.Cond
+	users   int
+	deleted bool
+}
+
+// tableMetadata is the schema and the storage settings of a table written at creation.
+// they cannot be changed after the items are written, except TimeToLiveAttribute.
+type tableMetadata struct {
+	FormatVersion    int
+	PartitionKey     string
+	PartitionKeyType types.ScalarAttributeType
+	SortKey          string                    `json:",omitempty"`
+	SortKeyType      types.ScalarAttributeType `json:",omitempty"`
+	Partitions       int
+	MaxStoreBytes    uint64
+	MaxIndexBytes    uint64
+	CreatedAt        time.Time
+
+	GlobalSecondaryIndexes []indexMetadata `json:",omitempty"`
+	LocalSecondaryIndexes  []indexMetadata `json:",omitempty"`
+	TimeToLiveAttribute    string          `json:",omitempty"`
+	// StreamViewType is the view type of the stream of the table. empty is disabled.
+	StreamViewType types.StreamViewType `json:",omitempty"`
+}
+
+// createTable creates the directory of the table with its schema, partitions and indexes.
+// the key schema and the partition num are of c.
+func createTable(dir, name string, c Config, global, local []indexMetadata, viewType types.StreamViewType) (*table, error) {
+	if !tableNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidTableName, name)
+	}
+	if err := validateKeyTypes(c); err != nil {
+		return nil, err
+	}
+	if c.Table.PartitionKey == "" {
+		return nil, fmt.Errorf("%w: partition key is required", ErrInvalidKeySchema)
+	}
+	m := newTableMetadata(c, global, local, viewType)
+
+	tdir := filepath.Join(dir, name)
+	if err := os.Mkdir(tdir, 0755); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("%w: '%s'", ErrTableExists, name)
+		}
+		return nil, err
+	}
+	if err := writeTableMetadata(tdir, m); err != nil {
+		return nil, err
+	}
+	return newTable(dir, name, m, c)
+}
+
+// newTableMetadata returns the metadata of a new table of the key schema and the storage settings of c.
+func newTableMetadata(c Config, global, local []indexMetadata, viewType types.StreamViewType) tableMetadata {
+	if c.Table.PartitionKeyType == "" {
+		c.Table.PartitionKeyType = types.ScalarAttributeTypeS
+	}
+	if c.Table.SortKey != "" && c.Table.SortKeyType == "" {
+		c.Table.SortKeyType = types.ScalarAttributeTypeS
+	}
+	if c.Partition.Num == 0 {
+		c.Partition.Num = 10
+	}
+	if c.Segment.MaxStoreBytes == 0 {
+		c.Segment.MaxStoreBytes = defaultMaxStoreBytes
+	}
+	if c.Segment.MaxIndexBytes == 0 {
+		c.Segment.MaxIndexBytes = defaultMaxIndexBytes
+	}
+	return tableMetadata{
+		FormatVersion:    tableFormatVersion,
+		PartitionKey:     c.Table.PartitionKey,
+		PartitionKeyType: c.Table.PartitionKeyType,
+		SortKey:          c.Table.SortKey,
+		SortKeyType:      c.Table.SortKeyType,
+		Partitions:       int(c.Partition.Num),
+		MaxStoreBytes:    c.Segment.MaxStoreBytes,
+		MaxIndexBytes:    c.Segment.MaxIndexBytes,
+		CreatedAt:        time.Now().UTC(),
+
+		GlobalSecondaryIndexes: global,
+		LocalSecondaryIndexes:  local,
+		StreamViewType:         viewType,
+	}
+}
+
+// writeTableMetadata writes the metadata into the table directory tdir.
+// the table exists once the metadata is renamed into place.
+func writeTableMetadata(tdir string, m tableMetadata) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(tdir, tableMetadataName+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(tdir, tableMetadataName))
+}
+
+// openTable opens the table by its metadata.
+// c is checked against the metadata, and the fields of c not set are filled from it.
+// the key schema, the partition num and the segment limits of c are checked only if schema is true,
+// since Config.Table is the schema of one table. the other tables keep the limits of their metadata.
+func openTable(dir, name string, c Config, schema bool) (*table, error) {
+	m, err := readTableMetadata(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.check(name, c, schema); err != nil {
+		return nil, err
+	}
+	return newTable(dir, name, m, c)
+}
+
+// readTableMetadata reads the metadata of the table in dir, and checks that its partitions exist.
+func readTableMetadata(dir, name string) (tableMetadata, error) {
+	tdir := filepath.Join(dir, name)
+	data, err := os.ReadFile(filepath.Join(tdir, tableMetadataName))
+	if err != nil {
+		return tableMetadata{}, err
+	}
+	var m tableMetadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return tableMetadata{}, fmt.Errorf("%w: cannot read the metadata of table '%s': %w", ErrTableFormat, name, err)
+	}
+	if m.FormatVersion != tableFormatVersion {
+		return tableMetadata{}, fmt.Errorf("%w: table '%s' has version %d, but %d is supported", ErrTableFormat, name, m.FormatVersion, tableFormatVersion)
+	}
+	if m.Partitions <= 0 || m.MaxStoreBytes == 0 || m.MaxIndexBytes == 0 {
+		return tableMetadata{}, fmt.Errorf("%w: table '%s' has invalid metadata", ErrTableFormat, name)
+	}
+	for i := 1; i <= m.Partitions; i++ {
+		if _, err := os.Stat(filepath.Join(tdir, strconv.Itoa(i))); err != nil {
+			return tableMetadata{}, fmt.Errorf("unexpected error: partition '%d' of table '%s' is not found", i, name)
+		}
+	}
+	return m, nil
+}
+
+// check returns ErrConfigMismatch if a field of c set is different from the metadata.
+func (m tableMetadata) check(name string, c Config, schema bool) error {
+	mismatch := func(field string, want, got any) error {
+		return fmt.Errorf("%w: table '%s' has %s '%v', but config has '%v'", ErrConfigMismatch, name, field, want, got)
+	}
+	if !schema {
+		return nil
+	}
+	if v := c.Segment.MaxStoreBytes; v != 0 && v != m.MaxStoreBytes {
+		return mismatch("Segment.MaxStoreBytes", m.MaxStoreBytes, v)
+	}
+	if v := c.Segment.MaxIndexBytes; v != 0 && v != m.MaxIndexBytes {
+		return mismatch("Segment.MaxIndexBytes", m.MaxIndexBytes, v)
+	}
+	if v := int(c.Partition.Num); v != 0 && v != m.Partitions {
+		return mismatch("Partition.Num", m.Partitions, v)
+	}
+	if v := c.Table.PartitionKey; v != m.PartitionKey {
+		return mismatch("Table.PartitionKey", m.PartitionKey, v)
+	}
+	if v := c.Table.PartitionKeyType; v != "" && v != m.PartitionKeyType {
+		return mismatch("Table.PartitionKeyType", m.PartitionKeyType, v)
+	}
+	if v := c.Table.SortKey; v != m.SortKey {
+		return mismatch("Table.SortKey", m.SortKey, v)
+	}
+	if v := c.Table.SortKeyType; v != "" && v != m.SortKeyType {
+		return mismatch("Table.SortKeyType", m.SortKeyType, v)
+	}
+	return nil
+}
+
+// newTable opens the partitions of the table. the fields of c are replaced with the metadata.
+func newTable(dir, name string, m tableMetadata, c Config) (*table, error) {
+	c = m.config(name, c)
+	t := &table{
+		name:       name,
+		dir:        filepath.Join(dir, name),
+		partitions: make(map[int]*partition, m.Partitions),
+		c:          c,
+		createdAt:  m.CreatedAt,
+
+		timeToLiveAttribute: m.TimeToLiveAttribute,
+	}
+	t.idle = sync.NewCond(&t.useMu)
+	for i := 1; i <= m.Partitions; i++ {
+		var err error
+		t.partitions[i], err = newPartition(t.dir, i, c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, xm := range m.GlobalSecondaryIndexes {
+		x, err := newSecondaryIndex(t.dir, xm, c, nil)
+		if err != nil {
+			return nil, err
+		}
+		t.indexes = append(t.indexes, x)
+	}
+	for _, xm := range m.LocalSecondaryIndexes {
+		x, err := newSecondaryIndex(t.dir, xm, c, t.partitions)
+		if err != nil {
+			return nil, err
+		}
+		t.indexes = append(t.indexes, x)
+	}
+	if m.StreamViewType != "" {
+		var err error
+		if t.stream, err = newStream(t.dir, m.StreamViewType, c); err != nil {
+			return nil, err
+		}
+	}
+	for i, p := range t.partitions {
+		p.indexes = t.indexes
+		if t.stream != nil {
+			p.stream = t.stream.shards[i]
+		}
+	}
+	return t, nil
+}
+
+// config returns c with the fields of the table replaced with the metadata.
+func (m tableMetadata) config(name string, c Config) Config {
+	c.Partition.Num = uint8(m.Partitions)
+	c.Segment.MaxStoreBytes = m.MaxStoreBytes
+	c.Segment.MaxIndexBytes = m.MaxIndexBytes
+	c.Table.Name = name
+	c.Table.PartitionKey = m.PartitionKey
+	c.Table.PartitionKeyType = m.PartitionKeyType
+	c.Table.SortKey = m.SortKey
+	c.Table.SortKeyType = m.SortKeyType
+	return c
+}
+
+// maxListTables is the max num of the table names returned by ListTables.
+const maxListTables = 100
+
+// CreateTable creates the table in the directory <db dir>/<TableName>.
+func (db *Db) CreateTable(ctx context.Context, input *CreateTableInput) (*CreateTableOutput, error) {
+	attributeTypes, err := attributeDefinitions(input.AttributeDefinitions)
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]struct{}, len(attributeTypes))
+	c, err := withKeySchema(db.c, input.KeySchema, attributeTypes, used)
+	if err != nil {
+		return nil, err
+	}
+	global, local, err := parseSecondaryIndexes(input, c, attributeTypes, used)
+	if err != nil {
+		return nil, err
+	}
+	viewType, err := parseStreamSpecification(input.StreamSpecification)
+	if err != nil {
+		return nil, err
+	}
+	for name := range attributeTypes {
+		if _, found := used[name]; !found {
+			return nil, fmt.Errorf("%w: attribute '%s' is defined but not used", ErrInvalidKeySchema, name)
+		}
+	}
+	if input.PartitionNum > 0 {
+		c.Partition.Num = input.PartitionNum
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, found := db.tables[input.TableName]; found {
+		return nil, fmt.Errorf("%w: '%s'", ErrTableExists, input.TableName)
+	}
+	t, err := createTable(db.dir, input.TableName, c, global, local, viewType)
+	if err != nil {
+		return nil, err
+	}
+	db.tables[t.name] = t
+	return &CreateTableOutput{TableDescription: t.describe()}, nil
+}
+
+// DeleteTable closes the table and removes its directory.
+// the requests using the table in progress are finished before the table is closed,
+// and the requests after it return ErrTableNotFound.
+func (db *Db) DeleteTable(ctx context.Context, input *DeleteTableInput) (*DeleteTableOutput, error) {
+	db.mu.Lock()
+	t, found := db.tables[input.TableName]
+	if !found {
+		db.mu.Unlock()
+		return nil, fmt.Errorf("%w: '%s'", ErrTableNotFound, input.TableName)
+	}
+	delete(db.tables, t.name)
+	// the requests in progress may look up other tables.
+	db.mu.Unlock()
+
+	t.drain()
+	desc := t.describe()
+	desc.TableStatus = types.TableStatusDeleting
+	if err := t.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(t.dir); err != nil {
+		return nil, err
+	}
+	return &DeleteTableOutput{TableDescription: desc}, nil
+}
+
+// ListTables returns the names of the tables in order.
+func (db *Db) ListTables(ctx context.Context, input *ListTablesInput) (*ListTablesOutput, error) {
+	limit := int(input.Limit)
+	if limit <= 0 || limit > maxListTables {
+		limit = maxListTables
+	}
+	db.mu.RLock()
+	names := make([]string, 0, len(db.tables))
+	for name := range db.tables {
+		if name > input.ExclusiveStartTableName {
+			names = append(names, name)
+		}
+	}
+	db.mu.RUnlock()
+	slices.Sort(names)
+
+	output := &ListTablesOutput{TableNames: names}
+	if len(names) > limit {
+		output.TableNames = names[:limit]
+		output.LastEvaluatedTableName = names[limit-1]
+	}
+	return output, nil
+}
+
+// DescribeTable returns the schema and the item count of the table.
+func (db *Db) DescribeTable(ctx context.Context, input *DescribeTableInput) (*DescribeTableOutput, error) {
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	defer t.release()
+	return &DescribeTableOutput{Table: t.describe()}, nil
+}
+
+// isTableDir reports whether the directory has the metadata of a table.
+func isTableDir(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, tableMetadataName))
+	return err == nil
+}
+
+func validateKeyTypes(c Config) error {
+	for _, t := range []types.ScalarAttributeType{c.Table.PartitionKeyType, c.Table.SortKeyType} {
+		switch t {
+		case "", types.ScalarAttributeTypeS, types.ScalarAttributeTypeN, types.ScalarAttributeTypeB:
+		default:
+			return fmt.Errorf("%w: '%s'", ErrInvalidKeyType, t)
+		}
+	}
+	return nil
+}
+
+// attributeDefinitions returns the types of the attributes by name.
+func attributeDefinitions(definitions []types.AttributeDefinition) (map[string]types.ScalarAttributeType, error) {
+	attributeTypes := make(map[string]types.ScalarAttributeType, len(definitions))
+	for _, d := range definitions {
+		name := stringValue(d.AttributeName)
+		if _, found := attributeTypes[name]; found || name == "" {
+			return nil, fmt.Errorf("%w: invalid attribute definition '%s'", ErrInvalidKeySchema, name)
+		}
+		switch d.AttributeType {
+		case types.ScalarAttributeTypeS, types.ScalarAttributeTypeN, types.ScalarAttributeTypeB:
+		default:
+			return nil, fmt.Errorf("%w: '%s'", ErrInvalidKeyType, d.AttributeType)
+		}
+		attributeTypes[name] = d.AttributeType
+	}
+	return attributeTypes, nil
+}
+
+// withKeySchema returns c having the key schema in Table. the types of the keys are of attributeTypes,
+// and the names of the keys are added to used.
+func withKeySchema(c Config, schema []types.KeySchemaElement, attributeTypes map[string]types.ScalarAttributeType, used map[string]struct{}) (Config, error) {
+	c.Table.PartitionKey, c.Table.SortKey = "", ""
+	c.Table.PartitionKeyType, c.Table.SortKeyType = "", ""
+	for _, e := range schema {
+		name := stringValue(e.AttributeName)
+		t, found := attributeTypes[name]
+		if !found {
+			return c, fmt.Errorf("%w: attribute '%s' is not defined", ErrInvalidKeySchema, name)
+		}
+		switch {
+		case e.KeyType == types.KeyTypeHash && c.Table.PartitionKey == "":
+			c.Table.PartitionKey, c.Table.PartitionKeyType = name, t
+		case e.KeyType == types.KeyTypeRange && c.Table.SortKey == "" && name != c.Table.PartitionKey:
+			c.Table.SortKey, c.Table.SortKeyType = name, t
+		default:
+			return c, fmt.Errorf("%w: key schema must have one HASH key and at most one RANGE key", ErrInvalidKeySchema)
+		}
+		used[name] = struct{}{}
+	}
+	if c.Table.PartitionKey == "" {
+		return c, fmt.Errorf("%w: key schema must have one HASH key and at most one RANGE key", ErrInvalidKeySchema)
+	}
+	return c, nil
+}
+
+// keySchema returns the key schema in c.Table.
+func keySchema(c Config) []types.KeySchemaElement {
+	pk := c.Table.PartitionKey
+	schema := []types.KeySchemaElement{
+		{AttributeName: &pk, KeyType: types.KeyTypeHash},
+	}
+	if sk := c.Table.SortKey; sk != "" {
+		schema = append(schema, types.KeySchemaElement{AttributeName: &sk, KeyType: types.KeyTypeRange})
+	}
+	return schema
+}
+
+// describe returns the description of the table.
+func (t *table) describe() *types.TableDescription {
+	name := t.name
+	desc := &types.TableDescription{
+		TableName:   &name,
+		TableStatus: types.TableStatusActive,
+		KeySchema:   keySchema(t.c),
+	}
+	// the definitions of the key attributes of the table and the indexes.
+	definitions := make(map[string]types.ScalarAttributeType)
+	define := func(c Config) {
+		for _, key := range []struct {
+			name string
+			t    types.ScalarAttributeType
+		}{
+			{c.Table.PartitionKey, c.Table.PartitionKeyType},
+			{c.Table.SortKey, c.Table.SortKeyType},
+		} {
+			if _, found := definitions[key.name]; found || key.name == "" {
+				continue
+			}
+			definitions[key.name] = key.t
+			desc.AttributeDefinitions = append(desc.AttributeDefinitions, types.AttributeDefinition{
+				AttributeName: &key.name,
+				AttributeType: key.t,
+			})
+		}
+	}
+	define(t.c)
+	for _, x := range t.indexes {
+		define(x.c)
+		if x.local {
+			desc.LocalSecondaryIndexes = append(desc.LocalSecondaryIndexes, x.describeLocal())
+		} else {
+			desc.GlobalSecondaryIndexes = append(desc.GlobalSecondaryIndexes, x.describeGlobal())
+		}
+	}
+	createdAt := t.createdAt
+	desc.CreationDateTime = &createdAt
+	if t.stream != nil {
+		enabled := true
+		desc.StreamSpecification = &types.StreamSpecification{StreamEnabled: &enabled, StreamViewType: t.stream.viewType}
+	}
+
+	var count int64
+	for _, p := range t.partitions {
+		p.mu.RLock()
+		count += int64(p.keys.Len())
+		p.mu.RUnlock()
+	}
+	desc.ItemCount = &count
+	return desc
+}
+
+// acquire marks the table in use by a request. it returns ErrTableNotFound if the table is deleted.
+func (t *table) acquire() error {
+	t.useMu.Lock()
+	defer t.useMu.Unlock()
+	if t.deleted {
+		return fmt.Errorf("%w: '%s'", ErrTableNotFound, t.name)
+	}
+	t.users++
+	return nil
+}
+
+// release ends a use of the table.
+func (t *table) release() {
+	t.useMu.Lock()
+	defer t.useMu.Unlock()
+	t.users--
+	if t.users == 0 {
+		t.idle.Broadcast()
+	}
+}
+
+// drain marks the table deleted and waits for the uses of the table to end.
+func (t *table) drain() {
+	t.useMu.Lock()
+	defer t.useMu.Unlock()
+	t.deleted = true
+	for t.users > 0 {
+		t.idle.Wait()
+	}
+}
+
+// sync writes the partitions of the ids and the secondary indexes updated by them to the disk.
+// the caller must hold the locks of the partitions of the ids.
+func (t *table) sync(ids []int) error {
+	for _, id := range ids {
+		if err := t.partitions[id].sync(); err != nil {
+			return err
+		}
+	}
+	for _, x := range t.indexes {
+		if x.local {
+			// locked with the partitions of the table
+			for _, id := range ids {
+				if err := x.partitions[id].sync(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for _, p := range x.partitions {
+			p.mu.Lock()
+			err := p.sync()
+			p.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *table) Close() error {
+	for _, p := range t.partitions {
+		if err := p.Close(); err != nil {
+			return err
+		}
+	}
+	for _, x := range t.indexes {
+		if err := x.Close(); err != nil {
+			return err
+		}
+	}
+	if t.stream != nil {
+		return t.stream.Close()
+	}
+	return nil
+}
+
+// index returns the secondary index of the name.
+func (t *table) index(name string) (*secondaryIndex, error) {
+	for _, x := range t.indexes {
+		if x.name == name {
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: '%s' of table '%s'", ErrIndexNotFound, name, t.name)
+}
+
+func (t *table) determinePartition(sha256key []byte) *partition {
+	return t.partitions[t.partitionId(sha256key)]
+}
+
+func (t *table) partitionId(sha256key []byte) int {
+	return partitionId(sha256key, len(t.partitions))
+}
+
+// partitionId returns the id of the partition of the key among n partitions.
+func partitionId(sha256key []byte, n int) int {
+	v := binary.BigEndian.Uint32(sha256key[:4])
+	id := int(v) % n
+	// partition id start with 1
+	return id + 1
+}
@@ -0,0 +1,342 @@
+package tinyamodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTables(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-tables")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	db, err := New(dir, c)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	s := func(v string) *string { return &v }
+	create := func(name string, partitions uint8, keys ...string) error {
+		input := &CreateTableInput{TableName: name, PartitionNum: partitions}
+		for i, key := range keys {
+			keyType, attributeType := types.KeyTypeHash, types.ScalarAttributeTypeS
+			if i == 1 {
+				keyType, attributeType = types.KeyTypeRange, types.ScalarAttributeTypeN
+			}
+			input.KeySchema = append(input.KeySchema, types.KeySchemaElement{AttributeName: s(key), KeyType: keyType})
+			input.AttributeDefinitions = append(input.AttributeDefinitions, types.AttributeDefinition{AttributeName: s(key), AttributeType: attributeType})
+		}
+		_, err := db.CreateTable(ctx, input)
+		return err
+	}
+	require.NoError(t, create("users", 2, "id"))
+	require.NoError(t, create("orders", 3, "user", "no"))
+	require.ErrorIs(t, create("users", 2, "id"), ErrTableExists)
+	require.ErrorIs(t, create("u", 2, "id"), ErrInvalidTableName)
+	require.ErrorIs(t, create("a/b", 2, "id"), ErrInvalidTableName)
+	require.ErrorIs(t, create("empty", 2), ErrInvalidKeySchema)
+	_, err = db.CreateTable(ctx, &CreateTableInput{
+		TableName:            "undefined",
+		KeySchema:            []types.KeySchemaElement{{AttributeName: s("id"), KeyType: types.KeyTypeHash}},
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: s("pk"), AttributeType: types.ScalarAttributeTypeS}},
+	})
+	require.ErrorIs(t, err, ErrInvalidKeySchema)
+	_, err = os.Stat(filepath.Join(dir, "users", tableMetadataName))
+	require.NoError(t, err)
+
+	// the same key in each table
+	user := map[string]types.AttributeValue{
+		"id":   &types.AttributeValueMemberS{Value: "1"},
+		"name": &types.AttributeValueMemberS{Value: "Taro"},
+	}
+	_, err = db.PutItem(ctx, &PutItemInput{TableName: "users", Item: user})
+	require.NoError(t, err)
+	for i := range 3 {
+		_, err = db.PutItem(ctx, &PutItemInput{TableName: "orders", Item: map[string]types.AttributeValue{
+			"user": &types.AttributeValueMemberS{Value: "1"},
+			"no":   &types.AttributeValueMemberN{Value: fmt.Sprint(i)},
+		}})
+		require.NoError(t, err)
+	}
+	_, err = db.PutItem(ctx, &PutItemInput{TableName: "orders", Item: user})
+	require.ErrorIs(t, err, ErrNotFoundPartitionKey)
+	_, err = db.PutItem(ctx, &PutItemInput{TableName: "unknown", Item: user})
+	require.ErrorIs(t, err, ErrTableNotFound)
+	_, err = db.GetItem(ctx, &GetItemInput{Key: user})
+	require.ErrorIs(t, err, ErrTableNotFound)
+
+	query, err := db.Query(ctx, &QueryInput{
+		TableName:                 "orders",
+		KeyConditionExpression:    "#u = :u",
+		ExpressionAttributeNames:  map[string]string{"#u": "user"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":u": &types.AttributeValueMemberS{Value: "1"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int32(3), query.Count)
+
+	// transaction across tables
+	_, err = db.TransactWriteItems(ctx, &TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Delete: &types.Delete{TableName: s("users"), Key: map[string]types.AttributeValue{"id": user["id"]}}},
+		{Delete: &types.Delete{TableName: s("orders"), Key: map[string]types.AttributeValue{
+			"user": &types.AttributeValueMemberS{Value: "1"},
+			"no":   &types.AttributeValueMemberN{Value: "0"},
+		}}},
+	}})
+	require.NoError(t, err)
+
+	desc, err := db.DescribeTable(ctx, &DescribeTableInput{TableName: "orders"})
+	require.NoError(t, err)
+	require.Equal(t, "orders", *desc.Table.TableName)
+	require.Equal(t, types.TableStatusActive, desc.Table.TableStatus)
+	require.Equal(t, int64(2), *desc.Table.ItemCount)
+	require.Equal(t, []types.KeySchemaElement{
+		{AttributeName: s("user"), KeyType: types.KeyTypeHash},
+		{AttributeName: s("no"), KeyType: types.KeyTypeRange},
+	}, desc.Table.KeySchema)
+	require.NotNil(t, desc.Table.CreationDateTime)
+	_, err = db.DescribeTable(ctx, &DescribeTableInput{TableName: "unknown"})
+	require.ErrorIs(t, err, ErrTableNotFound)
+
+	// list in pages
+	require.NoError(t, create("accounts", 1, "id"))
+	list, err := db.ListTables(ctx, &ListTablesInput{Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"accounts", "orders"}, list.TableNames)
+	require.Equal(t, "orders", list.LastEvaluatedTableName)
+	list, err = db.ListTables(ctx, &ListTablesInput{ExclusiveStartTableName: list.LastEvaluatedTableName, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"users"}, list.TableNames)
+	require.Empty(t, list.LastEvaluatedTableName)
+
+	// reopen with the schemas of the tables
+	require.NoError(t, db.Close())
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+	list, err = db.ListTables(ctx, &ListTablesInput{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"accounts", "orders", "users"}, list.TableNames)
+	got, err := db.GetItem(ctx, &GetItemInput{TableName: "orders", Key: map[string]types.AttributeValue{
+		"user": &types.AttributeValueMemberS{Value: "1"},
+		"no":   &types.AttributeValueMemberN{Value: "2.0"},
+	}})
+	require.NoError(t, err)
+	require.NotNil(t, got.Item)
+
+	tbl, err := db.table("orders")
+	require.NoError(t, err)
+	require.Len(t, tbl.partitions, 3)
+
+	// delete after the use of the table in progress ends
+	type result struct {
+		output *DeleteTableOutput
+		err    error
+	}
+	done := make(chan result)
+	go func() {
+		output, err := db.DeleteTable(ctx, &DeleteTableInput{TableName: "orders"})
+		done <- result{output, err}
+	}()
+	select {
+	case <-done:
+		t.Fatal("the table in use is deleted")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// the other tables are used while waiting
+	_, err = db.GetItem(ctx, &GetItemInput{TableName: "users", Key: map[string]types.AttributeValue{"id": user["id"]}})
+	require.NoError(t, err)
+	_, err = db.table("orders")
+	require.ErrorIs(t, err, ErrTableNotFound)
+	tbl.release()
+	deleted := <-done
+	require.NoError(t, deleted.err)
+	require.Equal(t, types.TableStatusDeleting, deleted.output.TableDescription.TableStatus)
+	require.ErrorIs(t, tbl.acquire(), ErrTableNotFound)
+	_, err = os.Stat(filepath.Join(dir, "orders"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = db.GetItem(ctx, &GetItemInput{TableName: "orders", Key: map[string]types.AttributeValue{
+		"user": &types.AttributeValueMemberS{Value: "1"},
+		"no":   &types.AttributeValueMemberN{Value: "2"},
+	}})
+	require.ErrorIs(t, err, ErrTableNotFound)
+	_, err = db.DeleteTable(ctx, &DeleteTableInput{TableName: "orders"})
+	require.ErrorIs(t, err, ErrTableNotFound)
+
+	// recreated empty
+	require.NoError(t, create("orders", 1, "user", "no"))
+	desc, err = db.DescribeTable(ctx, &DescribeTableInput{TableName: "orders"})
+	require.NoError(t, err)
+	require.Zero(t, *desc.Table.ItemCount)
+}
+
+func TestTableMetadata(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-table-metadata")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Partition.Num = 4
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.Name = "users"
+	c.Table.PartitionKey = "pk"
+	c.Table.SortKey = "sk"
+	c.Table.SortKeyType = types.ScalarAttributeTypeN
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	ctx := context.Background()
+	item := map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: "USER#1"},
+		"sk": &types.AttributeValueMemberN{Value: "1"},
+	}
+	_, err = db.PutItem(ctx, &PutItemInput{Item: item})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	data, err := os.ReadFile(filepath.Join(dir, "users", tableMetadataName))
+	require.NoError(t, err)
+	var m tableMetadata
+	require.NoError(t, json.Unmarshal(data, &m))
+	require.Equal(t, tableFormatVersion, m.FormatVersion)
+	require.Equal(t, "pk", m.PartitionKey)
+	require.Equal(t, types.ScalarAttributeTypeS, m.PartitionKeyType)
+	require.Equal(t, "sk", m.SortKey)
+	require.Equal(t, types.ScalarAttributeTypeN, m.SortKeyType)
+	require.Equal(t, 4, m.Partitions)
+	require.Equal(t, uint64(1024*1024), m.MaxStoreBytes)
+	require.Equal(t, uint64(1024*1024), m.MaxIndexBytes)
+	require.False(t, m.CreatedAt.IsZero())
+
+	// conflicts
+	for name, modify := range map[string]func(c *Config){
+		"partition key":      func(c *Config) { c.Table.PartitionKey = "id" },
+		"partition key type": func(c *Config) { c.Table.PartitionKeyType = types.ScalarAttributeTypeN },
+		"sort key":           func(c *Config) { c.Table.SortKey = "" },
+		"sort key type":      func(c *Config) { c.Table.SortKeyType = types.ScalarAttributeTypeS },
+		"partition num":      func(c *Config) { c.Partition.Num = 8 },
+		"max store bytes":    func(c *Config) { c.Segment.MaxStoreBytes = 1024 },
+		"max index bytes":    func(c *Config) { c.Segment.MaxIndexBytes = 1024 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := c
+			modify(&c)
+			_, err := New(dir, c)
+			require.ErrorIs(t, err, ErrConfigMismatch)
+		})
+	}
+
+	// defaults from the metadata
+	var empty Config
+	empty.Table.Name = "users"
+	db, err = New(dir, empty)
+	require.NoError(t, err)
+	got, err := db.GetItem(ctx, &GetItemInput{Key: item})
+	require.NoError(t, err)
+	require.Equal(t, item, got.Item)
+	tbl, err := db.table("")
+	require.NoError(t, err)
+	require.Len(t, tbl.partitions, 4)
+	require.Equal(t, uint64(1024*1024), tbl.c.Segment.MaxStoreBytes)
+	tbl.release()
+	// the tables other than Config.Table keep their segment limits
+	pk := "id"
+	_, err = db.CreateTable(ctx, &CreateTableInput{
+		TableName:            "others",
+		KeySchema:            []types.KeySchemaElement{{AttributeName: &pk, KeyType: types.KeyTypeHash}},
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: &pk, AttributeType: types.ScalarAttributeTypeS}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	tbl, err = db.table("others")
+	require.NoError(t, err)
+	require.Equal(t, uint64(defaultMaxStoreBytes), tbl.c.Segment.MaxStoreBytes)
+	require.Equal(t, uint64(defaultMaxIndexBytes), tbl.c.Segment.MaxIndexBytes)
+	tbl.release()
+	require.NoError(t, db.Close())
+
+	// unsupported format
+	m.FormatVersion = tableFormatVersion + 1
+	data, err = json.Marshal(m)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "users", tableMetadataName), data, 0644))
+	_, err = New(dir, c)
+	require.ErrorIs(t, err, ErrTableFormat)
+
+	// partitions without a table are not migrated without Config.Table
+	legacy := filepath.Join(dir, "legacy")
+	require.NoError(t, os.MkdirAll(filepath.Join(legacy, "1"), 0755))
+	_, err = New(legacy, empty)
+	require.ErrorIs(t, err, ErrTableFormat)
+}
+
+func TestMigrateLegacyPartitions(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-migrate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Partition.Num = 3
+	c.Table.PartitionKey = "pk"
+	c.TimeToLive.SweepInterval = -1
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	ctx := context.Background()
+	item := func(pk string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: pk}}
+	}
+	for _, pk := range []string{"A", "B", "C", "D"} {
+		_, err = db.PutItem(ctx, &PutItemInput{Item: item(pk)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+
+	// the partitions written in dir before the tables
+	tdir := filepath.Join(dir, defaultTableName)
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, os.Rename(filepath.Join(tdir, id), filepath.Join(dir, id)))
+	}
+	require.NoError(t, os.RemoveAll(tdir))
+	// interrupted after the first partition is moved
+	require.NoError(t, os.Mkdir(tdir, 0755))
+	require.NoError(t, os.Rename(filepath.Join(dir, "1"), filepath.Join(tdir, "1")))
+
+	var empty Config
+	_, err = New(dir, empty)
+	require.ErrorIs(t, err, ErrTableFormat)
+
+	// the partition num is of the partitions written
+	c.Partition.Num = 0
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	for _, pk := range []string{"A", "B", "C", "D"} {
+		got, err := db.GetItem(ctx, &GetItemInput{Key: item(pk)})
+		require.NoError(t, err)
+		require.Equal(t, item(pk), got.Item)
+	}
+	tables, err := db.ListTables(ctx, &ListTablesInput{})
+	require.NoError(t, err)
+	require.Equal(t, []string{defaultTableName}, tables.TableNames)
+	require.NoError(t, db.Close())
+	_, err = os.Stat(filepath.Join(dir, "2"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// opened as a table
+	db, err = New(dir, empty)
+	require.NoError(t, err)
+	defer db.Close()
+	tbl, err := db.table(defaultTableName)
+	require.NoError(t, err)
+	require.Len(t, tbl.partitions, 3)
+}
@@ -0,0 +1,622 @@
+package tinyamodb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/yyyoichi/tinyamodb/tinyamodb/expression"
+)
+
+const maxTransactItems = 100
+
+var (
+	ErrTransactSize        = errors.New("too many or no items requested in a transaction")
+	ErrInvalidTransactItem = errors.New("transact item must have one of ConditionCheck, Put, Delete or Update")
+)
+
+// cancellation reason codes of *types.TransactionCanceledException.
+const (
+	cancellationNone                   = "None"
+	cancellationConditionalCheckFailed = "ConditionalCheckFailed"
+	cancellationTransactionConflict    = "TransactionConflict"
+)
+
+// transactKey is the key of an item in a table.
+type transactKey struct {
+	t   *table
+	key *tinyamodbItem
+}
+
+// id returns the id of the item unique among the tables.
+func (k transactKey) id() string {
+	return k.t.name + "/" + k.key.strSha256Key
+}
+
+// transactWrite is an action of TransactWriteItems.
+type transactWrite struct {
+	transactKey
+	cond *expression.Condition
+	rv   types.ReturnValuesOnConditionCheckFailure
+	// write returns the item to write, or nil to delete it. nil write is a condition check.
+	write func(old Item) (*tinyamodbItem, error)
+}
+
+// TransactWriteItems writes the items all or nothing.
+// the partitions are locked in the order of their tables and ids, and all the conditions and the items are checked before writing.
+// the writes are logged as a transaction record first and are on the disk before the commit is logged,
+// so they are completed on open after a crash.
+// it returns *types.TransactionCanceledException with the reasons per item if a condition fails
+// or an item is written by another transaction.
+func (db *Db) TransactWriteItems(ctx context.Context, input *TransactWriteItemsInput) (*TransactWriteItemsOutput, error) {
+	if len(input.TransactItems) == 0 || len(input.TransactItems) > maxTransactItems {
+		return nil, ErrTransactSize
+	}
+	actions := make([]transactWrite, len(input.TransactItems))
+	seen := make(map[string]struct{}, len(input.TransactItems))
+	for i, ti := range input.TransactItems {
+		a, err := db.parseTransactWrite(ti)
+		if err != nil {
+			return nil, err
+		}
+		defer a.t.release()
+		if _, found := seen[a.id()]; found {
+			return nil, ErrDuplicateKeys
+		}
+		seen[a.id()] = struct{}{}
+		actions[i] = a
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]transactKey, len(actions))
+	for i, a := range actions {
+		keys[i] = a.transactKey
+	}
+	unlock, err := db.lockTransaction(keys, true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// check all conditions before writing.
+	reasons := make([]types.CancellationReason, len(actions))
+	var requests []transactRequest
+	canceled := false
+	unixNano := time.Now().UnixNano()
+	for i, a := range actions {
+		p := a.t.determinePartition(a.key.sha256PartitionKey)
+		old, err := p.get(a.key)
+		if err != nil {
+			return nil, err
+		}
+		// the expired items are not found by the conditions as by the reads
+		current := old
+		if old != nil && a.t.expiry(nil).expired(old.(*tinyamodbItem).Item) {
+			current = nil
+		}
+		if err := checkCondition(a.cond, current, a.rv); err != nil {
+			var ccf *types.ConditionalCheckFailedException
+			if !errors.As(err, &ccf) {
+				return nil, err
+			}
+			reasons[i] = cancellationReason(cancellationConditionalCheckFailed)
+			reasons[i].Message = ccf.Message
+			reasons[i].Item = ccf.Item
+			canceled = true
+			continue
+		}
+		reasons[i] = cancellationReason(cancellationNone)
+		if a.write == nil {
+			continue
+		}
+		item, err := a.write(old)
+		if err != nil {
+			return nil, err
+		}
+		if item == nil {
+			a.key.UnixNano = unixNano
+			requests = append(requests, transactRequest{t: a.t, writeRequest: writeRequest{item: a.key, delete: true}})
+			continue
+		}
+		item.UnixNano = unixNano
+		// a write failing after the transaction is logged cannot be undone.
+		if err := p.check(item); err != nil {
+			return nil, err
+		}
+		requests = append(requests, transactRequest{t: a.t, writeRequest: writeRequest{item: item}})
+	}
+	if canceled {
+		return nil, newTransactionCanceled(reasons)
+	}
+
+	id, err := db.txns.Begin(requests, unixNano)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range requests {
+		if err := r.t.determinePartition(r.item.(*tinyamodbItem).sha256PartitionKey).apply(r.writeRequest); err != nil {
+			// the transaction is completed on the next open.
+			return nil, err
+		}
+	}
+	// the writes are on the disk before the commit, which may truncate the log.
+	if err := syncTransaction(requests); err != nil {
+		return nil, err
+	}
+	if err := db.txns.Commit(id); err != nil {
+		return nil, err
+	}
+	return &TransactWriteItemsOutput{}, nil
+}
+
+// TransactGetItems reads the items as a serializable snapshot.
+// the partitions are read locked in the order of their tables and ids while reading.
+// it returns *types.TransactionCanceledException if an item is written by a transaction in flight.
+func (db *Db) TransactGetItems(ctx context.Context, input *TransactGetItemsInput) (*TransactGetItemsOutput, error) {
+	if len(input.TransactItems) == 0 || len(input.TransactItems) > maxTransactItems {
+		return nil, ErrTransactSize
+	}
+	type transactGet struct {
+		transactKey
+		proj       *expression.Projection
+		attributes map[string]struct{}
+		expiry     expiry
+	}
+	gets := make([]transactGet, len(input.TransactItems))
+	keys := make([]transactKey, len(input.TransactItems))
+	seen := make(map[string]struct{}, len(input.TransactItems))
+	for i, ti := range input.TransactItems {
+		if ti.Get == nil {
+			return nil, ErrInvalidTransactItem
+		}
+		t, err := db.table(stringValue(ti.Get.TableName))
+		if err != nil {
+			return nil, err
+		}
+		defer t.release()
+		key, err := NewTinyamoDbItem(ti.Get.Key, t.c)
+		if err != nil {
+			return nil, err
+		}
+		k := transactKey{t: t, key: key}
+		if _, found := seen[k.id()]; found {
+			return nil, ErrDuplicateKeys
+		}
+		seen[k.id()] = struct{}{}
+		proj, attributes, err := parseProjection(t, stringValue(ti.Get.ProjectionExpression), ti.Get.ExpressionAttributeNames, nil)
+		if err != nil {
+			return nil, err
+		}
+		gets[i] = transactGet{transactKey: k, proj: proj, attributes: attributes, expiry: t.expiry(attributes)}
+		keys[i] = k
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	unlock, err := db.lockTransaction(keys, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	output := &TransactGetItemsOutput{Responses: make([]types.ItemResponse, len(gets))}
+	for i, g := range gets {
+		item := newReadItem(g.key, g.attributes)
+		if _, err := g.t.determinePartition(g.key.sha256PartitionKey).read(item); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if g.expiry.expired(item.Item) {
+			item.Item = nil
+		}
+		if g.proj != nil && item.Item != nil {
+			item.Item = g.proj.Apply(item.Item)
+		}
+		output.Responses[i].Item = item.Item
+	}
+	return output, nil
+}
+
+// parseTransactWrite returns the action of the item on the table in use. the caller must release the table after the use.
+func (db *Db) parseTransactWrite(ti types.TransactWriteItem) (a transactWrite, err error) {
+	var (
+		name     *string
+		expr     *string
+		names    map[string]string
+		values   map[string]types.AttributeValue
+		key      map[string]types.AttributeValue
+		nactions int
+	)
+	if c := ti.ConditionCheck; c != nil {
+		nactions++
+		name, key, expr, names, values, a.rv = c.TableName, c.Key, c.ConditionExpression, c.ExpressionAttributeNames, c.ExpressionAttributeValues, c.ReturnValuesOnConditionCheckFailure
+	}
+	if d := ti.Delete; d != nil {
+		nactions++
+		name, key, expr, names, values, a.rv = d.TableName, d.Key, d.ConditionExpression, d.ExpressionAttributeNames, d.ExpressionAttributeValues, d.ReturnValuesOnConditionCheckFailure
+	}
+	if p := ti.Put; p != nil {
+		nactions++
+		name, key, expr, names, values, a.rv = p.TableName, p.Item, p.ConditionExpression, p.ExpressionAttributeNames, p.ExpressionAttributeValues, p.ReturnValuesOnConditionCheckFailure
+	}
+	if u := ti.Update; u != nil {
+		nactions++
+		name, key, expr, names, values, a.rv = u.TableName, u.Key, u.ConditionExpression, u.ExpressionAttributeNames, u.ExpressionAttributeValues, u.ReturnValuesOnConditionCheckFailure
+	}
+	if nactions != 1 {
+		return a, ErrInvalidTransactItem
+	}
+
+	a.t, err = db.table(stringValue(name))
+	if err != nil {
+		return a, err
+	}
+	defer func() {
+		if err != nil {
+			a.t.release()
+		}
+	}()
+	a.key, err = NewTinyamoDbItem(key, a.t.c)
+	if err != nil {
+		return a, err
+	}
+	a.cond, err = parseCondition(stringValue(expr), names, values)
+	if err != nil {
+		return a, err
+	}
+	switch {
+	case ti.ConditionCheck != nil:
+		if a.cond == nil {
+			return a, fmt.Errorf("%w: ConditionCheck needs ConditionExpression", ErrInvalidTransactItem)
+		}
+	case ti.Delete != nil:
+		a.write = func(old Item) (*tinyamodbItem, error) {
+			return nil, nil
+		}
+	case ti.Put != nil:
+		item := a.key
+		a.write = func(old Item) (*tinyamodbItem, error) {
+			return item, nil
+		}
+	case ti.Update != nil:
+		var update *expression.Update
+		if expr := stringValue(ti.Update.UpdateExpression); expr != "" {
+			update, err = expression.ParseUpdate(expr, names, values)
+			if err != nil {
+				return a, err
+			}
+		}
+		t, key := a.t, a.key
+		a.write = func(old Item) (*tinyamodbItem, error) {
+			return t.applyUpdate(key, update, old)
+		}
+	}
+	return a, nil
+}
+
+// lockTransaction locks the partitions of the keys in the order of their tables and ids
+// and returns the function to unlock them.
+// it returns *types.TransactionCanceledException if some keys are in flight in another write transaction.
+// the keys of a write transaction are registered as in flight until unlocked.
+func (db *Db) lockTransaction(keys []transactKey, write bool) (unlock func(), err error) {
+	strKeys := make([]string, len(keys))
+	for i, key := range keys {
+		strKeys[i] = key.id()
+	}
+	if conflicts := db.txns.Acquire(strKeys, write); len(conflicts) > 0 {
+		reasons := make([]types.CancellationReason, len(keys))
+		for i := range reasons {
+			reasons[i] = cancellationReason(cancellationNone)
+		}
+		for _, i := range conflicts {
+			reasons[i] = cancellationReason(cancellationTransactionConflict)
+			msg := "Transaction is ongoing for the item"
+			reasons[i].Message = &msg
+		}
+		return nil, newTransactionCanceled(reasons)
+	}
+
+	type lock struct {
+		t  *table
+		id int
+	}
+	var locks []lock
+	for _, key := range keys {
+		locks = append(locks, lock{t: key.t, id: key.t.partitionId(key.key.sha256PartitionKey)})
+	}
+	compare := func(a, b lock) int {
+		if c := strings.Compare(a.t.name, b.t.name); c != 0 {
+			return c
+		}
+		return a.id - b.id
+	}
+	slices.SortFunc(locks, compare)
+	locks = slices.CompactFunc(locks, func(a, b lock) bool { return compare(a, b) == 0 })
+	for _, l := range locks {
+		if write {
+			l.t.partitions[l.id].mu.Lock()
+		} else {
+			l.t.partitions[l.id].mu.RLock()
+		}
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			l := locks[i]
+			if write {
+				l.t.partitions[l.id].mu.Unlock()
+			} else {
+				l.t.partitions[l.id].mu.RUnlock()
+			}
+		}
+		if write {
+			db.txns.Release(strKeys)
+		}
+	}, nil
+}
+
+// syncTransaction writes the partitions written by the requests to the disk.
+// the caller must hold the locks of the partitions.
+func syncTransaction(requests []transactRequest) error {
+	ids := make(map[*table][]int)
+	for _, r := range requests {
+		id := r.t.partitionId(r.item.(*tinyamodbItem).sha256PartitionKey)
+		if !slices.Contains(ids[r.t], id) {
+			ids[r.t] = append(ids[r.t], id)
+		}
+	}
+	for t, ids := range ids {
+		if err := t.sync(ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cancellationReason(code string) types.CancellationReason {
+	return types.CancellationReason{Code: &code}
+}
+
+func newTransactionCanceled(reasons []types.CancellationReason) error {
+	codes := make([]string, len(reasons))
+	for i, r := range reasons {
+		codes[i] = stringValue(r.Code)
+	}
+	msg := fmt.Sprintf("Transaction cancelled, please refer cancellation reasons for specific reasons [%s]", strings.Join(codes, ", "))
+	return &types.TransactionCanceledException{Message: &msg, CancellationReasons: reasons}
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// transactions tracks the keys of the transactions in flight,
+// and logs the writes of the transactions to complete them after a crash.
+//
+// the log has the records [len][data] like a store. the data of a record begins a transaction with its writes,
+// or commits the transaction. the log is truncated when no transaction is in flight.
+type transactions struct {
+	mu       sync.Mutex
+	file     *os.File
+	inflight map[string]struct{}
+	seq      uint64
+	// pending is the number of the transactions begun and not committed.
+	pending int
+}
+
+// transactRequest is a write request to a table in a transaction.
+type transactRequest struct {
+	t *table
+	writeRequest
+}
+
+// transactionWrite is a write in a transaction record.
+type transactionWrite struct {
+	table    string
+	item     map[string]types.AttributeValue
+	delete   bool
+	unixNano int64
+}
+
+const transactionLogName = "transactions.log"
+
+func newTransactions(dir string) (*transactions, error) {
+	f, err := os.OpenFile(filepath.Join(dir, transactionLogName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &transactions{
+		file:     f,
+		inflight: make(map[string]struct{}),
+	}, nil
+}
+
+// Acquire returns the indexes of the keys in flight. if there are none and register is true,
+// it registers the keys as in flight.
+func (t *transactions) Acquire(keys []string, register bool) (conflicts []int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, key := range keys {
+		if _, found := t.inflight[key]; found {
+			conflicts = append(conflicts, i)
+		}
+	}
+	if len(conflicts) > 0 || !register {
+		return conflicts
+	}
+	for _, key := range keys {
+		t.inflight[key] = struct{}{}
+	}
+	return nil
+}
+
+func (t *transactions) Release(keys []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range keys {
+		delete(t.inflight, key)
+	}
+}
+
+// Begin logs the writes of a transaction durably and returns the id of the transaction.
+func (t *transactions) Begin(requests []transactRequest, unixNano int64) (uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.seq++
+	writes := make([]types.AttributeValue, len(requests))
+	for i, r := range requests {
+		item := r.item.(*tinyamodbItem)
+		table := &types.AttributeValueMemberS{Value: r.t.name}
+		if r.delete {
+			writes[i] = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"table":  table,
+				"delete": &types.AttributeValueMemberM{Value: item.Item},
+			}}
+		} else {
+			writes[i] = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"table": table,
+				"put":   &types.AttributeValueMemberM{Value: item.Item},
+			}}
+		}
+	}
+	record := map[string]types.AttributeValue{
+		"id":     &types.AttributeValueMemberN{Value: strconv.FormatUint(t.seq, 10)},
+		"writes": &types.AttributeValueMemberL{Value: writes},
+	}
+	if err := t.append(record, unixNano); err != nil {
+		return 0, err
+	}
+	t.pending++
+	return t.seq, nil
+}
+
+// Commit logs the commit of the transaction.
+func (t *transactions) Commit(id uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	record := map[string]types.AttributeValue{
+		"id": &types.AttributeValueMemberN{Value: strconv.FormatUint(id, 10)},
+	}
+	if err := t.append(record, time.Now().UnixNano()); err != nil {
+		return err
+	}
+	t.pending--
+	if t.pending == 0 {
+		return t.file.Truncate(0)
+	}
+	return nil
+}
+
+func (t *transactions) append(record map[string]types.AttributeValue, unixNano int64) error {
+	var data bytes.Buffer
+	var e encoder
+	if err := e.Encode(&types.AttributeValueMemberM{Value: record}, unixNano, &data); err != nil {
+		return err
+	}
+	b := enc.AppendUint64(nil, uint64(data.Len()))
+	if _, err := t.file.Write(append(b, data.Bytes()...)); err != nil {
+		return err
+	}
+	return t.file.Sync()
+}
+
+// Recover returns the writes of the transactions not committed in the order of the transactions.
+// a torn record at the end of the log is ignored since its transaction has written nothing.
+func (t *transactions) Recover() ([]transactionWrite, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(t.file)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		ids     []string
+		pending = make(map[string][]transactionWrite)
+	)
+	for len(data) >= lenWidth {
+		n := enc.Uint64(data)
+		if uint64(len(data)-lenWidth) < n {
+			break
+		}
+		var d decoder
+		av, unixNano, err := d.Decode(bytes.NewReader(data[lenWidth : lenWidth+n]))
+		if err != nil {
+			break
+		}
+		data = data[lenWidth+n:]
+
+		record, ok := av.(*types.AttributeValueMemberM)
+		if !ok {
+			return nil, ErrCannotUnmarshal
+		}
+		idv, ok := record.Value["id"].(*types.AttributeValueMemberN)
+		if !ok {
+			return nil, ErrCannotUnmarshal
+		}
+		id := idv.Value
+		l, found := record.Value["writes"].(*types.AttributeValueMemberL)
+		if !found {
+			delete(pending, id)
+			continue
+		}
+		var writes []transactionWrite
+		for _, w := range l.Value {
+			m, ok := w.(*types.AttributeValueMemberM)
+			if !ok {
+				return nil, ErrCannotUnmarshal
+			}
+			w := m.Value
+			table, ok := w["table"].(*types.AttributeValueMemberS)
+			if !ok {
+				return nil, ErrCannotUnmarshal
+			}
+			if item, found := w["put"].(*types.AttributeValueMemberM); found {
+				writes = append(writes, transactionWrite{table: table.Value, item: item.Value, unixNano: unixNano})
+			} else if key, found := w["delete"].(*types.AttributeValueMemberM); found {
+				writes = append(writes, transactionWrite{table: table.Value, item: key.Value, delete: true, unixNano: unixNano})
+			}
+		}
+		ids = append(ids, id)
+		pending[id] = writes
+	}
+
+	var writes []transactionWrite
+	for _, id := range ids {
+		writes = append(writes, pending[id]...)
+	}
+	return writes, nil
+}
+
+// Reset truncates the log after the recovery.
+func (t *transactions) Reset() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = 0
+	return t.file.Truncate(0)
+}
+
+func (t *transactions) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.file.Close()
+}
@@ -0,0 +1,448 @@
+package tinyamodb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactWriteItems(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-transact-write")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.PartitionKey = "pk"
+	db, err := New(dir, c)
+	require.NoError(t, err)
+
+	s := func(v string) *types.AttributeValueMemberS {
+		return &types.AttributeValueMemberS{Value: v}
+	}
+	n := func(v string) *types.AttributeValueMemberN {
+		return &types.AttributeValueMemberN{Value: v}
+	}
+	str := func(v string) *string {
+		return &v
+	}
+	key := func(pk string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{"pk": s(pk)}
+	}
+	get := func(pk string) map[string]types.AttributeValue {
+		output, err := db.GetItem(context.Background(), &GetItemInput{Key: key(pk)})
+		require.NoError(t, err)
+		return output.Item
+	}
+	for pk, balance := range map[string]string{"A": "100", "B": "0", "C": "0"} {
+		_, err := db.PutItem(context.Background(), &PutItemInput{Item: map[string]types.AttributeValue{
+			"pk":      s(pk),
+			"balance": n(balance),
+		}})
+		require.NoError(t, err)
+	}
+	transfer := func(amount string) []types.TransactWriteItem {
+		values := map[string]types.AttributeValue{":amount": n(amount)}
+		return []types.TransactWriteItem{
+			{Update: &types.Update{
+				Key:                                 key("A"),
+				UpdateExpression:                    str("SET balance = balance - :amount"),
+				ConditionExpression:                 str("balance >= :amount"),
+				ExpressionAttributeValues:           values,
+				ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+			}},
+			{Update: &types.Update{
+				Key:                       key("B"),
+				UpdateExpression:          str("SET balance = balance + :amount"),
+				ExpressionAttributeValues: values,
+			}},
+			{ConditionCheck: &types.ConditionCheck{
+				Key:                 key("C"),
+				ConditionExpression: str("attribute_exists(pk)"),
+			}},
+			{Put: &types.Put{
+				Item:                map[string]types.AttributeValue{"pk": s("LOG#" + amount), "amount": n(amount)},
+				ConditionExpression: str("attribute_not_exists(pk)"),
+			}},
+			{Delete: &types.Delete{Key: key("D")}},
+		}
+	}
+
+	_, err = db.TransactWriteItems(context.Background(), &TransactWriteItemsInput{TransactItems: transfer("30")})
+	require.NoError(t, err)
+	require.Equal(t, n("70"), get("A")["balance"])
+	require.Equal(t, n("30"), get("B")["balance"])
+	require.NotNil(t, get("LOG#30"))
+
+	// nothing is written when a condition fails
+	_, err = db.TransactWriteItems(context.Background(), &TransactWriteItemsInput{TransactItems: transfer("80")})
+	var canceled *types.TransactionCanceledException
+	require.ErrorAs(t, err, &canceled)
+	require.Len(t, canceled.CancellationReasons, 5)
+	require.Equal(t, "ConditionalCheckFailed", *canceled.CancellationReasons[0].Code)
+	require.Equal(t, get("A"), canceled.CancellationReasons[0].Item)
+	require.Equal(t, "None", *canceled.CancellationReasons[1].Code)
+	require.Equal(t, n("70"), get("A")["balance"])
+	require.Equal(t, n("30"), get("B")["balance"])
+	require.Nil(t, get("LOG#80"))
+
+	_, err = db.TransactWriteItems(context.Background(), &TransactWriteItemsInput{TransactItems: transfer("30")})
+	require.ErrorAs(t, err, &canceled)
+	require.Equal(t, "ConditionalCheckFailed", *canceled.CancellationReasons[3].Code)
+	require.Nil(t, canceled.CancellationReasons[3].Item)
+
+	// an item in another transaction
+	b, err := NewTinyamoDbItem(key("B"), c)
+	require.NoError(t, err)
+	require.Empty(t, db.txns.Acquire([]string{"default/" + b.strSha256Key}, true))
+	_, err = db.TransactWriteItems(context.Background(), &TransactWriteItemsInput{TransactItems: transfer("10")})
+	require.ErrorAs(t, err, &canceled)
+	require.Equal(t, "None", *canceled.CancellationReasons[0].Code)
+	require.Equal(t, "TransactionConflict", *canceled.CancellationReasons[1].Code)
+	db.txns.Release([]string{"default/" + b.strSha256Key})
+
+	// invalid requests
+	_, err = db.TransactWriteItems(context.Background(), &TransactWriteItemsInput{})
+	require.ErrorIs(t, err, ErrTransactSize)
+	_, err = db.TransactWriteItems(context.Background(), &TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{{}}})
+	require.ErrorIs(t, err, ErrInvalidTransactItem)
+	_, err = db.TransactWriteItems(context.Background(), &TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{ConditionCheck: &types.ConditionCheck{Key: key("A")}},
+	}})
+	require.ErrorIs(t, err, ErrInvalidTransactItem)
+	_, err = db.TransactWriteItems(context.Background(), &TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Delete: &types.Delete{Key: key("A")}},
+		{Put: &types.Put{Item: key("A")}},
+	}})
+	require.ErrorIs(t, err, ErrDuplicateKeys)
+
+	// crash after the transaction record is written
+	a, err := NewTinyamoDbItem(map[string]types.AttributeValue{"pk": s("A"), "balance": n("0")}, c)
+	require.NoError(t, err)
+	tbl, err := db.table("")
+	require.NoError(t, err)
+	_, err = db.txns.Begin([]transactRequest{
+		{t: tbl, writeRequest: writeRequest{item: a}},
+		{t: tbl, writeRequest: writeRequest{item: b, delete: true}},
+	}, a.UnixNano)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	require.Equal(t, n("0"), get("A")["balance"])
+	require.Nil(t, get("B"))
+	fi, err := os.Stat(filepath.Join(dir, transactionLogName))
+	require.NoError(t, err)
+	require.Zero(t, fi.Size())
+
+	// a torn record is ignored
+	f, err := os.OpenFile(filepath.Join(dir, transactionLogName), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 0, 0, 0, 1, 0, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, db.Close())
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, n("0"), get("A")["balance"])
+
+	// crash after the commit. the db is not closed, so nothing is flushed by Close.
+	_, err = db.TransactWriteItems(context.Background(), &TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Put: &types.Put{Item: map[string]types.AttributeValue{"pk": s("A"), "balance": n("5")}}},
+		{Put: &types.Put{Item: map[string]types.AttributeValue{"pk": s("C"), "balance": n("1")}}},
+	}})
+	require.NoError(t, err)
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, n("5"), get("A")["balance"])
+	require.Equal(t, n("1"), get("C")["balance"])
+}
+
+func TestTransactGetItems(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-transact-get")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.Table.PartitionKey = "pk"
+	db, err := New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := func(v string) *types.AttributeValueMemberS {
+		return &types.AttributeValueMemberS{Value: v}
+	}
+	str := func(v string) *string {
+		return &v
+	}
+	key := func(pk string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{"pk": s(pk)}
+	}
+	items := map[string]map[string]types.AttributeValue{}
+	for _, pk := range []string{"A", "B", "C"} {
+		items[pk] = map[string]types.AttributeValue{
+			"pk":      s(pk),
+			"balance": &types.AttributeValueMemberN{Value: "50"},
+		}
+		_, err := db.PutItem(context.Background(), &PutItemInput{Item: items[pk]})
+		require.NoError(t, err)
+	}
+
+	output, err := db.TransactGetItems(context.Background(), &TransactGetItemsInput{TransactItems: []types.TransactGetItem{
+		{Get: &types.Get{Key: key("C")}},
+		{Get: &types.Get{Key: key("X")}},
+		{Get: &types.Get{Key: key("A"), ProjectionExpression: str("#b"), ExpressionAttributeNames: map[string]string{"#b": "balance"}}},
+	}})
+	require.NoError(t, err)
+	require.Len(t, output.Responses, 3)
+	require.Equal(t, items["C"], output.Responses[0].Item)
+	require.Nil(t, output.Responses[1].Item)
+	require.Equal(t, map[string]types.AttributeValue{"balance": items["A"]["balance"]}, output.Responses[2].Item)
+
+	// an item in a write transaction
+	b, err := NewTinyamoDbItem(key("B"), c)
+	require.NoError(t, err)
+	require.Empty(t, db.txns.Acquire([]string{"default/" + b.strSha256Key}, true))
+	_, err = db.TransactGetItems(context.Background(), &TransactGetItemsInput{TransactItems: []types.TransactGetItem{
+		{Get: &types.Get{Key: key("A")}},
+		{Get: &types.Get{Key: key("B")}},
+	}})
+	var canceled *types.TransactionCanceledException
+	require.ErrorAs(t, err, &canceled)
+	require.Equal(t, "None", *canceled.CancellationReasons[0].Code)
+	require.Equal(t, "TransactionConflict", *canceled.CancellationReasons[1].Code)
+	db.txns.Release([]string{"default/" + b.strSha256Key})
+
+	// the reads never see a half of a transfer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 200 {
+			from, to := "A", "B"
+			if i%2 == 1 {
+				from, to = to, from
+			}
+			values := map[string]types.AttributeValue{":amount": &types.AttributeValueMemberN{Value: "10"}}
+			_, err := db.TransactWriteItems(context.Background(), &TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+				{Update: &types.Update{Key: key(from), UpdateExpression: str("SET balance = balance - :amount"), ExpressionAttributeValues: values}},
+				{Update: &types.Update{Key: key(to), UpdateExpression: str("SET balance = balance + :amount"), ExpressionAttributeValues: values}},
+			}})
+			var canceled *types.TransactionCanceledException
+			if !errors.As(err, &canceled) {
+				assert.NoError(t, err)
+			}
+		}
+	}()
+	for reads := 0; ; reads++ {
+		select {
+		case <-done:
+			require.Positive(t, reads)
+			return
+		default:
+		}
+		output, err := db.TransactGetItems(context.Background(), &TransactGetItemsInput{TransactItems: []types.TransactGetItem{
+			{Get: &types.Get{Key: key("A")}},
+			{Get: &types.Get{Key: key("B")}},
+		}})
+		if errors.As(err, &canceled) {
+			continue
+		}
+		require.NoError(t, err)
+		a, err := strconv.Atoi(output.Responses[0].Item["balance"].(*types.AttributeValueMemberN).Value)
+		require.NoError(t, err)
+		b, err := strconv.Atoi(output.Responses[1].Item["balance"].(*types.AttributeValueMemberN).Value)
+		require.NoError(t, err)
+		require.Equal(t, 100, a+b)
+	}
+}
+
+func TestTransactWriteItemsRecovery(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-transact-recovery")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.TimeToLive.SweepInterval = -1
+	db, err := New(dir, c)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	str := func(v string) *string { return &v }
+	enabled := true
+	_, err = db.CreateTable(ctx, &CreateTableInput{
+		TableName:            "events",
+		KeySchema:            []types.KeySchemaElement{{AttributeName: str("id"), KeyType: types.KeyTypeHash}},
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: str("id"), AttributeType: types.ScalarAttributeTypeS}},
+		StreamSpecification:  &types.StreamSpecification{StreamEnabled: &enabled, StreamViewType: types.StreamViewTypeNewAndOldImages},
+		PartitionNum:         2,
+	})
+	require.NoError(t, err)
+	item := func(id string, v int) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+			"v":  &types.AttributeValueMemberN{Value: strconv.Itoa(v)},
+		}
+	}
+	key := func(id string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}}
+	}
+	for _, id := range []string{"A", "B"} {
+		_, err := db.PutItem(ctx, &PutItemInput{TableName: "events", Item: item(id, 1)})
+		require.NoError(t, err)
+	}
+	_, err = db.TransactWriteItems(ctx, &TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Put: &types.Put{TableName: str("events"), Item: item("A", 2)}},
+		{Delete: &types.Delete{TableName: str("events"), Key: key("B")}},
+	}})
+	require.NoError(t, err)
+
+	history := func(id string) []ItemVersion {
+		output, err := db.GetItemHistory(ctx, key(id), &GetItemHistoryOptions{TableName: "events"})
+		require.NoError(t, err)
+		return output.Versions
+	}
+	// the writes of a transaction have the same time, deletes included
+	at := history("A")[0].Timestamp
+	require.Equal(t, at, history("B")[0].Timestamp)
+
+	countRecords := func() int {
+		var n int
+		for _, shard := range []string{"1", "2"} {
+			it, err := db.GetShardIterator(ctx, &GetShardIteratorInput{TableName: "events", ShardId: shard, ShardIteratorType: ShardIteratorTypeTrimHorizon})
+			require.NoError(t, err)
+			output, err := db.GetRecords(ctx, &GetRecordsInput{ShardIterator: it.ShardIterator})
+			require.NoError(t, err)
+			n += len(output.Records)
+		}
+		return n
+	}
+	require.Equal(t, 4, countRecords())
+
+	// crash after the writes before the commit. the writes are not done again on open.
+	tbl, err := db.table("events")
+	require.NoError(t, err)
+	a, err := NewTinyamoDbItem(item("A", 2), tbl.c)
+	require.NoError(t, err)
+	b, err := NewTinyamoDbItem(key("B"), tbl.c)
+	require.NoError(t, err)
+	a.UnixNano, b.UnixNano = at.UnixNano(), at.UnixNano()
+	_, err = db.txns.Begin([]transactRequest{
+		{t: tbl, writeRequest: writeRequest{item: a}},
+		{t: tbl, writeRequest: writeRequest{item: b, delete: true}},
+	}, at.UnixNano())
+	require.NoError(t, err)
+	tbl.release()
+	require.NoError(t, db.Close())
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Len(t, history("A"), 2)
+	require.Len(t, history("B"), 2)
+	require.Equal(t, 4, countRecords())
+
+	// the expired items are not found by the conditions
+	_, err = db.PutItem(ctx, &PutItemInput{TableName: "events", Item: item("C", 1)})
+	require.NoError(t, err)
+	_, err = db.UpdateTimeToLive(ctx, &UpdateTimeToLiveInput{
+		TableName:               "events",
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{AttributeName: str("v"), Enabled: &enabled},
+	})
+	require.NoError(t, err)
+	_, err = db.TransactWriteItems(ctx, &TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{ConditionCheck: &types.ConditionCheck{TableName: str("events"), Key: key("A"), ConditionExpression: str("attribute_not_exists(id)")}},
+		{Put: &types.Put{TableName: str("events"), Item: item("C", 4102444800), ConditionExpression: str("attribute_not_exists(id)")}},
+	}})
+	require.NoError(t, err)
+}
+
+func TestTransactWriteItemsInvalidIndexKey(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-transact-index-key")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.TimeToLive.SweepInterval = -1
+	db, err := New(dir, c)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	str := func(v string) *string { return &v }
+	_, err = db.CreateTable(ctx, &CreateTableInput{
+		TableName: "events",
+		KeySchema: []types.KeySchemaElement{{AttributeName: str("id"), KeyType: types.KeyTypeHash}},
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: str("id"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: str("g"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName:  str("gidx"),
+			KeySchema:  []types.KeySchemaElement{{AttributeName: str("g"), KeyType: types.KeyTypeHash}},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		}},
+		PartitionNum: 2,
+	})
+	require.NoError(t, err)
+	item := func(id string, g types.AttributeValue) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}, "g": g}
+	}
+	valid := func(id string) map[string]types.AttributeValue {
+		return item(id, &types.AttributeValueMemberS{Value: "G"})
+	}
+	invalid := func(id string) map[string]types.AttributeValue {
+		return item(id, &types.AttributeValueMemberN{Value: "1"})
+	}
+	get := func(id string) map[string]types.AttributeValue {
+		output, err := db.GetItem(ctx, &GetItemInput{TableName: "events", Key: map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}}})
+		require.NoError(t, err)
+		return output.Item
+	}
+
+	// nothing is written nor logged
+	_, err = db.TransactWriteItems(ctx, &TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Put: &types.Put{TableName: str("events"), Item: valid("a")}},
+		{Put: &types.Put{TableName: str("events"), Item: invalid("b")}},
+	}})
+	require.ErrorIs(t, err, ErrInvalidPartitionKeyType)
+	require.Nil(t, get("a"))
+	require.Nil(t, get("b"))
+	require.NoError(t, db.Close())
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	require.Nil(t, get("a"))
+
+	// a write logged but not written is skipped on open
+	tbl, err := db.table("events")
+	require.NoError(t, err)
+	var requests []transactRequest
+	for _, v := range []map[string]types.AttributeValue{valid("c"), invalid("d")} {
+		item, err := NewTinyamoDbItem(v, tbl.c)
+		require.NoError(t, err)
+		requests = append(requests, transactRequest{t: tbl, writeRequest: writeRequest{item: item}})
+	}
+	_, err = db.txns.Begin(requests, time.Now().UnixNano())
+	require.NoError(t, err)
+	tbl.release()
+	require.NoError(t, db.Close())
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, valid("c"), get("c"))
+	require.Nil(t, get("d"))
+}
@@ -0,0 +1,191 @@
+package tinyamodb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/yyyoichi/tinyamodb/tinyamodb/expression"
+)
+
+var ErrInvalidTimeToLive = errors.New("invalid time to live specification")
+
+// defaultSweepInterval is the interval of the sweeps of the expired items without Config.TimeToLive.
+const defaultSweepInterval = time.Minute
+
+// UpdateTimeToLive enables or disables the expiry of the items of the table by the attribute.
+// the attribute has the time of the expiry in epoch seconds as N. items without it do not expire.
+// the expired items are hidden from the reads at once, and deleted by the sweeper as system deletes.
+func (db *Db) UpdateTimeToLive(ctx context.Context, input *UpdateTimeToLiveInput) (*UpdateTimeToLiveOutput, error) {
+	spec := input.TimeToLiveSpecification
+	if spec == nil || spec.Enabled == nil || stringValue(spec.AttributeName) == "" {
+		return nil, fmt.Errorf("%w: AttributeName and Enabled are required", ErrInvalidTimeToLive)
+	}
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	defer t.release()
+	name := stringValue(spec.AttributeName)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case *spec.Enabled && t.timeToLiveAttribute != "":
+		return nil, fmt.Errorf("%w: TTL of table '%s' is already enabled", ErrInvalidTimeToLive, t.name)
+	case !*spec.Enabled && t.timeToLiveAttribute != name:
+		return nil, fmt.Errorf("%w: TTL of table '%s' is not enabled by '%s'", ErrInvalidTimeToLive, t.name, name)
+	}
+	if !*spec.Enabled {
+		name = ""
+	}
+	if err := t.updateMetadata(func(m *tableMetadata) { m.TimeToLiveAttribute = name }); err != nil {
+		return nil, err
+	}
+	t.timeToLiveAttribute = name
+	return &UpdateTimeToLiveOutput{TimeToLiveSpecification: spec}, nil
+}
+
+// DescribeTimeToLive returns the TTL status of the table.
+func (db *Db) DescribeTimeToLive(ctx context.Context, input *DescribeTimeToLiveInput) (*DescribeTimeToLiveOutput, error) {
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	defer t.release()
+	t.mu.RLock()
+	name := t.timeToLiveAttribute
+	t.mu.RUnlock()
+	desc := &types.TimeToLiveDescription{TimeToLiveStatus: types.TimeToLiveStatusDisabled}
+	if name != "" {
+		desc.AttributeName = &name
+		desc.TimeToLiveStatus = types.TimeToLiveStatusEnabled
+	}
+	return &DescribeTimeToLiveOutput{TimeToLiveDescription: desc}, nil
+}
+
+// updateMetadata rewrites the metadata of the table by fn. the caller must hold t.mu.
+func (t *table) updateMetadata(fn func(m *tableMetadata)) error {
+	path := filepath.Join(t.dir, tableMetadataName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var m tableMetadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	fn(&m)
+	if data, err = json.MarshalIndent(m, "", "  "); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// expiry hides the items expired at now.
+type expiry struct {
+	// attribute is the TTL attribute. empty hides nothing.
+	attribute string
+	now       int64
+}
+
+// expiry returns the expiry of the items of the table read now.
+// the TTL attribute is added to the attributes to decode if they are not nil.
+func (t *table) expiry(attributes map[string]struct{}) expiry {
+	t.mu.RLock()
+	e := expiry{attribute: t.timeToLiveAttribute, now: time.Now().Unix()}
+	t.mu.RUnlock()
+	if attributes != nil && e.attribute != "" {
+		attributes[e.attribute] = struct{}{}
+	}
+	return e
+}
+
+// expired reports whether the item has the TTL attribute of the time not after now.
+// the items of an index not projecting the attribute are checked by secondaryIndex.expired.
+func (e expiry) expired(item map[string]types.AttributeValue) bool {
+	if e.attribute == "" {
+		return false
+	}
+	v, ok := item[e.attribute].(*types.AttributeValueMemberN)
+	if !ok {
+		return false
+	}
+	r, ok := expression.ParseNumber(v.Value)
+	return ok && r.Cmp(new(big.Rat).SetInt64(e.now)) <= 0
+}
+
+// sweepExpired deletes the expired items at each interval until db is closed.
+func (db *Db) sweepExpired(interval time.Duration) {
+	defer db.sweeper.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-ticker.C:
+			// the items failed to be deleted are deleted by the next sweep.
+			_, _ = db.sweep()
+		}
+	}
+}
+
+// sweep deletes the expired items of the tables as system deletes, and returns the number of the items deleted.
+// the tables are swept one by one in use, so the tables can be created and deleted while the sweep runs.
+func (db *Db) sweep() (int, error) {
+	db.mu.RLock()
+	names := make([]string, 0, len(db.tables))
+	for name := range db.tables {
+		names = append(names, name)
+	}
+	db.mu.RUnlock()
+	var total int
+	for _, name := range names {
+		n, err := db.sweepTable(name)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// sweepTable deletes the expired items of the table of the name. the table deleted since the sweep started is not swept.
+func (db *Db) sweepTable(name string) (int, error) {
+	t, err := db.table(name)
+	if err != nil {
+		if errors.Is(err, ErrTableNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer t.release()
+	return t.sweep()
+}
+
+// sweep deletes the expired items of the partitions of the table.
+func (t *table) sweep() (int, error) {
+	e := t.expiry(nil)
+	if e.attribute == "" {
+		return 0, nil
+	}
+	var total int
+	for _, p := range t.partitions {
+		n, err := p.Expire(e)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,238 @@
+package tinyamodb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeToLive(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-db-ttl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c Config
+	c.Segment.MaxStoreBytes = 1024 * 1024
+	c.Segment.MaxIndexBytes = 1024 * 1024
+	c.TimeToLive.SweepInterval = -1
+	db, err := New(dir, c)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	s := func(v string) *string { return &v }
+	b := func(v bool) *bool { return &v }
+	_, err = db.CreateTable(ctx, &CreateTableInput{
+		TableName:            "sessions",
+		KeySchema:            []types.KeySchemaElement{{AttributeName: s("id"), KeyType: types.KeyTypeHash}},
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: s("id"), AttributeType: types.ScalarAttributeTypeS}, {AttributeName: s("user"), AttributeType: types.ScalarAttributeTypeS}},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName:  s("user"),
+			KeySchema:  []types.KeySchemaElement{{AttributeName: s("user"), KeyType: types.KeyTypeHash}},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		}, {
+			IndexName:  s("user-keys"),
+			KeySchema:  []types.KeySchemaElement{{AttributeName: s("user"), KeyType: types.KeyTypeHash}},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeKeysOnly},
+		}},
+		PartitionNum: 2,
+	})
+	require.NoError(t, err)
+
+	desc, err := db.DescribeTimeToLive(ctx, &DescribeTimeToLiveInput{TableName: "sessions"})
+	require.NoError(t, err)
+	require.Equal(t, types.TimeToLiveStatusDisabled, desc.TimeToLiveDescription.TimeToLiveStatus)
+	_, err = db.UpdateTimeToLive(ctx, &UpdateTimeToLiveInput{
+		TableName:               "sessions",
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{AttributeName: s("expires"), Enabled: b(true)},
+	})
+	require.NoError(t, err)
+	_, err = db.UpdateTimeToLive(ctx, &UpdateTimeToLiveInput{
+		TableName:               "sessions",
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{AttributeName: s("other"), Enabled: b(true)},
+	})
+	require.ErrorIs(t, err, ErrInvalidTimeToLive)
+
+	now := time.Now().Unix()
+	key := func(id string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}}
+	}
+	put := func(id string, expires types.AttributeValue) {
+		item := key(id)
+		item["user"] = &types.AttributeValueMemberS{Value: "U1"}
+		if expires != nil {
+			item["expires"] = expires
+		}
+		_, err := db.PutItem(ctx, &PutItemInput{TableName: "sessions", Item: item})
+		require.NoError(t, err)
+	}
+	put("expired", &types.AttributeValueMemberN{Value: fmt.Sprint(now - 10)})
+	put("live", &types.AttributeValueMemberN{Value: fmt.Sprint(now + 3600)})
+	put("forever", nil)
+	put("string", &types.AttributeValueMemberS{Value: fmt.Sprint(now - 10)})
+	put("infinite", &types.AttributeValueMemberN{Value: "-Inf"})
+
+	// hidden at once
+	get := func(id string) map[string]types.AttributeValue {
+		output, err := db.GetItem(ctx, &GetItemInput{TableName: "sessions", Key: key(id)})
+		require.NoError(t, err)
+		return output.Item
+	}
+	require.Nil(t, get("expired"))
+	require.NotNil(t, get("live"))
+	require.NotNil(t, get("forever"))
+	require.NotNil(t, get("string"))
+	require.NotNil(t, get("infinite"))
+	ids := func(items []map[string]types.AttributeValue) []string {
+		var ids []string
+		for _, item := range items {
+			ids = append(ids, item["id"].(*types.AttributeValueMemberS).Value)
+		}
+		return ids
+	}
+	scan, err := db.Scan(ctx, &ScanInput{TableName: "sessions", ProjectionExpression: "id"})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"live", "forever", "string", "infinite"}, ids(scan.Items))
+	require.Len(t, scan.Items[0], 1)
+	query := func(index string) []map[string]types.AttributeValue {
+		output, err := db.Query(ctx, &QueryInput{
+			TableName:                 "sessions",
+			IndexName:                 index,
+			KeyConditionExpression:    "#u = :u",
+			ExpressionAttributeNames:  map[string]string{"#u": "user"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":u": &types.AttributeValueMemberS{Value: "U1"}},
+		})
+		require.NoError(t, err)
+		return output.Items
+	}
+	require.ElementsMatch(t, []string{"live", "forever", "string", "infinite"}, ids(query("user")))
+	// the index not projecting the TTL attribute
+	keys := query("user-keys")
+	require.ElementsMatch(t, []string{"live", "forever", "string", "infinite"}, ids(keys))
+	for _, item := range keys {
+		require.NotContains(t, item, "expires")
+	}
+	batch, err := db.BatchGetItem(ctx, &BatchGetItemInput{RequestItems: map[string]types.KeysAndAttributes{
+		"sessions": {Keys: []map[string]types.AttributeValue{key("expired"), key("live")}},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"live"}, ids(batch.Responses["sessions"]))
+	txn, err := db.TransactGetItems(ctx, &TransactGetItemsInput{TransactItems: []types.TransactGetItem{
+		{Get: &types.Get{TableName: s("sessions"), Key: key("expired")}},
+	}})
+	require.NoError(t, err)
+	require.Nil(t, txn.Responses[0].Item)
+
+	// deleted by the sweeper as a system delete
+	deleted := func(id string) deleteKind {
+		tbl, err := db.table("sessions")
+		require.NoError(t, err)
+		item, err := NewTinyamoDbItem(key(id), tbl.c)
+		require.NoError(t, err)
+		data, err := tbl.determinePartition(item.sha256PartitionKey).activeSegment.Read(item.strSha256Key)
+		require.NoError(t, err)
+		var stored tinyamodbItem
+		require.NoError(t, stored.Unmarshal(data))
+		return stored.deleted
+	}
+	// the tables are created while the sweep runs
+	db.mu.RLock()
+	sessions := db.tables["sessions"]
+	db.mu.RUnlock()
+	locked := sessions.partitions[1].mu
+	locked.Lock()
+	type result struct {
+		n   int
+		err error
+	}
+	swept := make(chan result, 1)
+	go func() {
+		n, err := db.sweep()
+		swept <- result{n, err}
+	}()
+	// the sweep waits for the lock of the partition
+	require.Eventually(t, func() bool {
+		sessions.useMu.Lock()
+		defer sessions.useMu.Unlock()
+		return sessions.users == 1
+	}, time.Second, time.Millisecond)
+	created := make(chan error, 1)
+	go func() {
+		_, err := db.CreateTable(ctx, &CreateTableInput{
+			TableName:            "created",
+			KeySchema:            []types.KeySchemaElement{{AttributeName: s("id"), KeyType: types.KeyTypeHash}},
+			AttributeDefinitions: []types.AttributeDefinition{{AttributeName: s("id"), AttributeType: types.ScalarAttributeTypeS}},
+		})
+		created <- err
+	}()
+	select {
+	case err := <-created:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("CreateTable is blocked by the sweep")
+	}
+	locked.Unlock()
+	r := <-swept
+	require.NoError(t, r.err)
+	require.Equal(t, 1, r.n)
+	require.Equal(t, systemDelete, deleted("expired"))
+	_, err = db.DeleteItem(ctx, &DeleteItemInput{TableName: "sessions", Key: key("forever")})
+	require.NoError(t, err)
+	require.Equal(t, userDelete, deleted("forever"))
+	describe, err := db.DescribeTable(ctx, &DescribeTableInput{TableName: "sessions"})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), *describe.Table.ItemCount)
+	require.Equal(t, int64(3), *describe.Table.GlobalSecondaryIndexes[0].ItemCount)
+	require.Equal(t, int64(3), *describe.Table.GlobalSecondaryIndexes[1].ItemCount)
+
+	// reopen
+	require.NoError(t, db.Close())
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	desc, err = db.DescribeTimeToLive(ctx, &DescribeTimeToLiveInput{TableName: "sessions"})
+	require.NoError(t, err)
+	require.Equal(t, types.TimeToLiveStatusEnabled, desc.TimeToLiveDescription.TimeToLiveStatus)
+	require.Equal(t, "expires", *desc.TimeToLiveDescription.AttributeName)
+	require.Nil(t, get("expired"))
+	require.Nil(t, get("forever"))
+	put("expired", &types.AttributeValueMemberN{Value: fmt.Sprint(now - 10)})
+	require.Nil(t, get("expired"))
+
+	// disabled
+	_, err = db.UpdateTimeToLive(ctx, &UpdateTimeToLiveInput{
+		TableName:               "sessions",
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{AttributeName: s("other"), Enabled: b(false)},
+	})
+	require.ErrorIs(t, err, ErrInvalidTimeToLive)
+	_, err = db.UpdateTimeToLive(ctx, &UpdateTimeToLiveInput{
+		TableName:               "sessions",
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{AttributeName: s("expires"), Enabled: b(false)},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, get("expired"))
+	n, err := db.sweep()
+	require.NoError(t, err)
+	require.Zero(t, n)
+	require.NoError(t, db.Close())
+
+	// background sweeper
+	c.TimeToLive.SweepInterval = 10 * time.Millisecond
+	db, err = New(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.UpdateTimeToLive(ctx, &UpdateTimeToLiveInput{
+		TableName:               "sessions",
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{AttributeName: s("expires"), Enabled: b(true)},
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		describe, err := db.DescribeTable(ctx, &DescribeTableInput{TableName: "sessions"})
+		require.NoError(t, err)
+		return *describe.Table.ItemCount == 3
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, systemDelete, deleted("expired"))
+}
@@ -1,6 +1,10 @@
 package tinyamodb
 
-import "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
 
 type PutKeyItemOutput struct {
 }
@@ -13,14 +17,50 @@ type ReadKeyItemOutput struct {
 }
 
 type PutItemInput struct {
-	Item map[string]types.AttributeValue
+	// TableName is the table of the item. empty is the table of Config.Table.
+	TableName string
+	Item      map[string]types.AttributeValue
+	// ConditionExpression is such as 'attribute_not_exists(pk)'.
+	// the item is written only when the current item satisfies it.
+	ConditionExpression                 string
+	ExpressionAttributeNames            map[string]string
+	ExpressionAttributeValues           map[string]types.AttributeValue
+	ReturnValuesOnConditionCheckFailure types.ReturnValuesOnConditionCheckFailure
+	// ReturnValues is NONE or ALL_OLD.
+	ReturnValues types.ReturnValue
 }
 
 type PutItemOutput struct {
+	Attributes map[string]types.AttributeValue
+}
+
+type UpdateItemInput struct {
+	// TableName is the table of the item. empty is the table of Config.Table.
+	TableName string
+	Key       map[string]types.AttributeValue
+	// UpdateExpression is such as 'SET a = :a REMOVE b ADD c :c DELETE d :d'.
+	UpdateExpression                    string
+	ConditionExpression                 string
+	ExpressionAttributeNames            map[string]string
+	ExpressionAttributeValues           map[string]types.AttributeValue
+	ReturnValuesOnConditionCheckFailure types.ReturnValuesOnConditionCheckFailure
+	// ReturnValues is NONE, ALL_OLD, UPDATED_OLD, ALL_NEW or UPDATED_NEW.
+	ReturnValues types.ReturnValue
+}
+
+type UpdateItemOutput struct {
+	Attributes map[string]types.AttributeValue
 }
 
 type GetItemInput struct {
-	Key map[string]types.AttributeValue
+	// TableName is the table of the item. empty is the table of Config.Table.
+	TableName string
+	Key       map[string]types.AttributeValue
+	// ProjectionExpression is such as 'a, b.c, d[1]'.
+	ProjectionExpression     string
+	ExpressionAttributeNames map[string]string
+	// AsOf reads the item as it was at the time. zero reads the latest.
+	AsOf time.Time
 }
 
 type GetItemOutput struct {
@@ -28,8 +68,297 @@ type GetItemOutput struct {
 }
 
 type DeleteItemInput struct {
-	Key map[string]types.AttributeValue
+	// TableName is the table of the item. empty is the table of Config.Table.
+	TableName                           string
+	Key                                 map[string]types.AttributeValue
+	ConditionExpression                 string
+	ExpressionAttributeNames            map[string]string
+	ExpressionAttributeValues           map[string]types.AttributeValue
+	ReturnValuesOnConditionCheckFailure types.ReturnValuesOnConditionCheckFailure
+	// ReturnValues is NONE or ALL_OLD.
+	ReturnValues types.ReturnValue
 }
 
 type DeleteItemOutput struct {
+	Attributes map[string]types.AttributeValue
+}
+
+type QueryInput struct {
+	// TableName is the table of the item. empty is the table of Config.Table.
+	TableName string
+	// IndexName is the secondary index to query. empty queries the table.
+	IndexName string
+	// KeyConditionExpression is such as 'pk = :pk AND begins_with(sk, :prefix)'.
+	KeyConditionExpression string
+	// FilterExpression is applied to the items read by the key condition and Limit.
+	// it cannot contain the key attributes.
+	FilterExpression          string
+	ProjectionExpression      string
+	ExpressionAttributeNames  map[string]string
+	ExpressionAttributeValues map[string]types.AttributeValue
+	// ScanIndexForward is true when nil.
+	ScanIndexForward  *bool
+	Limit             int32
+	ExclusiveStartKey map[string]types.AttributeValue
+}
+
+type QueryOutput struct {
+	Items []map[string]types.AttributeValue
+	// Count is the number of the items after the filter,
+	// and ScannedCount is before the filter.
+	Count            int32
+	ScannedCount     int32
+	LastEvaluatedKey map[string]types.AttributeValue
+}
+
+type ScanInput struct {
+	// TableName is the table of the item. empty is the table of Config.Table.
+	TableName string
+	// FilterExpression is applied to the items read by Limit.
+	FilterExpression          string
+	ProjectionExpression      string
+	ExpressionAttributeNames  map[string]string
+	ExpressionAttributeValues map[string]types.AttributeValue
+	Limit                     int32
+	ExclusiveStartKey         map[string]types.AttributeValue
+	// Segment and TotalSegments split the scan for parallel workers.
+	// Segment is 0 to TotalSegments-1. TotalSegments 0 reads all segments.
+	Segment       int32
+	TotalSegments int32
+}
+
+type ScanOutput struct {
+	Items []map[string]types.AttributeValue
+	// Count is the number of the items after the filter,
+	// and ScannedCount is before the filter.
+	Count        int32
+	ScannedCount int32
+	// LastEvaluatedKey is set when the items are left.
+	// pass it as ExclusiveStartKey to read the next page.
+	LastEvaluatedKey map[string]types.AttributeValue
+}
+
+type BatchGetItemInput struct {
+	// RequestItems are the keys to read by table. they are up to 100 keys without duplicates.
+	RequestItems map[string]types.KeysAndAttributes
+}
+
+type BatchGetItemOutput struct {
+	// Responses are the items found by table in no particular order.
+	Responses map[string][]map[string]types.AttributeValue
+	// UnprocessedKeys are the keys not read. pass them to the next call.
+	UnprocessedKeys map[string]types.KeysAndAttributes
+}
+
+type BatchWriteItemInput struct {
+	// RequestItems are the put or delete requests by table.
+	// they are up to 25 requests without duplicate keys.
+	RequestItems map[string][]types.WriteRequest
+}
+
+type BatchWriteItemOutput struct {
+	// UnprocessedItems are the requests not written. pass them to the next call.
+	UnprocessedItems map[string][]types.WriteRequest
+}
+
+type TransactWriteItemsInput struct {
+	// TransactItems are up to 100 actions without duplicate keys.
+	// each has one of ConditionCheck, Put, Delete or Update. empty TableName is the table of Config.Table.
+	TransactItems []types.TransactWriteItem
+}
+
+type TransactWriteItemsOutput struct {
+}
+
+type TransactGetItemsInput struct {
+	// TransactItems are up to 100 keys without duplicates. empty TableName is the table of Config.Table.
+	TransactItems []types.TransactGetItem
+}
+
+type TransactGetItemsOutput struct {
+	// Responses are in the order of TransactItems. Item is nil if not found.
+	Responses []types.ItemResponse
+}
+
+type CreateTableInput struct {
+	TableName string
+	// KeySchema has a HASH key and an optional RANGE key.
+	KeySchema []types.KeySchemaElement
+	// AttributeDefinitions are the types of the key attributes. S, N or B.
+	AttributeDefinitions []types.AttributeDefinition
+	// GlobalSecondaryIndexes are up to 20 indexes having their own key schemas.
+	// the types of their keys are in AttributeDefinitions.
+	GlobalSecondaryIndexes []types.GlobalSecondaryIndex
+	// LocalSecondaryIndexes are up to 5 indexes having the partition key of the table and another sort key.
+	// the table must have a sort key.
+	LocalSecondaryIndexes []types.LocalSecondaryIndex
+	// StreamSpecification enables the stream of the changes of the items with the view type.
+	StreamSpecification *types.StreamSpecification
+	// PartitionNum is the partition num of the table and its indexes. 0 is Config.Partition.Num.
+	PartitionNum uint8
+}
+
+type CreateTableOutput struct {
+	TableDescription *types.TableDescription
+}
+
+type DeleteTableInput struct {
+	TableName string
+}
+
+type DeleteTableOutput struct {
+	TableDescription *types.TableDescription
+}
+
+type ListTablesInput struct {
+	// ExclusiveStartTableName is LastEvaluatedTableName of the previous call.
+	ExclusiveStartTableName string
+	// Limit is up to 100. 0 is 100.
+	Limit int32
+}
+
+type ListTablesOutput struct {
+	// TableNames are in the order of the names.
+	TableNames             []string
+	LastEvaluatedTableName string
+}
+
+type DescribeTableInput struct {
+	TableName string
+}
+
+type DescribeTableOutput struct {
+	Table *types.TableDescription
+}
+
+type UpdateTimeToLiveInput struct {
+	TableName string
+	// TimeToLiveSpecification has the TTL attribute and whether the TTL is enabled.
+	TimeToLiveSpecification *types.TimeToLiveSpecification
+}
+
+type UpdateTimeToLiveOutput struct {
+	TimeToLiveSpecification *types.TimeToLiveSpecification
+}
+
+type DescribeTimeToLiveInput struct {
+	TableName string
+}
+
+type DescribeTimeToLiveOutput struct {
+	TimeToLiveDescription *types.TimeToLiveDescription
+}
+
+type DescribeStreamInput struct {
+	TableName string
+}
+
+type DescribeStreamOutput struct {
+	StreamDescription *StreamDescription
+}
+
+type StreamDescription struct {
+	TableName      string
+	StreamViewType types.StreamViewType
+	// Shards are the shards of the partitions of the table.
+	Shards []Shard
+}
+
+type Shard struct {
+	ShardId string
+	// StartingSequenceNumber is the oldest sequence number retained. empty if no records are retained.
+	StartingSequenceNumber string
+}
+
+type ShardIteratorType string
+
+const (
+	// ShardIteratorTypeTrimHorizon is the oldest record retained.
+	ShardIteratorTypeTrimHorizon ShardIteratorType = "TRIM_HORIZON"
+	// ShardIteratorTypeLatest is the record written next.
+	ShardIteratorTypeLatest              ShardIteratorType = "LATEST"
+	ShardIteratorTypeAtSequenceNumber    ShardIteratorType = "AT_SEQUENCE_NUMBER"
+	ShardIteratorTypeAfterSequenceNumber ShardIteratorType = "AFTER_SEQUENCE_NUMBER"
+)
+
+type GetShardIteratorInput struct {
+	TableName         string
+	ShardId           string
+	ShardIteratorType ShardIteratorType
+	// SequenceNumber is for AT_SEQUENCE_NUMBER and AFTER_SEQUENCE_NUMBER.
+	SequenceNumber string
+}
+
+type GetShardIteratorOutput struct {
+	ShardIterator string
+}
+
+type GetRecordsInput struct {
+	ShardIterator string
+	// Limit is up to 1000. 0 is 1000.
+	Limit int32
+}
+
+type GetRecordsOutput struct {
+	Records []Record
+	// NextShardIterator reads the records after Records. it is valid after the db is reopened.
+	NextShardIterator string
+}
+
+type OperationType string
+
+const (
+	OperationTypeInsert OperationType = "INSERT"
+	OperationTypeModify OperationType = "MODIFY"
+	OperationTypeRemove OperationType = "REMOVE"
+)
+
+// Record is a write of an item in a stream.
+type Record struct {
+	EventName OperationType
+	// SequenceNumber increases in the order of the writes of the table.
+	SequenceNumber              string
+	ApproximateCreationDateTime time.Time
+	StreamViewType              types.StreamViewType
+	Keys                        map[string]types.AttributeValue
+	// NewImage is the item written. it is for NEW_IMAGE and NEW_AND_OLD_IMAGES.
+	NewImage map[string]types.AttributeValue
+	// OldImage is the item before the write. it is for OLD_IMAGE and NEW_AND_OLD_IMAGES.
+	OldImage map[string]types.AttributeValue
+	// UserIdentity is the service deleting the expired item by TTL. nil is a write by the APIs.
+	UserIdentity *Identity
+}
+
+type Identity struct {
+	PrincipalId string
+	Type        string
+}
+
+type GetItemHistoryOptions struct {
+	// TableName is the table of the item. empty is the table of Config.Table.
+	TableName string
+	// Limit is the max num of the versions returned. 0 is unlimited.
+	Limit int32
+	// ExclusiveStartVersion is LastEvaluatedVersion of the previous call.
+	ExclusiveStartVersion string
+}
+
+type GetItemHistoryOutput struct {
+	// Versions are newest first by Timestamp, and the later written first on the same Timestamp.
+	// the first is the one GetItem reads.
+	Versions []ItemVersion
+	// LastEvaluatedVersion is set if the versions are left.
+	LastEvaluatedVersion string
+}
+
+// ItemVersion is a write of an item stored.
+type ItemVersion struct {
+	Version string
+	// Item is nil if the version is a delete marker.
+	Item      map[string]types.AttributeValue
+	Timestamp time.Time
+	// Deleted reports whether the version is a delete marker.
+	Deleted bool
+	// UserIdentity is the service deleting the expired item by TTL. nil is a write by the APIs.
+	UserIdentity *Identity
 }